@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+)
+
+// auditTimeout is the max duration of posting an audit event, so that an
+// unavailable webhook doesn't delay sessions.
+const auditTimeout = 5 * time.Second
+
+// auditEvent is the JSON event posted to the audit webhook at session start and end.
+type auditEvent struct {
+	Event    string    `json:"event"`
+	Time     time.Time `json:"time"`
+	User     string    `json:"user"`
+	Host     string    `json:"host"`
+	SSHUser  string    `json:"ssh_user,omitempty"`
+	Instance string    `json:"instance"`
+	Zone     string    `json:"zone"`
+	Project  string    `json:"project"`
+	Ticket   string    `json:"ticket,omitempty"`
+	Duration float64   `json:"duration_seconds,omitempty"`
+	ExitCode *int      `json:"exit_code,omitempty"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// auditSession posts the session_start event, or the session_end event if
+// ended, to the audit webhook if configured. Failures are logged, not returned,
+// since they shouldn't prevent connecting.
+func auditSession(ctx context.Context, conf config.Config, inst inventory.Instance, sshUser string, t0 time.Time, ended bool, sessionErr error) {
+	if conf.AuditWebhook == "" {
+		return
+	}
+
+	e := auditEvent{
+		Event:    "session_start",
+		Time:     time.Now().UTC(),
+		SSHUser:  sshUser,
+		Instance: inst.Name,
+		Zone:     inst.TrimZone(),
+		Project:  inst.Project,
+		Ticket:   policyTicket,
+	}
+	if u, err := user.Current(); err == nil {
+		e.User = u.Username
+	}
+	e.Host, _ = os.Hostname()
+	if ended {
+		var (
+			code    int
+			exitErr sessionExit
+		)
+		if errors.As(sessionErr, &exitErr) {
+			code = exitErr.code
+		} else if sessionErr != nil {
+			code = exitCode(sessionErr)
+			e.Error = sessionErr.Error()
+		}
+		e.Event = "session_end"
+		e.Duration = time.Since(t0).Seconds()
+		e.ExitCode = &code
+	}
+
+	if err := postAudit(ctx, conf, e); err != nil {
+		slog.Warn("Failed to post audit event", "event", e.Event, "err", err)
+	}
+}
+
+// postAudit posts the event to the audit webhook, signed with the
+// HMAC-SHA256 of the body in the X-Gssh-Signature header if a secret is configured.
+func postAudit(ctx context.Context, conf config.Config, e auditEvent) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return fmt.Errorf("marshal audit event error: %w", err)
+	}
+
+	// Post the session_end event even if the session was interrupted.
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), auditTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, conf.AuditWebhook, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("new audit request error: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	secret, err := auditSecret(conf)
+	if err != nil {
+		return err
+	}
+	if secret != "" {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write(body)
+		req.Header.Set("X-Gssh-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("post audit event error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("post audit event error: %s", resp.Status)
+	}
+
+	return nil
+}
+
+// auditSecret returns the key signing the audit events from $GSSH_AUDIT_SECRET
+// or the audit_secret_file, which must not be readable by other users.
+func auditSecret(conf config.Config) (string, error) {
+	if s, ok := os.LookupEnv("GSSH_AUDIT_SECRET"); ok {
+		return s, nil
+	} else if conf.AuditSecretFile == "" {
+		return "", nil
+	}
+
+	fi, err := os.Stat(conf.AuditSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("audit secret file error: %w", err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("audit secret file %s is accessible by other users, run `chmod 600 %s`", conf.AuditSecretFile, conf.AuditSecretFile)
+	}
+
+	b, err := os.ReadFile(conf.AuditSecretFile)
+	if err != nil {
+		return "", fmt.Errorf("read audit secret file error: %w", err)
+	}
+
+	return strings.TrimSpace(string(b)), nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"context"
+	"runtime"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// openBrowser opens the URL in the default browser.
+func openBrowser(ctx context.Context, r runner.Runner, url string) error {
+	cmd := runner.Cmd{Name: "xdg-open", Args: []string{url}}
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = runner.Cmd{Name: "open", Args: []string{url}}
+	case "windows":
+		cmd = runner.Cmd{Name: "rundll32", Args: []string{"url.dll,FileProtocolHandler", url}}
+	}
+
+	return r.Run(ctx, cmd)
+}
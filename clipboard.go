@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// clipboardCmd returns the command copying its stdin to the system clipboard,
+// or false if there is no usable one, e.g. in an ssh session.
+func clipboardCmd() (runner.Cmd, bool) {
+	if os.Getenv("SSH_CONNECTION") != "" || os.Getenv("SSH_TTY") != "" {
+		// The clipboard of the remote machine is not the user's.
+		return runner.Cmd{}, false
+	}
+
+	var candidates []runner.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		candidates = []runner.Cmd{{Name: "pbcopy"}}
+	case "windows":
+		// Unlike clip, Set-Clipboard preserves non-ASCII text.
+		candidates = []runner.Cmd{
+			{Name: "powershell", Args: []string{"-NoProfile", "-NonInteractive", "-Command", "[Console]::InputEncoding = [Text.Encoding]::UTF8; $input | Set-Clipboard"}},
+			{Name: "clip"},
+		}
+	default:
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			candidates = append(candidates, runner.Cmd{Name: "wl-copy"})
+		}
+		if os.Getenv("DISPLAY") != "" {
+			candidates = append(candidates,
+				runner.Cmd{Name: "xclip", Args: []string{"-selection", "clipboard"}},
+				runner.Cmd{Name: "xsel", Args: []string{"--clipboard", "--input"}})
+		}
+	}
+
+	for _, cmd := range candidates {
+		if _, err := exec.LookPath(cmd.Name); err == nil {
+			return cmd, true
+		}
+	}
+
+	return runner.Cmd{}, false
+}
+
+// copyToClipboard copies the text to the system clipboard. It falls back to
+// the OSC 52 escape sequence, which the terminal interprets, so that it also
+// works in ssh sessions and tmux.
+func copyToClipboard(ctx context.Context, r runner.Runner, text string) error {
+	if cmd, ok := clipboardCmd(); ok {
+		cmd.Stdin = strings.NewReader(text)
+		err := r.Run(ctx, cmd)
+		if err == nil {
+			return nil
+		}
+		slog.Debug("Clipboard command failed, falling back to OSC 52", "cmd", cmd.Name, "err", err)
+	}
+
+	tty := "/dev/tty"
+	if runtime.GOOS == "windows" {
+		// Windows Terminal supports OSC 52, conhost ignores it.
+		tty = "CONOUT$"
+	}
+
+	var w io.Writer = os.Stderr
+	if tty, err := os.OpenFile(tty, os.O_WRONLY, 0); err == nil {
+		defer tty.Close()
+		w = tty
+	}
+
+	seq := "\x1b]52;c;" + base64.StdEncoding.EncodeToString([]byte(text)) + "\a"
+	if os.Getenv("TMUX") != "" {
+		// Pass the sequence through tmux to the outer terminal.
+		seq = "\x1bPtmux;" + strings.ReplaceAll(seq, "\x1b", "\x1b\x1b") + "\x1b\\"
+	}
+
+	if _, err := io.WriteString(w, seq); err != nil {
+		return fmt.Errorf("write osc52 error: %w", err)
+	}
+
+	return nil
+}
+
+// printOrCopy prints the line, or copies it to the clipboard with -copy.
+func printOrCopy(ctx context.Context, opts options, line string) error {
+	if !opts.copy {
+		_, err := fmt.Println(line)
+		return err
+	}
+
+	if err := copyToClipboard(ctx, opts.runner, line); err != nil {
+		return err
+	}
+	slog.Info("Copied to clipboard", "text", line)
+
+	return nil
+}
@@ -0,0 +1,1093 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"runtime"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/selector"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// runConnect connects to the selected VM, passing the args to ssh.
+func runConnect(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	fwd := fs.String("L", "", "configures SSH port forwarding. Equivalent to 'ssh -L <value>'")
+	addContainerFlags(fs, opts)
+	addTTYFlags(fs, opts)
+	addSudoFlags(fs, opts)
+	fs.BoolVar(&opts.forwardAgent, "A", false, "forward the ssh agent connection. Equivalent to 'ssh -A'")
+	fs.BoolFunc("X", "enable untrusted X11 forwarding. Equivalent to 'ssh -X'", func(string) error {
+		opts.forwardX11 = "untrusted"
+		return nil
+	})
+	fs.BoolFunc("Y", "enable trusted X11 forwarding. Equivalent to 'ssh -Y'", func(string) error {
+		opts.forwardX11 = "trusted"
+		return nil
+	})
+	for _, page := range []string{"console", "logs", "metrics"} {
+		page := page
+		fs.BoolFunc(page, "open the VM's Cloud Console "+page+" page in the browser instead of connecting", func(string) error {
+			opts.open = page
+			return nil
+		})
+	}
+	fs.BoolFunc("select-only", "print the selected VM as 'name zone project' instead of connecting, or as the text/template given as -select-only='{{.Name}}'", func(s string) error {
+		if s == "true" {
+			s = defaultSelectFormat
+		} else if s == "false" {
+			s = ""
+		}
+		opts.selectOnly = s
+		return nil
+	})
+	fs.IntVar(&opts.recentLogs, "recent-logs", 0, "print the VM's last N Cloud Logging entries (e.g. serial port output, syslog) before connecting")
+	fs.BoolVar(&opts.health, "health", false, "print the VM's load average, memory, boot time and disk usage before opening the shell (default the health_card config)")
+	fs.BoolVar(&opts.debugSSH, "debug-ssh", false, "log verbose ssh (and gcloud) debug output to a temp file whose path is printed")
+	fs.BoolFunc("tmux-remote", "attach to or create the remote tmux session 'gssh', or the name given as -tmux-remote=name", func(s string) error {
+		if s == "true" {
+			s = "gssh"
+		} else if s == "false" {
+			s = ""
+		}
+		opts.tmux = s
+		return nil
+	})
+	fs.DurationVar(&opts.idleTimeout, "idle-timeout", 0, "warn and then disconnect the session after this duration without terminal input or output (default the idle_timeout config)")
+	fs.BoolVar(&opts.reconnect, "reconnect", false, "reconnect with backoff if the ssh connection is lost")
+	serial := fs.Bool("serial", false, "attach to the VM's serial console instead of connecting via ssh")
+	fs.BoolFunc("browser", "open the VM's SSH-in-browser session instead of connecting via local ssh", func(string) error {
+		opts.open = "browser"
+		return nil
+	})
+	fs.BoolFunc("stop-on-exit", "stop the VM when the session ends", func(string) error {
+		opts.exitOp = "stop"
+		return nil
+	})
+	fs.BoolFunc("suspend-on-exit", "suspend the VM when the session ends", func(string) error {
+		opts.exitOp = "suspend"
+		return nil
+	})
+	parseFlags(fs, args)
+
+	if opts.selectOnly != "" {
+		opts.noStart = true
+	}
+
+	var fwds []string
+	if *fwd != "" {
+		fwds = []string{*fwd}
+	}
+
+	return connect(ctx, *opts, sshrunner.Options{PortFwds: fwds, Args: fs.Args(), Serial: *serial})
+}
+
+// runExec executes the command on the selected VM.
+func runExec(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	addContainerFlags(fs, opts)
+	addTTYFlags(fs, opts)
+	addSudoFlags(fs, opts)
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	return connect(ctx, *opts, sshrunner.Options{Args: fs.Args()})
+}
+
+// addContainerFlags registers the flags connecting to a container on the VM.
+func addContainerFlags(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.container, "container", "", "connect to the container on a Container-Optimized OS VM")
+	fs.BoolVar(&opts.pickContainer, "pick-container", false, "select one of the running containers on the VM to connect to")
+}
+
+// addTTYFlags registers the flags controlling pseudo-terminal allocation.
+func addTTYFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolFunc("t", "force pseudo-terminal allocation, e.g. for sudo prompts in commands. Equivalent to 'ssh -t'", func(string) error {
+		opts.tty = "true"
+		return nil
+	})
+	fs.BoolFunc("T", "disable pseudo-terminal allocation, e.g. for piping binary output. Equivalent to 'ssh -T'", func(string) error {
+		opts.tty = "false"
+		return nil
+	})
+}
+
+// addSudoFlags registers the flags running the remote command or login shell via sudo.
+func addSudoFlags(fs *flag.FlagSet, opts *options) {
+	fs.BoolFunc("root", "run the login shell or command as root via 'sudo -i'", func(string) error {
+		opts.sudo = "root"
+		return nil
+	})
+	fs.StringVar(&opts.sudo, "sudo-user", "", "run the login shell or command as this user via 'sudo -iu'")
+}
+
+// containerShell starts bash in the container if available, otherwise sh.
+const containerShell = "if command -v bash >/dev/null; then exec bash; else exec sh; fi"
+
+// runDocker selects a VM and one of its running containers and execs a shell,
+// or the command, in it.
+func runDocker(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	parseFlags(fs, args)
+
+	opts.pickContainer = true
+	sshArgs := fs.Args()
+	if len(sshArgs) == 0 {
+		sshArgs = []string{"/bin/sh", "-c", containerShell}
+	}
+
+	return connect(ctx, *opts, sshrunner.Options{Args: sshArgs})
+}
+
+// runTunnel forwards the ports to the selected VM without executing a remote command.
+func runTunnel(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	return connect(ctx, *opts, sshrunner.Options{PortFwds: fs.Args(), NoShell: true})
+}
+
+// connect selects a VM and runs the ssh command.
+func connect(ctx context.Context, opts options, sshOpts sshrunner.Options) error {
+	selected, conf, err := selectVM(ctx, opts, "portfwd", sshOpts.PortFwds, "args", len(sshOpts.Args))
+	if err != nil {
+		return err
+	}
+
+	return connectSelected(ctx, opts, conf, selected, sshOpts)
+}
+
+// connectSelected runs the ssh command to the selected VM.
+func connectSelected(ctx context.Context, opts options, conf config.Config, selected inventory.Instance, sshOpts sshrunner.Options) error {
+	var err error
+	if opts.selectOnly != "" {
+		return printSelected(ctx, opts, selected)
+	} else if !selected.GCE() && (opts.open != "" || opts.recentLogs > 0 || opts.preflight || sshOpts.Serial || opts.exitOp != "") {
+		return notGCE(selected)
+	} else if opts.open != "" {
+		return openPage(ctx, opts, selected)
+	}
+
+	if opts.recentLogs > 0 {
+		printRecentLogs(ctx, opts, selected)
+	}
+
+	if opts.preflight {
+		if err := preflight(ctx, opts, selected); err != nil {
+			return err
+		}
+	}
+
+	if err := prepareSSH(ctx, opts, conf, selected, &sshOpts); err != nil {
+		return err
+	}
+
+	sshOpts.Container = opts.container
+	if opts.pickContainer {
+		if sshOpts.Container, err = pickContainer(ctx, opts, selected, sshOpts); err != nil {
+			return err
+		}
+	}
+
+	if (opts.health || conf.HealthCard) && !opts.printCommand && len(sshOpts.Args) == 0 && !sshOpts.NoShell && !sshOpts.Serial {
+		printHealthCard(ctx, opts, selected, sshOpts)
+	}
+
+	if opts.debugSSH {
+		if sshOpts.DebugLog, err = debugLog(&opts, sshOpts); err != nil {
+			return err
+		}
+	}
+
+	cmds, err := sshrunner.Command(selected, sshOpts)
+	if err != nil {
+		return err
+	}
+
+	if opts.printCommand {
+		return printOrCopy(ctx, opts, sshrunner.Quote(cmds))
+	}
+
+	slog.Info("Executing", "cmd", sshrunner.Quote(cmds))
+
+	if sshOpts.DebugLog != "" {
+		slog.Info("Logging ssh debug output", "file", sshOpts.DebugLog)
+		defer slog.Info("Logged ssh debug output", "file", sshOpts.DebugLog)
+	}
+
+	if opts.idleTimeout == 0 && conf.IdleTimeout != "" {
+		opts.idleTimeout, _ = time.ParseDuration(conf.IdleTimeout) // Validated by config set.
+	}
+	if len(sshOpts.Args) > 0 || sshOpts.NoShell {
+		// Only guard interactive sessions.
+		opts.idleTimeout = 0
+	}
+
+	if len(sshOpts.Args) == 0 && !sshOpts.NoShell {
+		defer setTitle(ctx, opts.runner, conf, selected, sshOpts.User)()
+	}
+
+	inventory.ReportConnection(selected.Project)
+
+	t0 := time.Now()
+	auditSession(ctx, conf, selected, sshOpts.User, t0, false, nil)
+	err = runSession(ctx, opts, cmds)
+	switch offerFallback(ctx, err, sshOpts, selected) {
+	case fallbackSerial:
+		sshOpts.Serial = true
+		if cmds, err = sshrunner.Command(selected, sshOpts); err != nil {
+			return err
+		}
+
+		slog.Info("Executing", "cmd", sshrunner.Quote(cmds))
+		err = sshrunner.Run(ctx, opts.runner, cmds)
+	case fallbackBrowser:
+		slog.Info("Opening", "url", selected.BrowserSSHURL())
+		err = openBrowser(ctx, opts.runner, selected.BrowserSSHURL())
+	}
+	if err != nil && fromHistory && !noGcloud && time.Since(t0) < stableSession && ctx.Err() == nil {
+		if vErr := verifyHistoryVM(ctx, opts, selected); vErr != nil {
+			slog.Warn("VM selected from history may be outdated", "err", vErr)
+		}
+	}
+	err = sessionErr(selected, t0, err)
+	auditSession(ctx, conf, selected, sshOpts.User, t0, true, err)
+
+	if opts.exitOp != "" {
+		if opErr := instanceOpOnExit(ctx, opts, selected); opErr != nil && err == nil {
+			return opErr
+		} else if opErr != nil {
+			slog.Error("Failed to "+opts.exitOp+" VM", "err", opErr)
+		}
+	}
+
+	return err
+}
+
+// notGCE returns the error of GCE-only operations on hosts of other providers.
+func notGCE(inst inventory.Instance) error {
+	return fmt.Errorf("%s is not a GCE VM (provider %s)", inst.Name, inst.Provider)
+}
+
+// defaultSelectFormat is the -select-only output template.
+const defaultSelectFormat = "{{.Name}} {{.TrimZone}} {{.Project}}"
+
+// printSelected prints the selected VM formatted by the -select-only text/template.
+func printSelected(ctx context.Context, opts options, inst inventory.Instance) error {
+	tmpl, err := template.New("select").Parse(opts.selectOnly)
+	if err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -select-only template: %w", err))
+	}
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, inst); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -select-only template: %w", err))
+	}
+
+	return printOrCopy(ctx, opts, strings.TrimSpace(b.String()))
+}
+
+// openPage opens the Cloud Console page of the VM selected by -console, -logs, -metrics or -browser in the browser.
+func openPage(ctx context.Context, opts options, inst inventory.Instance) error {
+	url := inst.ConsoleURL()
+	switch opts.open {
+	case "logs":
+		url = inst.LogsURL()
+	case "metrics":
+		url = inst.MetricsURL()
+	case "browser":
+		url = inst.BrowserSSHURL()
+	}
+
+	slog.Info("Opening", "url", url)
+
+	return openBrowser(ctx, opts.runner, url)
+}
+
+// printRecentLogs prints the VM's recent Cloud Logging entries to stderr.
+// Failures are logged since they shouldn't prevent connecting.
+func printRecentLogs(ctx context.Context, opts options, inst inventory.Instance) {
+	entries, err := opts.gcloud().LogEntries(ctx, inst, opts.recentLogs)
+	if err != nil {
+		slog.Warn("Failed to read recent logs", "err", err)
+		return
+	}
+
+	for _, e := range entries {
+		fmt.Fprintf(os.Stderr, "%s %-8s %s\n", e.Timestamp.Local().Format(time.DateTime), e.Severity, e.Message())
+	}
+}
+
+// pickContainer lists the running containers on the VM via ssh and prompts
+// the user to select one if there are multiple, showing their images and status.
+func pickContainer(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options) (string, error) {
+	stdout, err := remoteOutput(ctx, opts, inst, sshOpts, "sudo", "docker", "ps", "--format", "{{.Names}}\t{{.Image}}\t{{.Status}}")
+	if err != nil {
+		return "", fmt.Errorf("list containers error: %w", err)
+	}
+
+	var names, items []string
+	for _, line := range strings.Split(strings.TrimSpace(string(stdout)), "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		names = append(names, fields[0])
+		items = append(items, fmt.Sprintf("%-30s %-50s %s", fields[0], fields[1], fields[2]))
+	}
+
+	if len(names) == 0 {
+		return "", withExitCode(exitNoMatch, fmt.Errorf("no running containers on VM %s", inst.Name))
+	} else if len(names) == 1 {
+		return names[0], nil
+	}
+
+	item, err := selector.SelectItem(ctx, "Select container", items, "")
+	if err != nil {
+		return "", fmt.Errorf("select container error: %w", err)
+	}
+
+	return names[slices.Index(items, item)], nil
+}
+
+// remoteOutput runs the command on the VM with the ssh options and returns its
+// stdout. It runs in the foreground process group like interactive sessions,
+// so that ssh and gcloud can prompt on the terminal, e.g. for unknown host keys
+// or the passphrase of a new key, instead of being stopped by SIGTTIN. Errors
+// are passed through, and it is terminated when the context is done.
+func remoteOutput(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options, args ...string) ([]byte, error) {
+	sshOpts.PortFwds, sshOpts.NoShell, sshOpts.Serial, sshOpts.Container = nil, false, false, ""
+	sshOpts.Args = args
+	cmds, err := sshrunner.Command(inst, sshOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout bytes.Buffer
+	err = opts.runner.Run(ctx, runner.Cmd{
+		Name:        cmds[0],
+		Args:        cmds[1:],
+		Stdin:       os.Stdin,
+		Stdout:      &stdout,
+		Stderr:      os.Stderr,
+		Interactive: true,
+		Stop:        ctx.Done(),
+	})
+	if err != nil {
+		return nil, err
+	} else if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// Fallback connection methods offered if ssh fails to connect.
+const (
+	fallbackSerial  = "Attach to the serial console"
+	fallbackBrowser = "Open SSH-in-browser"
+	fallbackNone    = "Give up"
+)
+
+// offerFallback returns the fallback connection method selected by the user if
+// the interactive ssh session failed to connect, e.g. due to a firewall or org
+// policy. If not in a terminal, it logs the SSH-in-browser URL instead.
+func offerFallback(ctx context.Context, err error, sshOpts sshrunner.Options, inst inventory.Instance) string {
+	if !connectionFailed(err) || !inst.GCE() {
+		return ""
+	} else if sshOpts.Serial || sshOpts.NoShell || len(sshOpts.Args) > 0 || len(sshOpts.PortFwds) > 0 {
+		return ""
+	} else if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		slog.Info("SSH connection failed, try SSH-in-browser", "url", inst.BrowserSSHURL())
+		return ""
+	}
+
+	fallback, err := selector.SelectItem(ctx, "SSH connection failed", []string{fallbackSerial, fallbackBrowser, fallbackNone}, "")
+	if err != nil {
+		return ""
+	}
+
+	return fallback
+}
+
+// connectionFailed returns true if the ssh error indicates a failed or lost connection.
+func connectionFailed(err error) bool {
+	var exitErr *exec.ExitError
+	return errors.As(err, &exitErr) && exitErr.ExitCode() == sshrunner.ExitConnectionFailed
+}
+
+const (
+	// maxReconnects is the number of consecutive failed -reconnect attempts after which gssh gives up.
+	maxReconnects = 10
+	// maxReconnectBackoff is the maximum delay between -reconnect attempts.
+	maxReconnectBackoff = 30 * time.Second
+	// stableSession is the duration after which a session is considered established,
+	// resetting the -reconnect attempts and backoff.
+	stableSession = 30 * time.Second
+)
+
+// sessionErr logs a summary of the ended session and returns its error, with the
+// exit code of the remote command (or 255 if ssh failed) as gssh's own exit code.
+func sessionErr(inst inventory.Instance, t0 time.Time, err error) error {
+	var exitErr *exec.ExitError
+	code := 0
+	if errors.As(err, &exitErr) {
+		code = exitErr.ExitCode()
+		err = sessionExit{code: code}
+	} else if err != nil {
+		return err
+	}
+
+	slog.Info("Session ended", "vm", inst.Name, "duration", time.Since(t0).Round(time.Second), "exit_code", code)
+
+	return err
+}
+
+// debugLog returns a new temp file for the ssh debug output of -debug-ssh. With
+// the gcloud backend, gcloud's own debug output on stderr is also written to it.
+func debugLog(opts *options, sshOpts sshrunner.Options) (string, error) {
+	f, err := os.CreateTemp("", "gssh-ssh-*.log")
+	if err != nil {
+		return "", fmt.Errorf("create debug log error: %w", err)
+	}
+
+	if sshOpts.Direct || sshOpts.Serial {
+		return f.Name(), f.Close()
+	}
+
+	runner.OnExit(func() { _ = f.Close() })
+	opts.runner = teeRunner{Runner: opts.runner, w: f}
+
+	return f.Name(), nil
+}
+
+// teeRunner is a runner that also writes the stderr of interactive commands to w.
+type teeRunner struct {
+	runner.Runner
+	w io.Writer
+}
+
+// Run runs the command, writing its stderr to w too if it is interactive.
+func (r teeRunner) Run(ctx context.Context, cmd runner.Cmd) error {
+	if cmd.Interactive && cmd.Stderr != nil {
+		cmd.Stderr = io.MultiWriter(cmd.Stderr, r.w)
+	}
+
+	return r.Runner.Run(ctx, cmd)
+}
+
+// runSession runs the ssh command. With -reconnect, it is rerun with backoff if the
+// connection is lost, which resumes the remote tmux session with -tmux-remote.
+func runSession(ctx context.Context, opts options, cmds []string) error {
+	var (
+		attempt int
+		backoff = time.Second
+	)
+	for {
+		t0 := time.Now()
+		var err error
+		if opts.idleTimeout > 0 {
+			err = sshrunner.RunIdle(ctx, opts.runner, cmds, opts.idleTimeout)
+		} else {
+			err = sshrunner.Run(ctx, opts.runner, cmds)
+		}
+		if !opts.reconnect || !connectionFailed(err) || ctx.Err() != nil {
+			return err
+		}
+
+		if time.Since(t0) > stableSession {
+			attempt, backoff = 0, time.Second
+		}
+		if attempt++; attempt > maxReconnects {
+			return err
+		}
+
+		slog.Warn("SSH connection lost, reconnecting", "attempt", attempt, "backoff", backoff)
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxReconnectBackoff)
+	}
+}
+
+// preflight returns an error naming the roles granting the IAM permissions
+// required to connect to the VM that the active gcloud account lacks.
+func preflight(ctx context.Context, opts options, inst inventory.Instance) error {
+	gc := opts.gcloud()
+
+	osLogin := opts.osLogin == "true"
+	if opts.osLogin == "" {
+		var err error
+		if osLogin, err = gc.OSLogin(ctx, inst); err != nil {
+			return gcloudErr(err)
+		}
+	}
+
+	missing, err := gc.MissingPermissions(ctx, inst, osLogin)
+	if err != nil {
+		return gcloudErr(err)
+	} else if len(missing) == 0 {
+		slog.Info("Preflight passed", "os_login", osLogin)
+		return nil
+	}
+
+	var msgs []string
+	for _, p := range missing {
+		msgs = append(msgs, fmt.Sprintf("%s (granted by %s)", p, inventory.PermissionRoles[p]))
+	}
+
+	return withExitCode(exitAuth, fmt.Errorf("missing permissions on VM %s: %s", inst.Name, strings.Join(msgs, ", ")))
+}
+
+// applyHints applies the gssh-user, gssh-port and gssh-init metadata of the VM
+// as defaults of the ssh options, so VM owners can encode how to connect.
+func applyHints(ctx context.Context, opts options, inst inventory.Instance, sshOpts *sshrunner.Options) error {
+	meta, err := opts.gcloud().InstanceMetadata(ctx, inst)
+	if err != nil {
+		return err
+	}
+
+	if u, ok := meta.Get("gssh-user"); ok && sshOpts.User == "" {
+		sshOpts.User = u
+	}
+	if p, ok := meta.Get("gssh-port"); ok && sshOpts.Port == 0 {
+		port, err := strconv.Atoi(p)
+		if err != nil {
+			return fmt.Errorf("invalid gssh-port metadata %q", p)
+		}
+		sshOpts.Port = port
+	}
+	if cmd, ok := meta.Get("gssh-init"); ok && sshOpts.Init == "" {
+		sshOpts.Init = cmd
+	}
+
+	slog.Info("Applied metadata hints", "user", sshOpts.User, "port", sshOpts.Port, "init", sshOpts.Init)
+
+	return nil
+}
+
+// addressProbeTimeout is the max duration of probing the internal IP when detecting the address.
+const addressProbeTimeout = time.Second
+
+// detectAddress returns "internal" if the VM's internal IP is reachable, e.g.
+// via a VPN or Cloud Interconnect, otherwise the default of the external IP or IAP.
+func detectAddress(ctx context.Context, opts options, inst inventory.Instance) string {
+	if inst.InternalIP() == "" {
+		return ""
+	}
+
+	if inventory.ReachableIP(ctx, inst.InternalIP(), opts.sshPort(), addressProbeTimeout) {
+		slog.Debug("Internal IP is reachable, connecting to it", "ip", inst.InternalIP())
+		return "internal"
+	}
+
+	return ""
+}
+
+// prepareKnownHosts configures the per project known_hosts file of plain ssh,
+// dropping host keys of a previous VM with the same name. On first use, ssh
+// prompts to verify the host key fingerprint which can be compared to the one
+// published by the VM's guest environment.
+func prepareKnownHosts(inst inventory.Instance, sshOpts *sshrunner.Options) error {
+	file, err := sshrunner.KnownHostsFile(inst.Project)
+	if err != nil {
+		return err
+	}
+
+	known, err := sshrunner.PruneKnownHosts(file, inst)
+	if err != nil {
+		return err
+	}
+	sshOpts.KnownHosts = file
+
+	if !known {
+		cmd := fmt.Sprintf("gcloud compute instances get-guest-attributes %s --zone=%s --query-path=hostkeys/", inst.Name, inst.TrimZone())
+		slog.Info("First connection to VM, verify the host key fingerprint with", "cmd", cmd)
+	}
+
+	return nil
+}
+
+// defaultKeepAlive is the default ssh keepalive interval, shorter than the
+// typical idle timeouts of IAP and NAT gateways.
+const defaultKeepAlive = 30 * time.Second
+
+// prepareSSH populates the ssh options of the selected VM. Plain ssh via the VM's
+// IP requires the gcloud key to be authorized, so if OS Login is enabled the key
+// is added to the OS Login profile and its POSIX username used by default.
+// Gcloud handles this itself, so detection is only logged in verbose mode.
+func prepareSSH(ctx context.Context, opts options, conf config.Config, inst inventory.Instance, sshOpts *sshrunner.Options) error {
+	sshOpts.User = opts.user
+	if !opts.userSet {
+		sshOpts.User = conf.User
+	}
+	sshOpts.Port = opts.port
+	if sshOpts.Port == 0 {
+		sshOpts.Port = conf.Ports[inst.Name]
+	}
+	sshOpts.SSHFlags = append(sshOpts.SSHFlags, opts.sshFlags...)
+	sshOpts.SendEnv = append(conf.SendEnv, opts.sendEnv...)
+	sshOpts.TTY = opts.tty
+	sshOpts.Identity = opts.identity
+	if sshOpts.Identity == "" {
+		sshOpts.Identity = conf.Identities[inventory.ActiveConfig()]
+	}
+	sshOpts.Tmux = opts.tmux
+	sshOpts.Sudo = opts.sudo
+	if (opts.multiplex || conf.Multiplex) && runtime.GOOS != "windows" {
+		// Windows OpenSSH doesn't support ControlMaster.
+		dir, err := sshrunner.ControlDir()
+		if err != nil {
+			return err
+		}
+		sshOpts.ControlDir = dir
+	}
+	sshOpts.KeepAlive = defaultKeepAlive
+	if opts.keepAlive != nil {
+		sshOpts.KeepAlive = *opts.keepAlive
+	} else if conf.KeepAlive != "" {
+		sshOpts.KeepAlive, _ = time.ParseDuration(conf.KeepAlive) // Validated by config set.
+	}
+	sshOpts.ForwardAgent = opts.forwardAgent || conf.ForwardAgent
+	sshOpts.ForwardX11 = opts.forwardX11
+	if sshOpts.ForwardX11 == "" {
+		sshOpts.ForwardX11 = conf.ForwardX11
+	}
+	if !inst.GCE() {
+		// Connect with plain ssh, to the host name of ssh_config hosts so that their config applies.
+		sshOpts.Identity = opts.identity
+		sshOpts.User = withDefault(sshOpts.User, inst.SSHUser)
+		if sshOpts.Port == 0 {
+			sshOpts.Port = inst.SSHPort
+		}
+		sshOpts.Direct = true
+		switch inst.Provider {
+		case inventory.ProviderEC2:
+			sshOpts.SSM = conf.EC2Connect != "ssh" && !opts.native
+			sshOpts.Address = withDefault(opts.address, conf.Address)
+			sshOpts.IAP = sshOpts.Address != "internal" && inst.ExternalIP() == ""
+		case inventory.ProviderAzure:
+			sshOpts.AzureSSH = conf.AzureConnect != "ssh" && !opts.native
+			if conf.AzureConnect == "bastion" {
+				sshOpts.Bastion = conf.AzureBastion
+			}
+			sshOpts.Address = withDefault(opts.address, conf.Address)
+		case inventory.ProviderTailscale:
+			sshOpts.TailscaleSSH = conf.TailscaleConnect != "ssh" && !opts.native
+		}
+		return nil
+	}
+	sshOpts.Direct = opts.offline || opts.native || conf.SSHBackend == "ssh" || noGcloud
+	sshOpts.Address = opts.address
+	if sshOpts.Address == "" {
+		sshOpts.Address = conf.Address
+	}
+	if sshOpts.Address == "auto" {
+		sshOpts.Address = detectAddress(ctx, opts, inst)
+	}
+	// Without an external IP, tunnel plain ssh through IAP like gcloud does.
+	sshOpts.IAP = !opts.offline && !noGcloud && sshOpts.Address != "internal" && (conf.IAP || (sshOpts.Direct && inst.ExternalIP() == ""))
+	if sshOpts.Direct {
+		if err := prepareKnownHosts(inst, sshOpts); err != nil {
+			slog.Warn("Failed to prepare known_hosts, using the ssh default", "err", err)
+		}
+	}
+
+	if (opts.hints || conf.MetadataHints) && !opts.offline && !noGcloud {
+		if err := applyHints(ctx, opts, inst, sshOpts); err != nil {
+			slog.Warn("Failed to read metadata hints", "err", err)
+		}
+	}
+
+	if opts.offline || noGcloud || (!sshOpts.Direct && !slog.Default().Enabled(ctx, slog.LevelDebug)) {
+		return nil
+	}
+
+	gc := opts.gcloud()
+
+	osLogin := opts.osLogin == "true"
+	if opts.osLogin == "" {
+		var err error
+		osLogin, err = gc.OSLogin(ctx, inst)
+		if err != nil {
+			slog.Warn("Failed to detect OS Login, assuming metadata ssh keys", "err", err)
+		}
+	}
+
+	if !sshOpts.Direct {
+		slog.Debug("Detected ssh key mechanism", "os_login", osLogin)
+		return nil
+	}
+
+	if !osLogin {
+		slog.Info("Using metadata ssh keys")
+		return nil
+	}
+
+	keyFile, err := sshrunner.IdentityFile(*sshOpts)
+	if err != nil {
+		return err
+	}
+
+	var username string
+	if opts.printCommand {
+		// Dry runs only print the command, so don't add the key to the profile.
+		username, err = gc.OSLoginUser(ctx)
+	} else {
+		username, err = gc.AddOSLoginKey(ctx, keyFile+".pub")
+	}
+	if err != nil {
+		return gcloudErr(err)
+	}
+	if sshOpts.User == "" {
+		sshOpts.User = username
+	}
+	slog.Info("Using OS Login", "user", sshOpts.User)
+
+	return nil
+}
+
+// runCopy copies files between the local machine and the selected VM.
+func runCopy(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	recurse := fs.Bool("r", false, "copy directories recursively")
+	compress := fs.Bool("compress", false, "download remote files or directories as a gzipped tar stream into the local dst directory")
+	parseFlags(fs, args)
+
+	if fs.NArg() < 2 {
+		return errUsage
+	}
+
+	selected, conf, err := selectVM(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	var sshOpts sshrunner.Options
+	if err := prepareSSH(ctx, *opts, conf, selected, &sshOpts); err != nil {
+		return err
+	}
+
+	if *compress {
+		return compressedDownload(ctx, *opts, selected, sshOpts, fs.Args())
+	}
+
+	cmds, err := sshrunner.CopyCommand(selected, sshOpts, *recurse, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	if opts.printCommand {
+		return printOrCopy(ctx, *opts, sshrunner.Quote(cmds))
+	}
+
+	slog.Info("Executing", "cmd", sshrunner.Quote(cmds))
+
+	t0 := time.Now()
+
+	return sessionErr(selected, t0, sshrunner.Run(ctx, opts.runner, cmds))
+}
+
+// runList prints the VMs matching the filters.
+func runList(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addListFlags(fs)
+	output := fs.String("o", "table", "output format: table, json, csv or names")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+	switch *output {
+	case "table", "json", "csv", "names":
+	default:
+		return fmt.Errorf("invalid output format %q, expected table, json, csv or names", *output)
+	}
+
+	l, err := listVMs(ctx, *opts)
+	if err != nil {
+		return err
+	}
+	opts.timing.Print()
+
+	switch *output {
+	case "json":
+		return printJSON(l.instances)
+	case "names":
+		for _, inst := range l.instances {
+			fmt.Println(inst.Name)
+		}
+		return nil
+	}
+
+	header := []string{"NAME", "ZONE", "STATUS", "INTERNAL_IP", "EXTERNAL_IP", "PROJECT"}
+	if opts.gke {
+		header = append(header, "CLUSTER", "NODE_POOL")
+	}
+	if opts.cost {
+		header = append(header, "COST")
+	}
+	if *output == "csv" {
+		header = append(header, "LABELS")
+	}
+
+	rows := [][]string{header}
+	var total float64
+	for _, inst := range l.instances {
+		row := []string{inst.Name, inst.Location(), inst.Status, inst.InternalIP(), inst.ExternalIP(), inst.Project}
+		if opts.gke {
+			row = append(row, inst.GKECluster(), inst.GKENodePool())
+		}
+		if opts.cost {
+			row = append(row, inst.CostLabel())
+			if cost, ok := inst.HourlyCost(); ok {
+				total += cost
+			}
+		}
+		if *output == "csv" {
+			row = append(row, labelList(inst.Labels))
+		}
+		rows = append(rows, row)
+	}
+
+	if *output == "csv" {
+		w := csv.NewWriter(os.Stdout)
+		if err := w.WriteAll(rows); err != nil {
+			return fmt.Errorf("write csv error: %w", err)
+		}
+	} else {
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		for _, row := range rows {
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if opts.cost {
+		fmt.Fprintf(os.Stderr, "Total: ~$%.2f/h, ~$%.0f/month for %d VMs\n", total, total*730, len(l.instances))
+	}
+
+	return nil
+}
+
+// printJSON prints the instances as a JSON array with their IPs.
+func printJSON(instances []inventory.Instance) error {
+	type record struct {
+		Name       string            `json:"name"`
+		Zone       string            `json:"zone"`
+		Status     string            `json:"status"`
+		InternalIP string            `json:"internal_ip,omitempty"`
+		ExternalIP string            `json:"external_ip,omitempty"`
+		Project    string            `json:"project,omitempty"`
+		Labels     map[string]string `json:"labels,omitempty"`
+	}
+
+	records := []record{}
+	for _, inst := range instances {
+		records = append(records, record{
+			Name:       inst.Name,
+			Zone:       inst.Location(),
+			Status:     inst.Status,
+			InternalIP: inst.InternalIP(),
+			ExternalIP: inst.ExternalIP(),
+			Project:    inst.Project,
+			Labels:     inst.Labels,
+		})
+	}
+
+	b, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal instances error: %w", err)
+	}
+
+	_, err = fmt.Printf("%s\n", b)
+
+	return err
+}
+
+// labelList returns the labels as sorted, semicolon separated key=value pairs.
+func labelList(labels map[string]string) string {
+	var l []string
+	for k, v := range labels {
+		l = append(l, k+"="+v)
+	}
+	sort.Strings(l)
+
+	return strings.Join(l, ";")
+}
+
+// runConfig shows or updates the gssh config file.
+func runConfig(_ context.Context, fs *flag.FlagSet, args []string) error {
+	parseFlags(fs, args)
+
+	action := "show"
+	if fs.NArg() > 0 {
+		action = fs.Arg(0)
+	}
+
+	switch {
+	case action == "path" && fs.NArg() == 1:
+		filename, ok := config.Path()
+		if !ok {
+			return fmt.Errorf("home directory not found")
+		}
+		fmt.Println(filename)
+
+		return nil
+	case action == "show" && fs.NArg() <= 1:
+		conf, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(conf, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal config error: %w", err)
+		}
+		fmt.Println(string(b))
+
+		return nil
+	case action == "set" && fs.NArg() == 3:
+		conf, err := config.Load()
+		if err != nil {
+			return err
+		}
+
+		if err := conf.Set(fs.Arg(1), fs.Arg(2)); err != nil {
+			return err
+		}
+
+		return config.Store(conf)
+	default:
+		return errUsage
+	}
+}
+
+// runHistory prints the previously selected VMs, most recent first.
+func runHistory(_ context.Context, fs *flag.FlagSet, args []string) error {
+	n := fs.Int("n", 20, "max number of entries to show")
+	parseFlags(fs, args)
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tNAME\tZONE\tPROJECT\tUSER")
+	for i := len(conf.History) - 1; i >= 0 && len(conf.History)-i <= *n; i-- {
+		e := conf.History[i]
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", e.Time.Format(time.DateTime), e.Instance.Name, e.Instance.Location(), e.Instance.Project, e.User)
+	}
+
+	return w.Flush()
+}
+
+// runDaemon runs the gssh daemon for the configured projects, or the gcloud config project if none.
+func runDaemon(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	ttl := fs.Duration("cache-ttl", time.Minute, "max age of the served VM lists")
+	useAPI := fs.Bool("api", false, "list VMs via the Compute Engine API using Application Default Credentials instead of gcloud")
+	timeout := fs.Duration("gcloud-timeout", time.Minute, "max duration of each gcloud invocation")
+	metricsAddr := fs.String("metrics-addr", "", "serve Prometheus metrics (cache age, list latency, connections per project) on this address, e.g. localhost:9464")
+	addGcloudFlags(fs)
+	parseFlags(fs, args)
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	gc := inventory.Gcloud{Timeout: *timeout, Runner: runner.Exec{}}
+
+	projects, err := configuredProjects(ctx, gc, conf)
+	if err != nil {
+		return err
+	}
+
+	return inventory.RunDaemon(ctx, inventory.NewFetcher(gc, *useAPI || conf.ListBackend == "api"), projects, *ttl, *metricsAddr)
+}
+
+// runPrefetch refreshes the cached VM lists of the configured projects, or the
+// gcloud config project if none, unless they are younger than the TTL. It prints
+// nothing on success, so it can be called from shell init or a timer.
+func runPrefetch(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	ttl := fs.Duration("cache-ttl", time.Minute, "skip projects with cached VM lists younger than this")
+	useAPI := fs.Bool("api", false, "list VMs via the Compute Engine API using Application Default Credentials instead of gcloud")
+	timeout := fs.Duration("gcloud-timeout", time.Minute, "max duration of each gcloud invocation")
+	addGcloudFlags(fs)
+	parseFlags(fs, args)
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	gc := inventory.Gcloud{Timeout: *timeout, Runner: runner.Exec{}}
+
+	projects, err := configuredProjects(ctx, gc, conf)
+	if err != nil {
+		return err
+	}
+
+	l := inventory.Lister{
+		Fetch: inventory.NewFetcher(gc, *useAPI || conf.ListBackend == "api"),
+		TTL:   *ttl,
+	}
+	_, _, err = l.ListProjects(ctx, projects, func(inventory.Instance) {})
+
+	return err
+}
+
+// configuredProjects returns the projects configured in the config, or the gcloud config project if none.
+func configuredProjects(ctx context.Context, gc inventory.Gcloud, conf config.Config) ([]string, error) {
+	if len(conf.Projects) > 0 {
+		return conf.Projects, nil
+	}
+
+	if project, ok := inventory.ActiveProject(); ok {
+		return []string{project}, nil
+	}
+
+	project, err := gc.ConfigGet(ctx, "project")
+	if err != nil {
+		return nil, gcloudErr(err)
+	} else if project == "" {
+		project = conf.DefaultProjects[inventory.ActiveConfig()]
+	}
+	if project == "" {
+		return nil, errors.New("no gcloud config project, configure one with 'gcloud config set project' or select one by running gssh")
+	}
+
+	return []string{project}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+)
+
+// commandNames are the names of the subcommands, populated in init since
+// referencing commands from runCompletion would be an initialization cycle.
+var commandNames []string
+
+func init() {
+	for _, cmd := range commands {
+		commandNames = append(commandNames, cmd.name)
+	}
+}
+
+// completionScripts are the shell completion scripts, which call back into
+// `gssh completion -complete` for the candidates.
+var completionScripts = map[string]string{
+	"bash": bashCompletion,
+	"zsh":  "autoload -U +X bashcompinit && bashcompinit\n" + bashCompletion,
+	"fish": fishCompletion,
+}
+
+const bashCompletion = `_gssh() {
+  local cur="${COMP_WORDS[COMP_CWORD]}" prev="${COMP_WORDS[COMP_CWORD-1]}" words
+  case "$prev" in
+    -h) words=$(gssh completion -complete hosts 2>/dev/null) ;;
+    -configuration) words=$(gssh completion -complete configurations 2>/dev/null) ;;
+    *)
+      if [[ "$cur" == -* ]]; then
+        local cmd=connect
+        [[ $COMP_CWORD -gt 1 && "${COMP_WORDS[1]}" != -* ]] && cmd="${COMP_WORDS[1]}"
+        words=$(gssh completion -complete flags "$cmd" 2>/dev/null)
+      elif [[ $COMP_CWORD -eq 1 ]]; then
+        words=$(gssh completion -complete commands 2>/dev/null)
+      else
+        return
+      fi
+      ;;
+  esac
+  COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _gssh gssh
+`
+
+const fishCompletion = `function __gssh_command
+  set -l args (commandline -opc)
+  if test (count $args) -gt 1; and not string match -q -- '-*' $args[2]
+    echo $args[2]
+  else
+    echo connect
+  end
+end
+complete -c gssh -n '__fish_use_subcommand' -f -a '(gssh completion -complete commands 2>/dev/null)'
+complete -c gssh -o h -x -a '(gssh completion -complete hosts 2>/dev/null)'
+complete -c gssh -o configuration -x -a '(gssh completion -complete configurations 2>/dev/null)'
+complete -c gssh -n 'string match -q -- "-*" (commandline -ct)' -f -a '(gssh completion -complete flags (__gssh_command) 2>/dev/null)'
+`
+
+// runCompletion prints the completion script of the shell, or the completion
+// candidates of the kind with -complete.
+func runCompletion(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	kind := fs.String("complete", "", "print the candidates of: commands, hosts (from the VM cache), configurations or flags [command]")
+	parseFlags(fs, args)
+
+	if *kind != "" {
+		words, err := completions(ctx, *kind, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		fmt.Println(strings.Join(words, "\n"))
+
+		return nil
+	}
+
+	script, ok := completionScripts[fs.Arg(0)]
+	if fs.NArg() != 1 || !ok {
+		return errUsage
+	}
+	fmt.Print(script)
+
+	return nil
+}
+
+// completions returns the completion candidates of the kind.
+func completions(ctx context.Context, kind, command string) ([]string, error) {
+	switch kind {
+	case "commands":
+		return commandNames, nil
+	case "hosts":
+		return inventory.CachedNames()
+	case "configurations":
+		return inventory.Configurations(), nil
+	case "flags":
+		return commandFlags(ctx, command)
+	default:
+		return nil, fmt.Errorf("unknown completion kind %q", kind)
+	}
+}
+
+// commandFlags returns the flags of the command, parsed from its -help output
+// since flags are only registered when the command runs.
+func commandFlags(ctx context.Context, command string) ([]string, error) {
+	if !slices.Contains(commandNames, command) {
+		// Else it is an arg of the default command, which would then run.
+		command = commandNames[0]
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return nil, fmt.Errorf("executable error: %w", err)
+	}
+
+	out, _ := runner.Output(ctx, runner.Exec{}, runner.Cmd{Name: self, Args: []string{command, "-help"}})
+
+	var flags []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.HasPrefix(line, "  -") {
+			flags = append(flags, strings.Fields(line)[0])
+		}
+	}
+
+	return flags, nil
+}
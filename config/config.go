@@ -0,0 +1,524 @@
+// Package config loads and stores the gssh config file.
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// File is the name of the gssh config file in the home directory.
+const File = ".gssh.json"
+
+// Config is the gssh config file format.
+type Config struct {
+	Previous inventory.Instance `json:"previous"`
+	// Projects are the projects used by -all-projects and kept warm by the daemon.
+	Projects []string `json:"projects,omitempty"`
+	// ListBackend lists VMs via "gcloud" (default) or the Compute Engine "api".
+	ListBackend string `json:"list_backend,omitempty"`
+	// SSHBackend connects via "gcloud" (default) or plain "ssh" to the VM's IP.
+	SSHBackend string `json:"ssh_backend,omitempty"`
+	// SSHHosts are the ssh_config files whose Host entries are listed alongside the VMs.
+	SSHHosts []string `json:"ssh_hosts,omitempty"`
+	// StaticInventory are the JSON static inventory files whose hosts are listed alongside the VMs.
+	StaticInventory []string `json:"static_inventory,omitempty"`
+	// Plugins are the provider plugins whose hosts are listed alongside the VMs, by
+	// executable path or name of the gssh-provider-<name> executable in the PATH.
+	Plugins []string `json:"plugins,omitempty"`
+	// EC2Regions are the AWS regions whose EC2 instances are listed alongside the VMs.
+	EC2Regions []string `json:"ec2_regions,omitempty"`
+	// EC2Profile is the AWS profile used to list and connect to EC2 instances, overridden by $AWS_PROFILE.
+	EC2Profile string `json:"ec2_profile,omitempty"`
+	// EC2Connect connects to EC2 instances via "ssm" Session Manager (default) or plain "ssh".
+	EC2Connect string `json:"ec2_connect,omitempty"`
+	// AzureSubscriptions are the Azure subscriptions whose VMs are listed alongside the VMs.
+	AzureSubscriptions []string `json:"azure_subscriptions,omitempty"`
+	// AzureConnect connects to Azure VMs via "aad" az ssh vm (default), "bastion" or plain "ssh".
+	AzureConnect string `json:"azure_connect,omitempty"`
+	// AzureBastion is the "resource-group/name" of the Azure Bastion used by azure_connect bastion.
+	AzureBastion string `json:"azure_bastion,omitempty"`
+	// Tailscale lists the machines of the tailnet alongside the VMs.
+	Tailscale bool `json:"tailscale,omitempty"`
+	// TailscaleConnect connects to tailnet machines via "tailscale" ssh (default) or plain "ssh" to their tailnet IP.
+	TailscaleConnect string `json:"tailscale_connect,omitempty"`
+	// DefaultProjects are the projects selected per gcloud configuration without a project.
+	DefaultProjects map[string]string `json:"default_projects,omitempty"`
+	// MetadataHints applies the gssh-user, gssh-port and gssh-init metadata of the selected VM.
+	MetadataHints bool `json:"metadata_hints,omitempty"`
+	// ForwardAgent forwards the ssh agent by default, like -A.
+	ForwardAgent bool `json:"forward_agent,omitempty"`
+	// ForwardX11 forwards X11 by default, "untrusted" like -X or "trusted" like -Y.
+	ForwardX11 string `json:"forward_x11,omitempty"`
+	// KeepAlive is the ssh ServerAliveInterval, e.g. "1m" or "0" to disable, empty for the default.
+	KeepAlive string `json:"keepalive,omitempty"`
+	// Multiplex shares one ssh connection per VM between gssh invocations, like -multiplex.
+	Multiplex bool `json:"multiplex,omitempty"`
+	// Ports are the ssh ports of VMs running sshd on a non-standard port, by VM name.
+	Ports map[string]int `json:"ports,omitempty"`
+	// Address connects to the "internal" or "external" IP of VMs, or "auto" detects
+	// whether the internal IP is reachable, empty for the default.
+	Address string `json:"address,omitempty"`
+	// Identities are the ssh private key files used per gcloud configuration.
+	Identities map[string]string `json:"identities,omitempty"`
+	// SendEnv are the environment variables sent to VMs by default, like -send-env.
+	SendEnv []string `json:"send_env,omitempty"`
+	// IdleTimeout disconnects interactive sessions without terminal activity for this duration, e.g. "30m".
+	IdleTimeout string `json:"idle_timeout,omitempty"`
+	// Title is the text/template of the terminal title while connected with the fields
+	// User, Name, Zone and Project, "off" disables it.
+	Title string `json:"title,omitempty"`
+	// ProjectPath is the remote path opened by gssh code and gssh gateway by default.
+	ProjectPath string `json:"project_path,omitempty"`
+	// AuditWebhook is the URL that session start and end events are posted to as JSON.
+	AuditWebhook string `json:"audit_webhook,omitempty"`
+	// AuditSecretFile is the file containing the HMAC-SHA256 key signing the audit events,
+	// which must only be readable by the user, overridden by $GSSH_AUDIT_SECRET.
+	AuditSecretFile string `json:"audit_secret_file,omitempty"`
+	// UpdateNotice logs a notice if a newer gssh release is available, checked at most once per day.
+	UpdateNotice bool `json:"update_notice,omitempty"`
+	// User is the default ssh username, overridden by $GSSH_USER and -u.
+	User string `json:"user,omitempty"`
+	// IAP tunnels connections to all VMs through Identity-Aware Proxy, not only to those without an external IP.
+	IAP bool `json:"iap,omitempty"`
+	// CacheTTL is the max age of cached VM lists, e.g. "5m", empty for the default.
+	CacheTTL string `json:"cache_ttl,omitempty"`
+	// UsageStats records anonymized usage (commands, flag names, results) locally for gssh stats, nothing is sent.
+	UsageStats bool `json:"usage_stats,omitempty"`
+	// Snippets are the named remote commands run by gssh run, they may use the snippet args as $1, $2, etc.
+	Snippets map[string]string `json:"snippets,omitempty"`
+	// Policies require confirmation, a change ticket ID or refuse connecting to matching VMs.
+	Policies []Policy `json:"policies,omitempty"`
+	// PolicyFiles are JSON files of additional policies, e.g. shipped by admins.
+	PolicyFiles []string `json:"policy_files,omitempty"`
+	// HealthCard prints the VM's load average, memory, boot time and disk usage before interactive sessions, like -health.
+	HealthCard bool `json:"health_card,omitempty"`
+	// Scratch is the template of VMs created by gssh scratch.
+	Scratch Scratch `json:"scratch,omitempty"`
+	// History are the previously selected VMs, oldest first.
+	History []HistoryEntry `json:"history,omitempty"`
+}
+
+// Scratch is the template of short-lived scratch VMs.
+type Scratch struct {
+	MachineType  string `json:"machine_type,omitempty"`
+	ImageFamily  string `json:"image_family,omitempty"`
+	ImageProject string `json:"image_project,omitempty"`
+	Zone         string `json:"zone,omitempty"`
+}
+
+// maxHistory is the maximum number of history entries kept.
+const maxHistory = 100
+
+// HistoryEntry is a previously selected VM.
+type HistoryEntry struct {
+	Time     time.Time          `json:"time"`
+	Instance inventory.Instance `json:"instance"`
+	User     string             `json:"user,omitempty"`
+}
+
+// AddHistory appends the entry to the history, dropping the oldest entries if full.
+func (c *Config) AddHistory(e HistoryEntry) {
+	c.History = append(c.History, e)
+	if len(c.History) > maxHistory {
+		c.History = c.History[len(c.History)-maxHistory:]
+	}
+}
+
+// Set sets the config key to the value parsed from its string representation.
+func (c *Config) Set(key, value string) error {
+	switch key {
+	case "projects":
+		c.Projects = splitList(value)
+	case "send_env":
+		c.SendEnv = splitList(value)
+	case "ssh_hosts":
+		c.SSHHosts = splitList(value)
+	case "azure_subscriptions":
+		c.AzureSubscriptions = splitList(value)
+	case "azure_connect":
+		if value != "" && value != "aad" && value != "bastion" && value != "ssh" {
+			return fmt.Errorf("invalid azure_connect %q, expected aad, bastion or ssh", value)
+		}
+		c.AzureConnect = value
+	case "azure_bastion":
+		if _, _, ok := strings.Cut(value, "/"); value != "" && !ok {
+			return fmt.Errorf("invalid azure_bastion %q, expected resource-group/name", value)
+		}
+		c.AzureBastion = value
+	case "tailscale":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid tailscale %q, expected true or false", value)
+		}
+		c.Tailscale = b
+	case "tailscale_connect":
+		if value != "" && value != "tailscale" && value != "ssh" {
+			return fmt.Errorf("invalid tailscale_connect %q, expected tailscale or ssh", value)
+		}
+		c.TailscaleConnect = value
+	case "static_inventory":
+		c.StaticInventory = splitList(value)
+	case "policy_files":
+		c.PolicyFiles = splitList(value)
+	case "plugins":
+		c.Plugins = splitList(value)
+	case "ec2_regions":
+		c.EC2Regions = splitList(value)
+	case "ec2_profile":
+		c.EC2Profile = value
+	case "ec2_connect":
+		if value != "" && value != "ssm" && value != "ssh" {
+			return fmt.Errorf("invalid ec2_connect %q, expected ssm or ssh", value)
+		}
+		c.EC2Connect = value
+	case "list_backend":
+		if value != "" && value != "gcloud" && value != "api" {
+			return fmt.Errorf("invalid list_backend %q, expected gcloud or api", value)
+		}
+		c.ListBackend = value
+	case "ssh_backend":
+		if value != "" && value != "gcloud" && value != "ssh" {
+			return fmt.Errorf("invalid ssh_backend %q, expected gcloud or ssh", value)
+		}
+		c.SSHBackend = value
+	case "metadata_hints":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid metadata_hints %q, expected true or false", value)
+		}
+		c.MetadataHints = b
+	case "forward_agent":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid forward_agent %q, expected true or false", value)
+		}
+		c.ForwardAgent = b
+	case "user":
+		c.User = value
+	case "iap":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid iap %q, expected true or false", value)
+		}
+		c.IAP = b
+	case "cache_ttl":
+		if _, err := time.ParseDuration(value); value != "" && err != nil {
+			return fmt.Errorf("invalid cache_ttl %q, expected a duration like 5m", value)
+		}
+		c.CacheTTL = value
+	case "usage_stats":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid usage_stats %q, expected true or false", value)
+		}
+		c.UsageStats = b
+	case "update_notice":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid update_notice %q, expected true or false", value)
+		}
+		c.UpdateNotice = b
+	case "forward_x11":
+		if value != "" && value != "untrusted" && value != "trusted" {
+			return fmt.Errorf("invalid forward_x11 %q, expected untrusted or trusted", value)
+		}
+		c.ForwardX11 = value
+	case "keepalive":
+		if _, err := time.ParseDuration(value); value != "" && err != nil {
+			return fmt.Errorf("invalid keepalive %q, expected a duration like 30s", value)
+		}
+		c.KeepAlive = value
+	case "health_card":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid health_card %q, expected true or false", value)
+		}
+		c.HealthCard = b
+	case "multiplex":
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("invalid multiplex %q, expected true or false", value)
+		}
+		c.Multiplex = b
+	case "address":
+		if value != "" && value != "internal" && value != "external" && value != "auto" {
+			return fmt.Errorf("invalid address %q, expected internal, external or auto", value)
+		}
+		c.Address = value
+	case "idle_timeout":
+		if _, err := time.ParseDuration(value); value != "" && err != nil {
+			return fmt.Errorf("invalid idle_timeout %q, expected a duration like 30m", value)
+		}
+		c.IdleTimeout = value
+	case "title":
+		if _, err := template.New("title").Parse(value); err != nil {
+			return fmt.Errorf("invalid title template: %w", err)
+		}
+		c.Title = value
+	case "project_path":
+		c.ProjectPath = value
+	case "audit_webhook":
+		if u, err := url.Parse(value); value != "" && (err != nil || (u.Scheme != "https" && u.Scheme != "http")) {
+			return fmt.Errorf("invalid audit_webhook %q, expected an http(s) URL", value)
+		}
+		c.AuditWebhook = value
+	case "audit_secret_file":
+		c.AuditSecretFile = value
+	case "scratch.machine_type":
+		c.Scratch.MachineType = value
+	case "scratch.image_family":
+		c.Scratch.ImageFamily = value
+	case "scratch.image_project":
+		c.Scratch.ImageProject = value
+	case "scratch.zone":
+		c.Scratch.Zone = value
+	default:
+		if name, ok := strings.CutPrefix(key, "ports."); ok && name != "" {
+			return c.setPort(name, value)
+		} else if name, ok := strings.CutPrefix(key, "identities."); ok && name != "" {
+			c.setIdentity(name, value)
+			return nil
+		} else if name, ok := strings.CutPrefix(key, "snippets."); ok && name != "" {
+			c.setSnippet(name, value)
+			return nil
+		}
+		return fmt.Errorf("unknown config key %q", key)
+	}
+
+	return nil
+}
+
+// Validate returns an error if any value is invalid, e.g. after editing the file by hand.
+func (c Config) Validate() error {
+	settings := [][2]string{
+		{"list_backend", c.ListBackend},
+		{"ssh_backend", c.SSHBackend},
+		{"ec2_connect", c.EC2Connect},
+		{"azure_connect", c.AzureConnect},
+		{"azure_bastion", c.AzureBastion},
+		{"tailscale_connect", c.TailscaleConnect},
+		{"forward_x11", c.ForwardX11},
+		{"keepalive", c.KeepAlive},
+		{"address", c.Address},
+		{"idle_timeout", c.IdleTimeout},
+		{"cache_ttl", c.CacheTTL},
+		{"title", c.Title},
+		{"audit_webhook", c.AuditWebhook},
+	}
+
+	var scratch Config
+	for _, kv := range settings {
+		if err := scratch.Set(kv[0], kv[1]); err != nil {
+			return err
+		}
+	}
+
+	for name, port := range c.Ports {
+		if port <= 0 || port > 65535 {
+			return fmt.Errorf("invalid port %d of %s", port, name)
+		}
+	}
+
+	if _, err := LoadPolicies(c); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// splitList returns the non-empty comma separated values.
+func splitList(value string) []string {
+	var l []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			l = append(l, v)
+		}
+	}
+
+	return l
+}
+
+// setPort sets the ssh port of the VM, or removes it if the value is empty.
+func (c *Config) setPort(name, value string) error {
+	if value == "" {
+		delete(c.Ports, name)
+		return nil
+	}
+
+	port, err := strconv.Atoi(value)
+	if err != nil || port <= 0 || port > 65535 {
+		return fmt.Errorf("invalid port %q", value)
+	}
+
+	if c.Ports == nil {
+		c.Ports = make(map[string]int)
+	}
+	c.Ports[name] = port
+
+	return nil
+}
+
+// setIdentity sets the identity file of the gcloud configuration, or removes it if the value is empty.
+func (c *Config) setIdentity(configuration, value string) {
+	if value == "" {
+		delete(c.Identities, configuration)
+		return
+	}
+
+	if c.Identities == nil {
+		c.Identities = make(map[string]string)
+	}
+	c.Identities[configuration] = value
+}
+
+// setSnippet sets the remote command of the snippet, or removes it if the value is empty.
+func (c *Config) setSnippet(name, value string) {
+	if value == "" {
+		delete(c.Snippets, name)
+		return
+	}
+
+	if c.Snippets == nil {
+		c.Snippets = make(map[string]string)
+	}
+	c.Snippets[name] = value
+}
+
+// Load loads the gssh config file.
+func Load() (Config, error) {
+	filename, ok := Path()
+	if !ok {
+		return Config{}, fmt.Errorf("home directory not found, cannot read config")
+	}
+
+	b, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return Config{}, nil
+	} else if err != nil {
+		return Config{}, fmt.Errorf("read config error: %w", err)
+	}
+
+	var conf Config
+	err = json.Unmarshal(stripComments(b), &conf)
+	if err != nil {
+		return Config{}, fmt.Errorf("unmarshal config error: %w", err)
+	}
+
+	return conf, nil
+}
+
+// Store stores the gssh config file, keeping the comments at its top.
+func Store(conf Config) error {
+	var comments []string
+	if filename, ok := Path(); ok {
+		if b, err := os.ReadFile(filename); err == nil {
+			comments = leadingComments(b)
+		}
+	}
+
+	return StoreCommented(conf, comments)
+}
+
+// StoreCommented stores the gssh config file with the comment lines at its top,
+// only readable by the user since it may contain credentials like webhook URLs.
+func StoreCommented(conf Config, comments []string) error {
+	b, err := json.MarshalIndent(conf, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal config error: %w", err)
+	}
+
+	if len(comments) > 0 {
+		b = append([]byte(strings.Join(comments, "\n")+"\n"), b...)
+	}
+
+	filename, ok := Path()
+	if !ok {
+		return fmt.Errorf("home directory not found, cannot store config")
+	}
+
+	if err := writeAtomic(filename, b, 0600); err != nil {
+		return fmt.Errorf("write config error: %w", err)
+	}
+
+	return nil
+}
+
+// writeAtomic writes the file via a temp file in the same directory that is
+// renamed over it, so concurrent gssh invocations never see a truncated file.
+func writeAtomic(filename string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op after the rename.
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// Exists returns true if the gssh config file exists, i.e. gssh was run before.
+func Exists() bool {
+	filename, ok := Path()
+	if !ok {
+		return false
+	}
+
+	_, err := os.Stat(filename)
+
+	return err == nil
+}
+
+// leadingComments returns the "//" comment lines at the top of the config file.
+func leadingComments(b []byte) []string {
+	var comments []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			break
+		}
+		comments = append(comments, line)
+	}
+
+	return comments
+}
+
+// stripComments returns the config file without its "//" comment lines, which
+// are not valid JSON.
+func stripComments(b []byte) []byte {
+	var lines []string
+	for _, line := range strings.Split(string(b), "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "//") {
+			lines = append(lines, line)
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// Path returns true and the path to the gssh config file or false if the home
+// directory is not found, i.e. $HOME is not present (or %USERPROFILE% on windows).
+func Path() (string, bool) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", false
+	}
+
+	return filepath.Join(home, File), true
+}
@@ -0,0 +1,109 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// Policy actions, from least to most strict.
+const (
+	PolicyConfirm = "confirm"
+	PolicyTicket  = "ticket"
+	PolicyRefuse  = "refuse"
+)
+
+// policyStrictness orders the policy actions.
+var policyStrictness = map[string]int{PolicyConfirm: 1, PolicyTicket: 2, PolicyRefuse: 3}
+
+// Policy guards connecting to matching VMs by requiring confirmation, a change
+// ticket ID or by refusing outright.
+type Policy struct {
+	// Projects match VMs in any of the projects, all projects if empty.
+	Projects []string `json:"projects,omitempty"`
+	// Labels match VMs with all of the labels, e.g. {"env": "prod"}, a "*" value matches any value.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Action is "confirm", "ticket" or "refuse".
+	Action string `json:"action"`
+	// TicketPattern is the regex that ticket IDs must match, e.g. "^CHG[0-9]+$", any non-empty ID if empty.
+	TicketPattern string `json:"ticket_pattern,omitempty"`
+	// Message explains the policy when it applies, e.g. a link to the change process.
+	Message string `json:"message,omitempty"`
+}
+
+// Matches returns true if the instance is in one of the projects and has all the labels.
+func (p Policy) Matches(inst inventory.Instance) bool {
+	if len(p.Projects) > 0 {
+		var found bool
+		for _, project := range p.Projects {
+			found = found || project == inst.Project
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, v := range p.Labels {
+		if actual, ok := inst.Labels[k]; !ok || (v != "*" && v != actual) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Validate returns an error if the action or ticket pattern is invalid.
+func (p Policy) Validate() error {
+	if policyStrictness[p.Action] == 0 {
+		return fmt.Errorf("invalid policy action %q, expected confirm, ticket or refuse", p.Action)
+	} else if _, err := regexp.Compile(p.TicketPattern); err != nil {
+		return fmt.Errorf("invalid policy ticket_pattern %q: %w", p.TicketPattern, err)
+	}
+
+	return nil
+}
+
+// LoadPolicies returns the policies of the config and of its policy files,
+// e.g. shipped by admins to a shared location.
+func LoadPolicies(c Config) ([]Policy, error) {
+	policies := append([]Policy(nil), c.Policies...)
+	for _, file := range c.PolicyFiles {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("read policy file error: %w", err)
+		}
+
+		var ps []Policy
+		if err := json.Unmarshal(b, &ps); err != nil {
+			return nil, fmt.Errorf("unmarshal policy file %s error: %w", file, err)
+		}
+		policies = append(policies, ps...)
+	}
+
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	return policies, nil
+}
+
+// StrictestPolicy returns the strictest of the policies matching the instance,
+// or false if none match.
+func StrictestPolicy(policies []Policy, inst inventory.Instance) (Policy, bool) {
+	var (
+		strictest Policy
+		found     bool
+	)
+	for _, p := range policies {
+		if p.Matches(inst) && policyStrictness[p.Action] > policyStrictness[strictest.Action] {
+			strictest, found = p, true
+		}
+	}
+
+	return strictest, found
+}
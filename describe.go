@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/selector"
+)
+
+// keyCtrlO is the ctrl-o control character, readline has no constant for it.
+const keyCtrlO = 0x0f
+
+// selectorKeys returns the selector keys showing the describe output of the
+// highlighted VM in a pager, copying one of its fields to the clipboard and
+// opening its Cloud Console, Logs Explorer or metrics page in the browser.
+func selectorKeys(opts options) []selector.Key {
+	return []selector.Key{
+		{
+			Code: keyCtrlO,
+			Help: "ctrl-o: describe",
+			Run: func(ctx context.Context, inst inventory.Instance) error {
+				return describeInPager(ctx, opts, inst)
+			},
+		},
+		{
+			Code: readline.CharCtrlY,
+			Help: "ctrl-y: copy",
+			Run: func(ctx context.Context, inst inventory.Instance) error {
+				return copyField(ctx, opts, inst)
+			},
+		},
+		pageKey(opts, readline.CharBell, "ctrl-g: console", "console"),
+		pageKey(opts, readline.CharCtrlL, "ctrl-l: logs", "logs"),
+		pageKey(opts, readline.CharTranspose, "ctrl-t: metrics", "metrics"),
+	}
+}
+
+// pageKey returns the selector key opening the page of the highlighted VM, see openPage.
+func pageKey(opts options, code byte, help string, page string) selector.Key {
+	opts.open = page
+	return selector.Key{
+		Code: code,
+		Help: help,
+		Run: func(ctx context.Context, inst inventory.Instance) error {
+			return openPage(ctx, opts, inst)
+		},
+	}
+}
+
+// describeInPager shows the full gcloud compute instances describe YAML of the
+// VM, including its metadata and labels, in $PAGER. Hosts of other providers
+// are shown as the JSON of their inventory entry.
+func describeInPager(ctx context.Context, opts options, inst inventory.Instance) error {
+	var (
+		output []byte
+		err    error
+	)
+	if inst.GCE() && !noGcloud {
+		output, err = opts.gcloud().Output(ctx, "compute", "instances", "describe", inst.Name,
+			"--zone="+inst.TrimZone(), "--project="+inst.Project, "--format=yaml")
+		if err != nil {
+			return fmt.Errorf("gcloud compute instances describe error: %w, %s", gcloudErr(err), bytes.TrimSpace(output))
+		}
+	} else if output, err = json.MarshalIndent(inst, "", "  "); err != nil {
+		return fmt.Errorf("marshal instance error: %w", err)
+	}
+
+	pager := strings.Fields(os.Getenv("PAGER"))
+	if len(pager) == 0 {
+		pager = []string{"less", "-R"}
+		if runtime.GOOS == "windows" {
+			pager = []string{"more"}
+		}
+	}
+
+	// The selector renders on stderr, so does the pager.
+	err = opts.runner.Run(ctx, runner.Cmd{Name: pager[0], Args: pager[1:], Stdin: bytes.NewReader(output), Stdout: os.Stderr, Stderr: os.Stderr})
+	if err != nil {
+		return fmt.Errorf("pager %s error: %w", pager[0], err)
+	}
+
+	return nil
+}
+
+// copyField copies the field of the VM selected by the user to the clipboard.
+func copyField(ctx context.Context, opts options, inst inventory.Instance) error {
+	fields := []struct{ name, value string }{
+		{"external IP", inst.ExternalIP()},
+		{"internal IP", inst.InternalIP()},
+		{"name", inst.Name},
+		{"zone", inst.TrimZone()},
+		{"project", inst.Project},
+	}
+	if inst.GCE() {
+		fields = append(fields, struct{ name, value string }{"self-link", inst.SelfLink()})
+	}
+
+	var items []string
+	values := make(map[string]string)
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		item := f.name + ": " + f.value
+		items = append(items, item)
+		values[item] = f.value
+	}
+
+	item, err := selector.SelectItem(ctx, "Copy", items, "")
+	if err != nil {
+		return err
+	}
+
+	if err := copyToClipboard(ctx, opts.runner, values[item]); err != nil {
+		return err
+	}
+	slog.Info("Copied to clipboard", "text", values[item])
+
+	return nil
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// iapHost is the endpoint of IAP TCP forwarding tunnels.
+const iapHost = "tunnel.cloudproxy.app"
+
+// doctorCheck is a diagnostic check of gssh doctor.
+type doctorCheck struct {
+	name string
+	// fix is the actionable fix printed if the check fails.
+	fix string
+	// gcloud checks are skipped if gcloud is missing.
+	gcloud bool
+	// run returns the details of the passed check or why it failed.
+	run func(ctx context.Context) (string, error)
+}
+
+// runDoctor checks the gcloud installation, credentials, connectivity and the
+// gssh config and cache, printing actionable fixes for each failed check.
+func runDoctor(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	timeout := fs.Duration("gcloud-timeout", time.Minute, "max duration of each gcloud invocation")
+	probeTimeout := fs.Duration("probe-timeout", 5*time.Second, "max duration of the IAP connectivity probe")
+	addGcloudFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	gc := inventory.Gcloud{Timeout: *timeout, Runner: runner.Exec{}}
+	conf, confErr := config.Load()
+
+	checks := []doctorCheck{
+		{
+			name: "gcloud",
+			fix:  "install the Google Cloud CLI (https://cloud.google.com/sdk/docs/install) or point -gcloud-bin at it",
+			run: func(context.Context) (string, error) {
+				return exec.LookPath(inventory.GcloudBin)
+			},
+		},
+		{
+			name:   "gcloud version",
+			fix:    "run `gcloud components update`",
+			gcloud: true,
+			run:    gc.Version,
+		},
+		{
+			name:   "account",
+			fix:    "run `gcloud auth login`",
+			gcloud: true,
+			run: func(ctx context.Context) (string, error) {
+				return required(gc.ConfigGet(ctx, "account"))
+			},
+		},
+		{
+			name:   "project",
+			fix:    "run `gcloud config set project <project>` or `gssh config set projects <project,...>`",
+			gcloud: true,
+			run: func(ctx context.Context) (string, error) {
+				projects, err := configuredProjects(ctx, gc, conf)
+				return strings.Join(projects, ","), err
+			},
+		},
+		{
+			name: "application default credentials",
+			fix:  "run `gcloud auth application-default login` (only required by -api and list_backend api)",
+			run: func(ctx context.Context) (string, error) {
+				return "valid", inventory.ValidateADC(ctx)
+			},
+		},
+		{
+			name: "IAP connectivity",
+			fix:  "allow outbound HTTPS to " + iapHost + " in your firewall or proxy",
+			run: func(ctx context.Context) (string, error) {
+				if !inventory.ReachableIP(ctx, iapHost, "443", *probeTimeout) {
+					return "", fmt.Errorf("cannot connect to %s:443", iapHost)
+				}
+				return iapHost + ":443 reachable", nil
+			},
+		},
+		{
+			name:   "OS Login key",
+			fix:    "connect to an OS Login VM once, or run `gcloud compute os-login ssh-keys add --key-file=<identity>.pub`",
+			gcloud: true,
+			run: func(ctx context.Context) (string, error) {
+				return checkOSLoginKey(ctx, gc, conf)
+			},
+		},
+		{
+			name: "config",
+			fix:  "fix or remove the invalid value with `gssh config set <key> <value>`, see `gssh config path`",
+			run: func(context.Context) (string, error) {
+				if confErr != nil {
+					return "", confErr
+				} else if err := conf.Validate(); err != nil {
+					return "", err
+				}
+				filename, _ := config.Path()
+				return filename, nil
+			},
+		},
+		{
+			name: "cache",
+			fix:  "delete the corrupt cache files, they are refetched on the next listing",
+			run: func(context.Context) (string, error) {
+				n, oldest, corrupt, err := inventory.CacheHealth()
+				if err != nil {
+					return "", err
+				} else if len(corrupt) > 0 {
+					return "", fmt.Errorf("corrupt cache files: %s", strings.Join(corrupt, ", "))
+				}
+				return fmt.Sprintf("%d VM lists, oldest %s", n, oldest.Truncate(time.Second)), nil
+			},
+		},
+	}
+
+	var failed int
+	gcloudOK := true
+	for _, c := range checks {
+		if c.gcloud && !gcloudOK {
+			fmt.Printf("[skip] %s: gcloud not found\n", c.name)
+			continue
+		}
+
+		detail, err := c.run(ctx)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		} else if err == nil {
+			fmt.Printf("[ok]   %s: %s\n", c.name, detail)
+			continue
+		}
+
+		failed++
+		if c.name == "gcloud" {
+			gcloudOK = false
+		}
+		fmt.Printf("[fail] %s: %v\n", c.name, err)
+		fmt.Printf("       fix: %s\n", c.fix)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+
+	return nil
+}
+
+// required returns an error if the gcloud config value is empty.
+func required(value string, err error) (string, error) {
+	if err != nil {
+		return "", gcloudErr(err)
+	} else if value == "" {
+		return "", errors.New("not set")
+	}
+
+	return value, nil
+}
+
+// checkOSLoginKey returns an error if the ssh identity is not in the OS Login
+// profile of the active gcloud account, required by plain ssh to OS Login VMs.
+func checkOSLoginKey(ctx context.Context, gc inventory.Gcloud, conf config.Config) (string, error) {
+	keyFile, err := sshrunner.IdentityFile(sshrunner.Options{Identity: conf.Identities[inventory.ActiveConfig()]})
+	if err != nil {
+		return "", err
+	}
+
+	pub, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		return "", fmt.Errorf("read public key error: %w", err)
+	}
+
+	profile, err := gc.OSLoginKeys(ctx)
+	if err != nil {
+		return "", gcloudErr(err)
+	}
+
+	for _, key := range profile {
+		if keyMaterial(key) == keyMaterial(string(pub)) {
+			return keyFile + " registered", nil
+		}
+	}
+
+	return "", fmt.Errorf("%s not in the OS Login profile (%d keys)", keyFile, len(profile))
+}
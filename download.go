@@ -0,0 +1,274 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// tarScript writes a gzipped tar archive of the remote path $2, relative to its
+// parent directory $1, to stdout.
+const tarScript = `tar -czf - -C "$1" "$2"`
+
+// compressedDownload downloads the remote files or directories, prefixed with
+// ':', to the local dst directory via a gzipped tar stream, so large logs are
+// compressed in flight. The dst directory is created if it doesn't exist.
+func compressedDownload(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options, paths []string) error {
+	dst := paths[len(paths)-1]
+	if strings.HasPrefix(dst, ":") {
+		return withExitCode(exitUsage, errors.New("-compress only supports downloads, dst must be a local directory"))
+	}
+
+	var srcs []string
+	for _, src := range paths[:len(paths)-1] {
+		if !strings.HasPrefix(src, ":") {
+			return withExitCode(exitUsage, errors.New("-compress only supports downloads, src must be remote paths prefixed with ':'"))
+		}
+		srcs = append(srcs, path.Clean(strings.TrimPrefix(src, ":")))
+	}
+
+	if !opts.printCommand {
+		if err := os.MkdirAll(dst, 0o755); err != nil {
+			return fmt.Errorf("create dst directory error: %w", err)
+		}
+	}
+
+	sshOpts.PortFwds, sshOpts.NoShell, sshOpts.Serial, sshOpts.Container = nil, false, false, ""
+	sshOpts.TTY = "false"
+
+	for _, src := range srcs {
+		sshOpts.Args = []string{"sh", "-c", tarScript, "gssh-cp", path.Dir(src), path.Base(src)}
+		cmds, err := sshrunner.Command(inst, sshOpts)
+		if err != nil {
+			return err
+		}
+
+		if opts.printCommand {
+			if err := printOrCopy(ctx, opts, sshrunner.Quote(cmds)+" | tar -xzf - -C "+sshrunner.Quote([]string{dst})); err != nil {
+				return err
+			}
+			continue
+		}
+
+		slog.Info("Downloading compressed", "src", src, "dst", dst)
+		slog.Debug("Executing", "cmd", sshrunner.Quote(cmds))
+
+		if err := downloadTar(ctx, opts, cmds, src, dst); err != nil {
+			return fmt.Errorf("download %s error: %w", src, err)
+		}
+	}
+
+	return nil
+}
+
+// downloadTar runs the remote tar command and extracts its output to dst while it arrives.
+func downloadTar(ctx context.Context, opts options, cmds []string, src, dst string) error {
+	pr, pw := io.Pipe()
+	p := newTransferProgress("Downloading " + path.Base(src))
+
+	var (
+		files  int
+		untar  error
+		untarW sync.WaitGroup
+	)
+	untarW.Add(1)
+	go func() {
+		defer untarW.Done()
+		r := io.TeeReader(pr, p)
+		files, untar = extractTar(r, dst)
+		if untar == nil {
+			// Drain the trailing tar padding so the remote command doesn't fail writing it.
+			_, _ = io.Copy(io.Discard, r)
+		}
+		// Unblock the remote command if extracting failed.
+		_ = pr.CloseWithError(untar)
+	}()
+
+	err := opts.runner.Run(ctx, runner.Cmd{Name: cmds[0], Args: cmds[1:], Stdout: pw, Stderr: os.Stderr})
+	_ = pw.CloseWithError(err)
+	untarW.Wait()
+	p.Done()
+
+	if err != nil {
+		return err
+	} else if untar != nil {
+		return untar
+	}
+
+	slog.Info("Downloaded", "src", src, "files", files, "compressed", formatBytes(p.total), "duration", time.Since(p.start).Round(time.Millisecond))
+
+	return nil
+}
+
+// extractTar extracts the gzipped tar archive to dst and returns the number of files extracted.
+// Entries escaping dst or below a symlink are rejected, and only directories, regular files and symlinks are extracted.
+func extractTar(r io.Reader, dst string) (int, error) {
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("read gzip error: %w", err)
+	}
+	defer zr.Close()
+
+	var files int
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		} else if err != nil {
+			return files, fmt.Errorf("read tar error: %w", err)
+		}
+
+		name := filepath.FromSlash(path.Clean(hdr.Name))
+		if !filepath.IsLocal(name) {
+			return files, fmt.Errorf("unsafe path in archive: %s", hdr.Name)
+		}
+		target := filepath.Join(dst, name)
+
+		// The lexical checks don't catch writing through a symlink extracted earlier, e.g. "a -> ." then "a/b -> ../x".
+		if err := checkParents(dst, name); err != nil {
+			return files, err
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := writeFile(target, tr, hdr.FileInfo().Mode().Perm()); err != nil {
+				return files, err
+			}
+			files++
+		case tar.TypeSymlink:
+			// Links pointing outside dst could be used to write outside it.
+			if filepath.IsAbs(hdr.Linkname) || !filepath.IsLocal(filepath.Join(filepath.Dir(name), hdr.Linkname)) {
+				slog.Warn("Skipping symlink pointing outside the download", "name", hdr.Name, "link", hdr.Linkname)
+				continue
+			}
+			_ = os.Remove(target)
+			if err := os.Symlink(hdr.Linkname, target); err != nil {
+				return files, err
+			}
+		default:
+			slog.Debug("Skipping unsupported archive entry", "name", hdr.Name, "type", hdr.Typeflag)
+		}
+	}
+}
+
+// checkParents returns an error if any parent directory of the local name
+// below dst is a symlink, so extracting it can't write outside dst.
+func checkParents(dst, name string) error {
+	dir := dst
+	for _, elem := range strings.Split(filepath.Dir(name), string(filepath.Separator)) {
+		if elem == "." {
+			continue
+		}
+		dir = filepath.Join(dir, elem)
+
+		fi, err := os.Lstat(dir)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		} else if err != nil {
+			return err
+		} else if fi.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("unsafe path in archive, %s is a symlink: %s", dir, name)
+		}
+	}
+
+	return nil
+}
+
+// writeFile writes the contents of r to the file, replacing it if it exists.
+func writeFile(name string, r io.Reader, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+		return err
+	}
+
+	// Remove existing files, which may be symlinks, instead of writing through them.
+	_ = os.Remove(name)
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, r); err != nil {
+		_ = f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// transferProgress prints the number of bytes received to stderr while they arrive.
+// Nothing is printed if info logs are disabled.
+type transferProgress struct {
+	msg     string
+	start   time.Time
+	total   int64
+	printed time.Time
+	shown   bool
+}
+
+// newTransferProgress returns a transferProgress printing the message.
+func newTransferProgress(msg string) *transferProgress {
+	now := time.Now()
+	return &transferProgress{msg: msg, start: now, printed: now}
+}
+
+// Write records the received bytes, it is not safe for concurrent use.
+func (p *transferProgress) Write(b []byte) (int, error) {
+	p.total += int64(len(b))
+	if time.Since(p.printed) >= progressInterval && slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		p.print()
+	}
+
+	return len(b), nil
+}
+
+// Done prints the final count if any progress was printed.
+func (p *transferProgress) Done() {
+	if !p.shown {
+		return
+	}
+
+	p.print()
+	fmt.Fprint(os.Stderr, "\n")
+}
+
+func (p *transferProgress) print() {
+	rate := float64(p.total) / max(time.Since(p.start).Seconds(), 0.001)
+	fmt.Fprintf(os.Stderr, "\r\033[K%s: %s received (%s/s)", p.msg, formatBytes(p.total), formatBytes(int64(rate)))
+	p.printed = time.Now()
+	p.shown = true
+}
+
+// formatBytes returns the byte count in human readable binary units, e.g. 1.5 MiB.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/manifoldco/promptui"
+)
+
+// Exit codes returned by gssh so that wrapper scripts can branch on failure causes.
+// They are above the codes of shells and signal deaths (126-192) and below ssh's 255, so that
+// they don't collide with the exit codes of sessions, which gssh passes through.
+const (
+	exitGeneric  = 241
+	exitUsage    = 242
+	exitNoMatch  = 243
+	exitMultiple = 244
+	exitGcloud   = 245
+	exitAuth     = 246
+	exitAbort    = 247
+)
+
+// exitKinds are the machine-readable names of the exit codes.
+var exitKinds = map[int]string{
+	exitGeneric:  "error",
+	exitUsage:    "usage",
+	exitNoMatch:  "no_match",
+	exitMultiple: "multiple_matches",
+	exitGcloud:   "gcloud",
+	exitAuth:     "auth",
+	exitAbort:    "aborted",
+}
+
+// exitError is an error with a specific exit code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e exitError) Unwrap() error {
+	return e.err
+}
+
+// sessionExit is returned if the ssh session exits with a non-zero code, which
+// gssh exits with too, without printing an error since ssh or the remote command did.
+type sessionExit struct {
+	code int
+}
+
+func (e sessionExit) Error() string {
+	return fmt.Sprintf("session exited with code %d", e.code)
+}
+
+// withExitCode returns the error with the exit code.
+func withExitCode(code int, err error) error {
+	return exitError{code: code, err: err}
+}
+
+// gcloudErr returns the gcloud or API error with the auth or gcloud exit code.
+func gcloudErr(err error) error {
+	if inventory.IsAuthError(err) {
+		return withExitCode(exitAuth, err)
+	}
+
+	return withExitCode(exitGcloud, err)
+}
+
+// exitCode returns the exit code of the error.
+func exitCode(err error) int {
+	var (
+		exitErr    exitError
+		sessionErr sessionExit
+	)
+	switch {
+	case errors.As(err, &sessionErr):
+		return sessionErr.code
+	case errors.Is(err, errUsage):
+		return exitUsage
+	case errors.Is(err, promptui.ErrInterrupt), errors.Is(err, promptui.ErrEOF), errors.Is(err, promptui.ErrAbort), errors.Is(err, context.Canceled):
+		return exitAbort
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	default:
+		return exitGeneric
+	}
+}
+
+// printError prints the error to w, as a JSON object if jsonErrors.
+func printError(w io.Writer, err error, jsonErrors bool) {
+	code := exitCode(err)
+	if !jsonErrors {
+		fmt.Fprintf(w, "Fatal error: %v\n", err)
+		return
+	}
+
+	b, _ := json.Marshal(struct {
+		Error string `json:"error"`
+		Kind  string `json:"kind"`
+		Code  int    `json:"code"`
+	}{Error: err.Error(), Kind: exitKinds[code], Code: code})
+
+	fmt.Fprintf(w, "%s\n", b)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// sshConfigHeader is the header of the gssh ssh_config fragment.
+const sshConfigHeader = "# Generated by gssh, changes are overwritten.\n\n"
+
+// runSSHConfig writes an ssh_config fragment with a Host entry per VM matching
+// the filters, which ~/.ssh/config can Include, so that plain ssh and tools like
+// VS Code Remote-SSH connect like `gssh -native`. Rerun it to refresh the entries.
+func runSSHConfig(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addListFlags(fs)
+	fs.StringVar(&opts.user, "u", os.Getenv("GSSH_USER"), "ssh username (overrides $GSSH_USER env var)")
+	out := fs.String("o", "", "output file, '-' for stdout (default ~/.ssh/gssh_config)")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	l, err := listVMs(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	// Qualify names that exist in multiple projects.
+	counts := make(map[string]int)
+	for _, inst := range l.instances {
+		counts[inst.Name]++
+	}
+
+	var (
+		b     bytes.Buffer
+		hosts int
+	)
+	b.WriteString(sshConfigHeader)
+	for _, inst := range l.instances {
+		if !inst.GCE() {
+			// Already declared in an ssh_config file.
+			continue
+		}
+
+		host := inst.Name
+		if counts[inst.Name] > 1 && inst.Project != "" {
+			host += "." + inst.Project
+		}
+
+		sshOpts := sshrunner.Options{
+			User: opts.user,
+			Port: l.conf.Ports[inst.Name],
+			IAP:  inst.ExternalIP() == "",
+		}
+		if sshOpts.KnownHosts, err = sshrunner.KnownHostsFile(inst.Project); err != nil {
+			return err
+		}
+
+		entry, err := sshrunner.HostConfig(host, inst, sshOpts)
+		if err != nil {
+			slog.Warn("Skipping VM", "vm", inst.Name, "err", err)
+			continue
+		}
+		fmt.Fprintln(&b, entry)
+		hosts++
+	}
+
+	if *out == "-" {
+		_, err := os.Stdout.Write(b.Bytes())
+		return err
+	}
+
+	filename := *out
+	if filename == "" {
+		if filename, err = sshConfigFile(); err != nil {
+			return err
+		}
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return fmt.Errorf("create ssh dir error: %w", err)
+	}
+
+	err = os.WriteFile(filename, b.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("write ssh config error: %w", err)
+	}
+
+	slog.Info("Wrote ssh config", "file", filename, "hosts", hosts)
+	warnNotIncluded(filename)
+
+	return nil
+}
+
+// warnNotIncluded logs how to include the ssh_config fragment if ~/.ssh/config doesn't yet.
+func warnNotIncluded(filename string) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return
+	}
+
+	b, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err == nil && bytes.Contains(b, []byte(filepath.Base(filename))) {
+		return
+	}
+
+	slog.Warn("Add the line to the top of ~/.ssh/config to use the hosts", "line", "Include "+strings.TrimPrefix(filename, filepath.Join(home, ".ssh")+string(filepath.Separator)))
+}
+
+// runAnsible prints the VMs matching the filters as Ansible dynamic inventory
+// JSON, grouped by zone, project and label, with the IPs and zone as host vars.
+func runAnsible(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addListFlags(fs)
+	_ = fs.Bool("list", true, "print the whole inventory, as invoked by Ansible")
+	host := fs.String("host", "", "print the host vars of the host, empty since they are included in the inventory")
+	internal := fs.Bool("internal", false, "use the internal IPs as ansible_host, e.g. when on the VPC")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	if *host != "" {
+		_, err := fmt.Println("{}")
+		return err
+	}
+
+	l, err := listVMs(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	type group struct {
+		Hosts []string `json:"hosts"`
+	}
+	var (
+		inv      = make(map[string]any)
+		hostvars = make(map[string]map[string]any)
+		groups   = make(map[string]*group)
+	)
+	addToGroup := func(name, host string) {
+		name = ansibleGroup(name)
+		if groups[name] == nil {
+			groups[name] = &group{}
+		}
+		groups[name].Hosts = append(groups[name].Hosts, host)
+	}
+
+	for _, inst := range l.instances {
+		ip := inst.ExternalIP()
+		if *internal || ip == "" {
+			ip = inst.InternalIP()
+		}
+
+		hostvars[inst.Name] = map[string]any{
+			"ansible_host": ip,
+			"internal_ip":  inst.InternalIP(),
+			"external_ip":  inst.ExternalIP(),
+			"zone":         inst.TrimZone(),
+			"project":      inst.Project,
+			"status":       inst.Status,
+			"labels":       inst.Labels,
+		}
+
+		addToGroup("zone_"+inst.Location(), inst.Name)
+		if inst.Project != "" {
+			addToGroup("project_"+inst.Project, inst.Name)
+		}
+		for k, v := range inst.Labels {
+			addToGroup("label_"+k+"_"+v, inst.Name)
+		}
+	}
+
+	for name, g := range groups {
+		inv[name] = g
+	}
+	inv["_meta"] = map[string]any{"hostvars": hostvars}
+
+	b, err := json.MarshalIndent(inv, "", "  ")
+	if err != nil {
+		return fmt.Errorf("marshal inventory error: %w", err)
+	}
+
+	_, err = fmt.Printf("%s\n", b)
+
+	return err
+}
+
+// ansibleGroup returns the name with characters invalid in Ansible group names replaced by underscores.
+func ansibleGroup(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
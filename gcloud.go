@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/manifoldco/promptui"
+)
+
+var (
+	// gcloudChecked ensures gcloud is only checked once per invocation.
+	gcloudChecked  sync.Once
+	gcloudCheckErr error
+	// noGcloud is true if gcloud is missing and the user chose to proceed without
+	// it, listing VMs via the Compute Engine API and connecting with plain ssh.
+	noGcloud bool
+)
+
+// checkGcloud returns a clear error if gcloud is missing, unless the user
+// chooses to proceed without it, and warns if it is older than supported.
+// It only checks once per invocation.
+func checkGcloud(ctx context.Context, opts options) error {
+	gcloudChecked.Do(func() {
+		gcloudCheckErr = lookupGcloud(ctx, opts)
+	})
+
+	return gcloudCheckErr
+}
+
+// lookupGcloud implements checkGcloud.
+func lookupGcloud(ctx context.Context, opts options) error {
+	bin, err := exec.LookPath(inventory.GcloudBin)
+	if err == nil {
+		if version, err := cachedGcloudVersion(ctx, opts.gcloud(), bin); err != nil && version != "" {
+			slog.Warn("Outdated gcloud, some features may fail", "err", err)
+		} else if err != nil {
+			slog.Debug("Failed to check gcloud version", "err", err)
+		}
+		return nil
+	} else if opts.offline {
+		// The cached VM list and plain ssh don't require gcloud.
+		noGcloud = true
+		return nil
+	}
+
+	missing := withExitCode(exitGcloud, fmt.Errorf("%s not found in the PATH, install the Google Cloud SDK "+
+		"from https://cloud.google.com/sdk/docs/install or select it with -gcloud-bin", inventory.GcloudBin))
+
+	if err := inventory.ValidateADC(ctx); err != nil {
+		slog.Debug("Cannot proceed without gcloud", "err", err)
+		return missing
+	}
+
+	if !opts.api || !opts.native {
+		if !readline.IsTerminal(int(os.Stdin.Fd())) {
+			return fmt.Errorf("%w, or proceed without it using -api -native", missing)
+		}
+
+		prompt := promptui.Prompt{
+			Label:     "gcloud not found, proceed listing VMs via the Compute Engine API and connecting with plain ssh (no IAP or OS Login)",
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return missing
+		}
+	}
+
+	slog.Info("Proceeding without gcloud, VMs without an external IP are only reachable via their internal IP")
+	noGcloud = true
+
+	return nil
+}
+
+// gcloudVersion is the cached version of a gcloud binary.
+type gcloudVersion struct {
+	Bin     string    `json:"bin"`
+	ModTime time.Time `json:"mod_time"`
+	Version string    `json:"version"`
+}
+
+// cachedGcloudVersion returns the version of the gcloud binary, cached until the
+// binary changes since gcloud takes a second to start. It also returns an error
+// if the version is older than supported, which isn't cached.
+func cachedGcloudVersion(ctx context.Context, gc inventory.Gcloud, bin string) (string, error) {
+	info, err := os.Stat(bin)
+	if err != nil {
+		return "", fmt.Errorf("stat gcloud error: %w", err)
+	}
+
+	filename, err := cacheFile("gcloud-version.json")
+	if err != nil {
+		return "", err
+	}
+
+	var cached gcloudVersion
+	if b, err := os.ReadFile(filename); err == nil && json.Unmarshal(b, &cached) == nil &&
+		cached.Bin == bin && cached.ModTime.Equal(info.ModTime()) {
+		return cached.Version, nil
+	}
+
+	version, err := gc.Version(ctx)
+	if err != nil {
+		return version, err
+	}
+
+	b, err := json.Marshal(gcloudVersion{Bin: bin, ModTime: info.ModTime(), Version: version})
+	if err != nil {
+		return version, nil
+	}
+	if err := os.WriteFile(filename, b, 0644); err != nil {
+		slog.Debug("Failed to cache gcloud version", "err", err)
+	}
+
+	return version, nil
+}
+
+// projectsWithoutGcloud returns the projects listed if proceeding without gcloud,
+// the project remembered for the active gcloud configuration or the configured projects.
+func projectsWithoutGcloud(conf config.Config) ([]string, error) {
+	if p := conf.DefaultProjects[inventory.ActiveConfig()]; p != "" {
+		return []string{p}, nil
+	} else if len(conf.Projects) > 0 {
+		return conf.Projects, nil
+	}
+
+	return nil, errors.New("no project without gcloud, select one with -P or configure them with `gssh config set projects`")
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// healthScript prints the load average and CPU count, memory in MiB, boot time
+// and disk usage in KiB of real filesystems as one line each, in a single round trip.
+const healthScript = `echo "load $(cut -d' ' -f1-3 /proc/loadavg) $(nproc)"
+free -m | awk '/^Mem:/ {print "mem", $2, $3, $7}'
+echo "boot $(uptime -s)"
+df -P -x tmpfs -x devtmpfs -x squashfs -x overlay -x efivarfs 2>/dev/null | awk 'NR > 1 {print "disk", $6, $2, $3}'`
+
+// healthTimeout is the max duration of fetching the health card, so a struggling VM doesn't block connecting.
+const healthTimeout = 20 * time.Second
+
+// Thresholds above which health card lines are marked with "!".
+const (
+	healthDiskFull  = 0.9
+	healthMemLow    = 0.1
+	healthLoadRatio = 1.0
+)
+
+// printHealthCard prints the VM's load average, memory, boot time and disk
+// usage to stderr before connecting. Failures are logged since they shouldn't
+// prevent connecting.
+func printHealthCard(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options) {
+	ctx, cancel := context.WithTimeout(ctx, healthTimeout)
+	defer cancel()
+
+	output, err := remoteOutput(ctx, opts, inst, sshOpts, healthScript)
+	if err != nil {
+		slog.Warn("Failed to read VM health", "vm", inst.Name, "err", err)
+		return
+	}
+
+	w := tabwriter.NewWriter(os.Stderr, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Health of %s:\n", inst.Name)
+
+	s := bufio.NewScanner(strings.NewReader(string(output)))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch {
+		case fields[0] == "load" && len(fields) == 5:
+			load1, _ := strconv.ParseFloat(fields[1], 64)
+			cpus, _ := strconv.Atoi(fields[4])
+			fmt.Fprintf(w, "%s\tload\t%s (%s cpus)\n", mark(cpus > 0 && load1 > healthLoadRatio*float64(cpus)), strings.Join(fields[1:4], " "), fields[4])
+		case fields[0] == "mem" && len(fields) == 4:
+			total, _ := strconv.ParseInt(fields[1], 10, 64)
+			used, _ := strconv.ParseInt(fields[2], 10, 64)
+			avail, _ := strconv.ParseInt(fields[3], 10, 64)
+			fmt.Fprintf(w, "%s\tmemory\t%s/%s used, %s available\n", mark(total > 0 && float64(avail) < healthMemLow*float64(total)),
+				formatBytes(used<<20), formatBytes(total<<20), formatBytes(avail<<20))
+		case fields[0] == "boot" && len(fields) == 3:
+			booted, err := time.ParseInLocation(time.DateTime, fields[1]+" "+fields[2], time.Local)
+			if err != nil {
+				fmt.Fprintf(w, " \tbooted\t%s %s\n", fields[1], fields[2])
+				continue
+			}
+			// The remote timezone may differ, so the age is approximate.
+			fmt.Fprintf(w, " \tbooted\t%s (%s ago)\n", booted.Format(time.DateTime), time.Since(booted).Round(time.Minute))
+		case fields[0] == "disk" && len(fields) == 4:
+			total, _ := strconv.ParseInt(fields[2], 10, 64)
+			used, _ := strconv.ParseInt(fields[3], 10, 64)
+			if total == 0 {
+				continue
+			}
+			ratio := float64(used) / float64(total)
+			fmt.Fprintf(w, "%s\tdisk %s\t%s/%s used (%.0f%%)\n", mark(ratio >= healthDiskFull), fields[1],
+				formatBytes(used<<10), formatBytes(total<<10), ratio*100)
+		}
+	}
+
+	_ = w.Flush()
+}
+
+// mark returns "!" if the health card line needs attention, else a space.
+func mark(warn bool) string {
+	if warn {
+		return "!"
+	}
+	return " "
+}
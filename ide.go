@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// runCode opens VS Code connected to the selected VM via Remote-SSH, after
+// ensuring its Host entry in the gssh ssh_config fragment.
+func runCode(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+
+	host, ide, err := ensureHostConfig(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	codeArgs := []string{"--remote", "ssh-remote+" + host}
+	if path := withDefault(fs.Arg(0), ide.projectPath); path != "" {
+		codeArgs = append(codeArgs, path)
+	}
+
+	slog.Info("Opening VS Code", "host", host)
+
+	return opts.runner.Run(ctx, runner.Cmd{Name: "code", Args: codeArgs, Stdout: os.Stderr, Stderr: os.Stderr})
+}
+
+// runGateway opens JetBrains Gateway connected to the selected VM via ssh, after
+// ensuring its Host entry in the gssh ssh_config fragment, or prints the URL.
+func runGateway(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	printURL := fs.Bool("print-url", false, "print the jetbrains-gateway:// URL instead of opening it")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+
+	host, ide, err := ensureHostConfig(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	params := url.Values{"type": {"ssh"}, "deploy": {"false"}, "host": {host}, "port": {strconv.Itoa(withDefaultPort(ide.port))}}
+	if ide.user != "" {
+		params.Set("user", ide.user)
+	}
+	if path := withDefault(fs.Arg(0), ide.projectPath); path != "" {
+		params.Set("projectPath", path)
+	}
+	gatewayURL := "jetbrains-gateway://connect#" + params.Encode()
+
+	if *printURL {
+		fmt.Println(gatewayURL)
+		return nil
+	}
+
+	slog.Info("Opening JetBrains Gateway", "url", gatewayURL)
+
+	return openBrowser(ctx, opts.runner, gatewayURL)
+}
+
+// ideHost are the connection details of the VM for IDEs.
+type ideHost struct {
+	user        string
+	port        int
+	projectPath string
+}
+
+// withDefaultPort returns the port or the default ssh port if zero.
+func withDefaultPort(port int) int {
+	if port == 0 {
+		return 22
+	}
+
+	return port
+}
+
+// ensureHostConfig selects a VM and adds or updates its Host entry in the gssh
+// ssh_config fragment, connecting like -native, and returns the host name.
+func ensureHostConfig(ctx context.Context, opts options) (string, ideHost, error) {
+	selected, conf, err := selectVM(ctx, opts)
+	if err != nil {
+		return "", ideHost{}, err
+	}
+	if !selected.GCE() {
+		// Hosts imported from ssh_config are already declared there.
+		return selected.Name, ideHost{user: opts.user, projectPath: conf.ProjectPath}, nil
+	}
+
+	opts.native = true
+	var sshOpts sshrunner.Options
+	if err := prepareSSH(ctx, opts, conf, selected, &sshOpts); err != nil {
+		return "", ideHost{}, err
+	}
+
+	entry, err := sshrunner.HostConfig(selected.Name, selected, sshOpts)
+	if err != nil {
+		return "", ideHost{}, err
+	}
+
+	filename, err := sshConfigFile()
+	if err != nil {
+		return "", ideHost{}, err
+	}
+
+	if err := upsertHostConfig(filename, selected.Name, entry); err != nil {
+		return "", ideHost{}, err
+	}
+	warnNotIncluded(filename)
+
+	return selected.Name, ideHost{user: sshOpts.User, port: sshOpts.Port, projectPath: conf.ProjectPath}, nil
+}
+
+// sshConfigFile returns the path of the gssh ssh_config fragment.
+func sshConfigFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("home dir error: %w", err)
+	}
+
+	return filepath.Join(home, ".ssh", "gssh_config"), nil
+}
+
+// upsertHostConfig replaces the Host entry of the host in the ssh_config file, or appends it.
+func upsertHostConfig(filename, host, entry string) error {
+	b, err := os.ReadFile(filename)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("read ssh config error: %w", err)
+	} else if len(b) == 0 {
+		b = []byte(sshConfigHeader)
+	}
+
+	// Entries are separated by empty lines as written by HostConfig.
+	var (
+		out      bytes.Buffer
+		replaced bool
+	)
+	for _, block := range strings.SplitAfter(string(b), "\n\n") {
+		if strings.HasPrefix(block, "Host "+host+"\n") {
+			block, replaced = entry+"\n", true
+		}
+		out.WriteString(block)
+	}
+	if !replaced {
+		if !bytes.HasSuffix(out.Bytes(), []byte("\n\n")) {
+			out.WriteString("\n")
+		}
+		out.WriteString(entry + "\n")
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0700)
+	if err != nil {
+		return fmt.Errorf("create ssh dir error: %w", err)
+	}
+
+	err = os.WriteFile(filename, out.Bytes(), 0600)
+	if err != nil {
+		return fmt.Errorf("write ssh config error: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,315 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	compute "cloud.google.com/go/compute/apiv1"
+	"cloud.google.com/go/compute/apiv1/computepb"
+	"github.com/googleapis/gax-go/v2/apierror"
+	"github.com/googleapis/gax-go/v2/callctx"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"google.golang.org/api/impersonate"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	scope = "https://www.googleapis.com/auth/cloud-platform"
+
+	// instanceFields are the instance fields used by gssh.
+	instanceFields = "name,id,zone,status,machineType,labels,networkInterfaces(networkIP,accessConfigs/natIP)," +
+		"shieldedInstanceConfig(enableSecureBoot,enableVtpm),confidentialInstanceConfig/enableConfidentialCompute"
+
+	// aggregatedFields is the field mask of the aggregated instance list.
+	aggregatedFields = "items/*/instances(" + instanceFields + "),nextPageToken"
+
+	// zoneFields is the field mask of the zonal instance list.
+	zoneFields = "items(" + instanceFields + "),nextPageToken"
+
+	// fieldMaskHeader selects the response fields, like the fields query parameter.
+	fieldMaskHeader = "X-Goog-FieldMask"
+
+	// pageSize is the max number of instances per list page.
+	pageSize = 500
+
+	// maxConcurrentPages limits the number of concurrent zonal instance list requests.
+	maxConcurrentPages = 8
+)
+
+// apiClients are the Compute Engine API clients, created once so that their
+// access tokens are cached and refreshed across calls, e.g. by the daemon.
+var apiClients struct {
+	mu        sync.Mutex
+	instances *compute.InstancesClient
+	zones     *compute.ZonesClient
+}
+
+// computeClients returns the Compute Engine API clients authenticated with the
+// Application Default Credentials. Failures aren't cached, so the daemon
+// recovers once the credentials are fixed.
+func computeClients() (*compute.InstancesClient, *compute.ZonesClient, error) {
+	apiClients.mu.Lock()
+	defer apiClients.mu.Unlock()
+
+	if apiClients.instances != nil {
+		return apiClients.instances, apiClients.zones, nil
+	}
+
+	// The token source refreshes tokens with this context, so it must outlive any call.
+	ctx := context.Background()
+
+	ts, err := tokenSource(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	instances, err := compute.NewInstancesRESTClient(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		return nil, nil, fmt.Errorf("new instances client error: %w", err)
+	}
+
+	zones, err := compute.NewZonesRESTClient(ctx, option.WithTokenSource(ts))
+	if err != nil {
+		_ = instances.Close()
+		return nil, nil, fmt.Errorf("new zones client error: %w", err)
+	}
+
+	apiClients.instances, apiClients.zones = instances, zones
+
+	return instances, zones, nil
+}
+
+// FetchAPI returns the instances of the project listed via the Compute Engine
+// API using Application Default Credentials. Found is called for each instance
+// as its page arrives. If the aggregated list spans multiple pages, the
+// remaining instances are listed per zone concurrently.
+func FetchAPI(ctx context.Context, project string, found func(Instance)) ([]Instance, error) {
+	if project == "" {
+		return nil, errors.New("project required")
+	}
+
+	instancesClient, zonesClient, err := computeClients()
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		mu        sync.Mutex
+		instances []Instance
+		seen      = make(map[string]bool)
+	)
+	add := func(insts []*computepb.Instance) error {
+		mu.Lock()
+		defer mu.Unlock()
+
+		for _, p := range insts {
+			inst, err := fromProto(p)
+			if err != nil {
+				return err
+			}
+
+			key := inst.Zone + "/" + inst.Name
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			found(inst)
+			instances = append(instances, inst)
+		}
+
+		return nil
+	}
+
+	it := instancesClient.AggregatedList(callctx.SetHeaders(ctx, fieldMaskHeader, aggregatedFields), &computepb.AggregatedListInstancesRequest{
+		Project:              project,
+		ReturnPartialSuccess: proto.Bool(true),
+	})
+	var pairs []compute.InstancesScopedListPair
+	next, err := iterator.NewPager(it, pageSize, "").NextPage(&pairs)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pair := range pairs {
+		if err := add(pair.Value.GetInstances()); err != nil {
+			return nil, err
+		}
+	}
+
+	if next == "" {
+		return instances, nil
+	}
+
+	// Paging through the aggregated list is sequential, so list the zones concurrently instead.
+	zones, err := listZones(ctx, zonesClient, project)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		sem  = make(chan struct{}, maxConcurrentPages)
+		errc = make(chan error, len(zones))
+		wg   sync.WaitGroup
+	)
+	for _, zone := range zones {
+		wg.Add(1)
+		go func(zone string) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			errc <- listZone(ctx, instancesClient, project, zone, add)
+		}(zone)
+	}
+	wg.Wait()
+	close(errc)
+
+	for err := range errc {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return instances, nil
+}
+
+// listZones returns the zone names of the project.
+func listZones(ctx context.Context, client *compute.ZonesClient, project string) ([]string, error) {
+	it := client.List(callctx.SetHeaders(ctx, fieldMaskHeader, "items/name,nextPageToken"), &computepb.ListZonesRequest{Project: project})
+
+	var zones []string
+	for {
+		zone, err := it.Next()
+		if errors.Is(err, iterator.Done) {
+			return zones, nil
+		} else if err != nil {
+			return nil, fmt.Errorf("list zones error: %w", err)
+		}
+		zones = append(zones, zone.GetName())
+	}
+}
+
+// listZone pages through the instances of the zone, calling add for each page.
+func listZone(ctx context.Context, client *compute.InstancesClient, project string, zone string, add func([]*computepb.Instance) error) error {
+	it := client.List(callctx.SetHeaders(ctx, fieldMaskHeader, zoneFields), &computepb.ListInstancesRequest{
+		Project:              project,
+		Zone:                 zone,
+		ReturnPartialSuccess: proto.Bool(true),
+	})
+
+	pager := iterator.NewPager(it, pageSize, "")
+	for {
+		var page []*computepb.Instance
+		next, err := pager.NextPage(&page)
+		if err != nil {
+			return err
+		} else if err := add(page); err != nil {
+			return err
+		} else if next == "" {
+			return nil
+		}
+	}
+}
+
+// fromProto returns the instance of the API response, whose JSON encoding is
+// the same as gcloud's.
+func fromProto(p *computepb.Instance) (Instance, error) {
+	b, err := protojson.Marshal(p)
+	if err != nil {
+		return Instance{}, fmt.Errorf("marshal instance error: %w", err)
+	}
+
+	var inst Instance
+	if err := json.Unmarshal(b, &inst); err != nil {
+		return Instance{}, fmt.Errorf("unmarshal instance error: %w", err)
+	}
+
+	return inst, nil
+}
+
+// tokenSource returns the token source of the Application Default Credentials,
+// impersonating the service account in CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT if set.
+// All ADC types are supported, including external_account (workload identity
+// federation) and impersonated_service_account.
+func tokenSource(ctx context.Context) (oauth2.TokenSource, error) {
+	creds, err := findCredentials(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("no application default credentials found, run `gcloud auth application-default login`: %w", err)
+	}
+
+	chain := os.Getenv("CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT")
+	if chain == "" {
+		return creds.TokenSource, nil
+	}
+
+	// The others are delegates as in gcloud's --impersonate-service-account.
+	accounts := strings.Split(chain, ",")
+	ts, err := impersonate.CredentialsTokenSource(ctx, impersonate.CredentialsConfig{
+		TargetPrincipal: accounts[len(accounts)-1],
+		Delegates:       accounts[:len(accounts)-1],
+		Scopes:          []string{scope},
+	}, option.WithCredentials(creds))
+	if err != nil {
+		return nil, fmt.Errorf("impersonate %s error: %w", accounts[len(accounts)-1], err)
+	}
+
+	return ts, nil
+}
+
+// findCredentials returns the Application Default Credentials. Unlike the
+// client library, it also finds the gcloud ADC file in $CLOUDSDK_CONFIG.
+func findCredentials(ctx context.Context) (*google.Credentials, error) {
+	if _, ok := os.LookupEnv("GOOGLE_APPLICATION_CREDENTIALS"); !ok {
+		if b, err := os.ReadFile(filepath.Join(gcloudConfigDir(), "application_default_credentials.json")); err == nil {
+			var f struct {
+				Type string `json:"type"`
+			}
+			if err := json.Unmarshal(b, &f); err != nil {
+				return nil, fmt.Errorf("unmarshal credentials error: %w", err)
+			}
+
+			return google.CredentialsFromJSONWithType(ctx, b, google.CredentialsType(f.Type), scope)
+		}
+	}
+
+	return google.FindDefaultCredentials(ctx, scope)
+}
+
+// ValidateADC returns an error if no access token can be obtained from the
+// Application Default Credentials used by the Compute Engine API backend.
+func ValidateADC(ctx context.Context) error {
+	ts, err := tokenSource(ctx)
+	if err != nil {
+		return err
+	}
+
+	_, err = ts.Token()
+
+	return err
+}
+
+// apiStatus returns the HTTP status code of the Google API error, if it is one.
+func apiStatus(err error) (int, bool) {
+	var apiErr *apierror.APIError
+	if errors.As(err, &apiErr) && apiErr.HTTPCode() > 0 {
+		return apiErr.HTTPCode(), true
+	}
+
+	var httpErr apiError
+	if errors.As(err, &httpErr) {
+		return httpErr.Code, true
+	}
+
+	return 0, false
+}
@@ -0,0 +1,74 @@
+package inventory
+
+import (
+	"context"
+	"strings"
+)
+
+// NewAssetFetcher returns a Fetcher that lists the instances of all projects
+// under the organization or folder scope, e.g. "organizations/123" or
+// "folders/456", via Cloud Asset Inventory.
+func NewAssetFetcher(gc Gcloud) Fetcher {
+	return func(ctx context.Context, scope string, found func(Instance)) ([]Instance, error) {
+		var results []struct {
+			Name                 string            `json:"name"`
+			Location             string            `json:"location"`
+			State                string            `json:"state"`
+			Labels               map[string]string `json:"labels"`
+			AdditionalAttributes struct {
+				InternalIPs []string `json:"internalIPs"`
+				ExternalIPs []string `json:"externalIPs"`
+			} `json:"additionalAttributes"`
+		}
+		err := gc.JSON(ctx, &results, "asset", "search-all-resources", "--scope="+scope,
+			"--asset-types=compute.googleapis.com/Instance",
+			"--format=json(name,location,state,labels,additionalAttributes)")
+		if err != nil {
+			return nil, err
+		}
+
+		var instances []Instance
+		for _, r := range results {
+			// Names are formatted as //compute.googleapis.com/projects/PROJECT/zones/ZONE/instances/NAME.
+			parts := strings.Split(r.Name, "/")
+			if len(parts) < 4 {
+				continue
+			}
+
+			inst := Instance{
+				Name:    parts[len(parts)-1],
+				Zone:    r.Location,
+				Status:  r.State,
+				Labels:  r.Labels,
+				Project: projectOf(parts),
+			}
+
+			var nic NetworkInterface
+			if len(r.AdditionalAttributes.InternalIPs) > 0 {
+				nic.NetworkIP = r.AdditionalAttributes.InternalIPs[0]
+			}
+			for _, ip := range r.AdditionalAttributes.ExternalIPs {
+				nic.AccessConfigs = append(nic.AccessConfigs, struct {
+					NatIP string `json:",omitempty"`
+				}{NatIP: ip})
+			}
+			inst.NetworkInterfaces = []NetworkInterface{nic}
+
+			found(inst)
+			instances = append(instances, inst)
+		}
+
+		return instances, nil
+	}
+}
+
+// projectOf returns the project following "projects" in the resource name parts.
+func projectOf(parts []string) string {
+	for i := 0; i < len(parts)-1; i++ {
+		if parts[i] == "projects" {
+			return parts[i+1]
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,133 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// ProviderAzure is the provider of Azure VMs.
+const ProviderAzure = "azure"
+
+// AzureBin is the az cli binary, it defaults to az in the PATH.
+var AzureBin = "az"
+
+// Azure is the provider of the Azure VMs of the subscriptions, listed via the
+// az cli and cached like the VM lists. The instances' ID is their resource ID,
+// their zone the location and their project the subscription.
+type Azure struct {
+	Subscriptions []string
+	// Lister configures the caching of the instance lists, its Fetch is ignored.
+	Lister Lister
+	// Timeout is the maximum duration of a single az invocation.
+	Timeout time.Duration
+	// Runner runs the az subprocesses, it defaults to runner.Exec.
+	Runner runner.Runner
+}
+
+// Name returns ProviderAzure.
+func (Azure) Name() string {
+	return ProviderAzure
+}
+
+// List returns the VMs of the subscriptions, skipping subscriptions that fail to list.
+func (p Azure) List(ctx context.Context) ([]Instance, error) {
+	var (
+		instances []Instance
+		lastErr   error
+	)
+	for _, sub := range p.Subscriptions {
+		sub := sub
+		l := p.Lister
+		l.SkipDaemon = true
+		l.Fetch = func(ctx context.Context, _ string, _ func(Instance)) ([]Instance, error) {
+			return p.fetch(ctx, sub)
+		}
+
+		listed, _, err := l.List(ctx, "azure-"+sub, func(Instance) {})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		instances = append(instances, listed...)
+	}
+
+	if len(instances) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return instances, nil
+}
+
+// azureVM is a VM in the az vm list --show-details output.
+type azureVM struct {
+	Name            string
+	ID              string
+	Location        string
+	PowerState      string
+	PrivateIPs      string `json:"privateIps"`
+	PublicIPs       string `json:"publicIps"`
+	Tags            map[string]string
+	HardwareProfile struct {
+		VMSize string `json:"vmSize"`
+	}
+}
+
+// fetch returns the VMs of the subscription.
+func (p Azure) fetch(ctx context.Context, subscription string) ([]Instance, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	r := p.Runner
+	if r == nil {
+		r = runner.Exec{}
+	}
+
+	output, err := runner.Output(ctx, r, runner.Cmd{Name: AzureBin, Args: []string{
+		"vm", "list", "--show-details", "--subscription", subscription, "--output", "json",
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("az vm list error: %w, %s", err, output)
+	}
+
+	var resp []azureVM
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal azure vms error: %w", err)
+	}
+
+	var instances []Instance
+	for _, vm := range resp {
+		inst := Instance{
+			Name:        vm.Name,
+			ID:          vm.ID,
+			Zone:        vm.Location,
+			Status:      strings.ToUpper(strings.TrimPrefix(vm.PowerState, "VM ")),
+			MachineType: vm.HardwareProfile.VMSize,
+			Labels:      vm.Tags,
+			Project:     subscription,
+			Provider:    ProviderAzure,
+		}
+
+		// Multiple IPs are comma separated, use the first.
+		private, _, _ := strings.Cut(vm.PrivateIPs, ",")
+		public, _, _ := strings.Cut(vm.PublicIPs, ",")
+		nic := NetworkInterface{NetworkIP: private}
+		if public != "" {
+			nic.AccessConfigs = append(nic.AccessConfigs, struct {
+				NatIP string `json:",omitempty"`
+			}{NatIP: public})
+		}
+		inst.NetworkInterfaces = []NetworkInterface{nic}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,172 @@
+package inventory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Cache is the on-disk cache of a project's instance list.
+type Cache struct {
+	Fetched   time.Time  `json:"fetched"`
+	Instances []Instance `json:"instances"`
+}
+
+// LoadCache loads the cached instance list of the project.
+func LoadCache(project string) (Cache, error) {
+	filename, err := cachePath(project)
+	if err != nil {
+		return Cache{}, err
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return Cache{}, fmt.Errorf("read cache error: %w", err)
+	}
+
+	var c Cache
+	err = json.Unmarshal(b, &c)
+	if err != nil {
+		return Cache{}, fmt.Errorf("unmarshal cache error: %w", err)
+	}
+
+	return c, nil
+}
+
+// StoreCache stores the instance list of the project in the cache.
+func StoreCache(project string, instances []Instance) error {
+	b, err := json.Marshal(Cache{Fetched: time.Now(), Instances: instances})
+	if err != nil {
+		return fmt.Errorf("marshal cache error: %w", err)
+	}
+
+	filename, err := cachePath(project)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(filename), 0755)
+	if err != nil {
+		return fmt.Errorf("create cache dir error: %w", err)
+	}
+
+	err = writeAtomic(filename, b, 0644)
+	if err != nil {
+		return fmt.Errorf("write cache error: %w", err)
+	}
+
+	return nil
+}
+
+// writeAtomic writes the file via a temp file in the same directory that is
+// renamed over it, so concurrent listings and the daemon never read a
+// truncated cache.
+func writeAtomic(filename string, b []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // No-op after the rename.
+
+	if _, err := f.Write(b); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Chmod(perm); err != nil {
+		_ = f.Close()
+		return err
+	} else if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filename)
+}
+
+// CachedNames returns the sorted unique names of the instances in all caches,
+// e.g. for shell completion which must not invoke gcloud.
+func CachedNames() ([]string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return nil, fmt.Errorf("cache dir error: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "gssh", "instances-*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("glob cache error: %w", err)
+	}
+
+	unique := make(map[string]bool)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		var c Cache
+		if err := json.Unmarshal(b, &c); err != nil {
+			continue
+		}
+		for _, inst := range c.Instances {
+			unique[inst.Name] = true
+		}
+	}
+
+	var names []string
+	for name := range unique {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// CacheHealth returns the number of cached instance lists, the age of the
+// oldest one and the files that cannot be parsed.
+func CacheHealth() (int, time.Duration, []string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("cache dir error: %w", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "gssh", "instances-*.json"))
+	if err != nil {
+		return 0, 0, nil, fmt.Errorf("glob cache error: %w", err)
+	}
+
+	var (
+		oldest  time.Duration
+		corrupt []string
+	)
+	for _, file := range files {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			corrupt = append(corrupt, file)
+			continue
+		}
+
+		var c Cache
+		if err := json.Unmarshal(b, &c); err != nil {
+			corrupt = append(corrupt, file)
+			continue
+		}
+		oldest = max(oldest, time.Since(c.Fetched))
+	}
+
+	return len(files), oldest, corrupt, nil
+}
+
+// cachePath returns the path to the instance list cache file of the project.
+func cachePath(project string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache dir error: %w", err)
+	}
+
+	// Organization and folder scopes contain slashes.
+	name := strings.ReplaceAll(project, "/", "-")
+
+	return filepath.Join(dir, "gssh", "instances-"+name+".json"), nil
+}
@@ -0,0 +1,120 @@
+package inventory
+
+import (
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// familyRates are the approximate on-demand us-central1 USD rates per vCPU hour
+// and per GB of memory hour of the machine families.
+var familyRates = map[string]struct{ cpu, mem float64 }{
+	"e2":  {0.021811, 0.002923},
+	"n1":  {0.031611, 0.004237},
+	"n2":  {0.031611, 0.004237},
+	"n2d": {0.027502, 0.003686},
+	"n4":  {0.030900, 0.003800},
+	"t2d": {0.027502, 0.003686},
+	"t2a": {0.022000, 0.002750},
+	"c2":  {0.033982, 0.004555},
+	"c2d": {0.029563, 0.003959},
+	"c3":  {0.034650, 0.003938},
+	"c3d": {0.029950, 0.004040},
+	"m1":  {0.034806, 0.005101},
+}
+
+// sharedCoreCosts are the approximate on-demand us-central1 USD hourly costs of shared-core machine types.
+var sharedCoreCosts = map[string]float64{
+	"e2-micro":  0.0084,
+	"e2-small":  0.0168,
+	"e2-medium": 0.0335,
+	"f1-micro":  0.0076,
+	"g1-small":  0.0257,
+}
+
+// memPerCPU are the GB of memory per vCPU of the predefined machine type classes,
+// n1 excepted.
+var memPerCPU = map[string]float64{
+	"standard": 4,
+	"highmem":  8,
+	"highcpu":  1,
+}
+
+// n1MemPerCPU are the GB of memory per vCPU of the n1 machine type classes.
+var n1MemPerCPU = map[string]float64{
+	"standard": 3.75,
+	"highmem":  6.5,
+	"highcpu":  0.9,
+}
+
+// HourlyCost returns the approximate on-demand USD hourly cost of the instance's
+// machine type, zero if it is not running, or false if the machine type is unknown.
+// It excludes disks, GPUs, licenses and discounts.
+func (i Instance) HourlyCost() (float64, bool) {
+	if i.Status != "" && i.Status != "RUNNING" {
+		return 0, true
+	}
+
+	return machineTypeCost(filepath.Base(i.MachineType))
+}
+
+// CostLabel returns the approximate hourly cost of the instance formatted as
+// e.g. "$0.39/h", "-" if it is not running or "?" if the machine type is unknown.
+func (i Instance) CostLabel() string {
+	if i.Status != "" && i.Status != "RUNNING" {
+		return "-"
+	}
+
+	cost, ok := i.HourlyCost()
+	if !ok {
+		return "?"
+	}
+
+	return fmt.Sprintf("$%.2f/h", cost)
+}
+
+// machineTypeCost returns the approximate USD hourly cost of the predefined,
+// e.g. n2-standard-8, or custom, e.g. n2-custom-4-8192, machine type.
+func machineTypeCost(machineType string) (float64, bool) {
+	if cost, ok := sharedCoreCosts[machineType]; ok {
+		return cost, true
+	}
+
+	parts := strings.Split(machineType, "-")
+	if len(parts) == 3 && parts[0] == "custom" {
+		// Custom N1 machine types are named custom-CPUS-MEMORY.
+		parts = append([]string{"n1"}, parts...)
+	}
+	if len(parts) < 3 {
+		return 0, false
+	}
+
+	rates, ok := familyRates[parts[0]]
+	if !ok {
+		return 0, false
+	}
+
+	cpus, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, false
+	}
+
+	var mem float64
+	if parts[1] == "custom" && len(parts) >= 4 {
+		mb, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return 0, false
+		}
+		mem = mb / 1024
+	} else if parts[0] == "n1" {
+		mem = cpus * n1MemPerCPU[parts[1]]
+	} else {
+		mem = cpus * memPerCPU[parts[1]]
+	}
+	if mem == 0 {
+		return 0, false
+	}
+
+	return cpus*rates.cpu + mem*rates.mem, true
+}
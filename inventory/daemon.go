@@ -0,0 +1,333 @@
+package inventory
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// daemonRequest is a request sent by gssh to the daemon.
+type daemonRequest struct {
+	Project string `json:"project"`
+	// Connect reports a connection to a VM of the project instead of requesting its instance list.
+	Connect bool `json:"connect,omitempty"`
+}
+
+// daemonResponse is the daemon's response to a daemonRequest.
+type daemonResponse struct {
+	Cache Cache  `json:"cache"`
+	Error string `json:"error,omitempty"`
+}
+
+// Bounds of the projects requested by clients that are kept warm in addition to the configured ones.
+const (
+	// maxRequested is the max number of requested projects kept warm, the least recently requested is dropped first.
+	maxRequested = 20
+	// requestedIdle is the duration after which a requested project is dropped if not requested again.
+	requestedIdle = time.Hour
+)
+
+// RunDaemon runs the gssh daemon that keeps the instance lists of the projects
+// warm and serves them over a unix socket until the context is cancelled.
+// Projects requested by clients that are not in the list are kept warm too,
+// until they aren't requested for an hour or more than 20 others are requested.
+// If metricsAddr is not empty, Prometheus metrics are served on it.
+func RunDaemon(ctx context.Context, fetch Fetcher, projects []string, ttl time.Duration, metricsAddr string) error {
+	filename, err := socketPath()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return fmt.Errorf("create socket dir error: %w", err)
+	}
+
+	if err := os.Remove(filename); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("remove stale socket error: %w", err)
+	}
+
+	ln, err := net.Listen("unix", filename)
+	if err != nil {
+		return fmt.Errorf("listen error: %w", err)
+	}
+	defer ln.Close()
+
+	go func() {
+		<-ctx.Done()
+		_ = ln.Close()
+	}()
+
+	d := &daemon{
+		ctx:    ctx,
+		ttl:    ttl,
+		fetch:  fetch,
+		caches: make(map[string]Cache),
+		warmed: make(map[string]*warmProject),
+		stats:  make(map[string]*projectStats),
+	}
+
+	if metricsAddr != "" {
+		if err := d.serveMetrics(ctx, metricsAddr); err != nil {
+			return err
+		}
+	}
+
+	for _, project := range projects {
+		d.warm(project, true)
+	}
+
+	slog.Info("Serving instance lists", "socket", filename, "projects", projects, "ttl", ttl)
+
+	for {
+		conn, err := ln.Accept()
+		if ctx.Err() != nil {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("accept error: %w", err)
+		}
+
+		go d.serve(conn)
+	}
+}
+
+// daemon holds the warm instance lists by project.
+type daemon struct {
+	ctx   context.Context
+	ttl   time.Duration
+	fetch Fetcher
+
+	mu     sync.Mutex
+	caches map[string]Cache
+	warmed map[string]*warmProject
+	stats  map[string]*projectStats
+}
+
+// warmProject is a project whose instance list is kept warm.
+type warmProject struct {
+	// ready is closed once the first list is available.
+	ready chan struct{}
+	// cancel stops refreshing the list.
+	cancel context.CancelFunc
+	// pinned projects are configured and never dropped.
+	pinned bool
+	// requested is when the list was last requested by a client.
+	requested time.Time
+}
+
+// warm starts keeping the instance list of the project warm, if not already,
+// and records the request of unpinned projects. It returns a channel that is
+// closed once the first list is available.
+func (d *daemon) warm(project string, pinned bool) <-chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if w, ok := d.warmed[project]; ok {
+		w.requested = time.Now()
+		return w.ready
+	}
+
+	if !pinned {
+		d.evictRequested()
+	}
+
+	ctx, cancel := context.WithCancel(d.ctx)
+	w := &warmProject{ready: make(chan struct{}), cancel: cancel, pinned: pinned, requested: time.Now()}
+	d.warmed[project] = w
+	stats := d.projectStats(project)
+
+	go func() {
+		var once sync.Once
+		// Unblock waiting clients if the project is dropped before its first list.
+		defer once.Do(func() { close(w.ready) })
+
+		for {
+			t0 := time.Now()
+			instances, err := d.fetch(ctx, project, func(Instance) {})
+			if ctx.Err() != nil {
+				return
+			} else if err != nil {
+				slog.Error("Failed to refresh instances", "project", project, "err", err)
+
+				d.mu.Lock()
+				stats.listErrors++
+				d.mu.Unlock()
+			} else {
+				c := Cache{Fetched: time.Now(), Instances: Sort(instances)}
+
+				d.mu.Lock()
+				d.caches[project] = c
+				stats.listDuration = time.Since(t0)
+				d.mu.Unlock()
+
+				if err := StoreCache(project, instances); err != nil {
+					slog.Debug("Failed to store cache", "err", err)
+				}
+			}
+
+			once.Do(func() { close(w.ready) })
+
+			// Refresh at half the TTL so that served lists are always younger than the TTL.
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(d.ttl / 2):
+			}
+
+			if d.dropIdle(project, w) {
+				return
+			}
+		}
+	}()
+
+	return w.ready
+}
+
+// dropIdle stops keeping the unpinned project warm if it wasn't requested
+// recently, and returns true if it was dropped.
+func (d *daemon) dropIdle(project string, w *warmProject) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if w.pinned || time.Since(w.requested) < requestedIdle {
+		return false
+	}
+
+	slog.Info("Dropping idle project", "project", project, "requested", w.requested.Format(time.DateTime))
+	d.drop(project)
+
+	return true
+}
+
+// evictRequested drops the least recently requested unpinned project if
+// adding another would exceed maxRequested. It must be called with mu held.
+func (d *daemon) evictRequested() {
+	var (
+		oldest  string
+		current int
+	)
+	for project, w := range d.warmed {
+		if w.pinned {
+			continue
+		}
+		current++
+		if oldest == "" || w.requested.Before(d.warmed[oldest].requested) {
+			oldest = project
+		}
+	}
+
+	if current < maxRequested {
+		return
+	}
+
+	slog.Info("Dropping least recently requested project", "project", oldest, "max", maxRequested)
+	d.drop(oldest)
+}
+
+// drop stops keeping the project warm and forgets its list. It must be called with mu held.
+func (d *daemon) drop(project string) {
+	d.warmed[project].cancel()
+	delete(d.warmed, project)
+	delete(d.caches, project)
+}
+
+// serve handles a single gssh connection.
+func (d *daemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var req daemonRequest
+	if err := json.NewDecoder(bufio.NewReader(conn)).Decode(&req); err != nil {
+		slog.Debug("Failed to decode request", "err", err)
+		return
+	}
+
+	if req.Connect {
+		d.mu.Lock()
+		d.projectStats(req.Project).connections++
+		d.mu.Unlock()
+
+		return
+	}
+
+	<-d.warm(req.Project, false)
+
+	d.mu.Lock()
+	c, ok := d.caches[req.Project]
+	d.stats[req.Project].requests++
+	d.mu.Unlock()
+
+	var resp daemonResponse
+	if ok {
+		resp.Cache = c
+	} else {
+		resp.Error = "instance list not available"
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		slog.Debug("Failed to encode response", "err", err)
+	}
+}
+
+// ReportConnection reports a connection to a VM of the project to the daemon
+// for its metrics. It does nothing if the daemon is not running.
+func ReportConnection(project string) {
+	filename, err := socketPath()
+	if err != nil {
+		return
+	}
+
+	conn, err := net.DialTimeout("unix", filename, 100*time.Millisecond)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	_ = json.NewEncoder(conn).Encode(daemonRequest{Project: project, Connect: true})
+}
+
+// QueryDaemon returns the instance list of the project from the daemon.
+// It returns an error if the daemon is not running.
+func QueryDaemon(project string) (Cache, error) {
+	filename, err := socketPath()
+	if err != nil {
+		return Cache{}, err
+	}
+
+	conn, err := net.DialTimeout("unix", filename, 100*time.Millisecond)
+	if err != nil {
+		return Cache{}, fmt.Errorf("dial daemon error: %w", err)
+	}
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(daemonRequest{Project: project}); err != nil {
+		return Cache{}, fmt.Errorf("send daemon request error: %w", err)
+	}
+
+	var resp daemonResponse
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return Cache{}, fmt.Errorf("read daemon response error: %w", err)
+	}
+
+	if resp.Error != "" {
+		return Cache{}, errors.New(resp.Error)
+	}
+
+	return resp.Cache, nil
+}
+
+// socketPath returns the path to the daemon's unix socket.
+func socketPath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache dir error: %w", err)
+	}
+
+	return filepath.Join(dir, "gssh", "daemon.sock"), nil
+}
@@ -0,0 +1,152 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// ProviderEC2 is the provider of AWS EC2 instances.
+const ProviderEC2 = "ec2"
+
+// AWSBin is the aws cli binary, it defaults to aws in the PATH.
+var AWSBin = "aws"
+
+// EC2 is the provider of the EC2 instances of the regions, listed via the aws
+// cli and cached like the VM lists. The aws cli uses the $AWS_PROFILE profile.
+// The instances are named by their Name tag, their project is the region.
+type EC2 struct {
+	Regions []string
+	// Lister configures the caching of the instance lists, its Fetch is ignored.
+	Lister Lister
+	// Timeout is the maximum duration of a single aws invocation.
+	Timeout time.Duration
+	// Runner runs the aws subprocesses, it defaults to runner.Exec.
+	Runner runner.Runner
+}
+
+// Name returns ProviderEC2.
+func (EC2) Name() string {
+	return ProviderEC2
+}
+
+// List returns the EC2 instances of the regions, skipping regions that fail to list.
+func (p EC2) List(ctx context.Context) ([]Instance, error) {
+	var (
+		instances []Instance
+		lastErr   error
+	)
+	for _, region := range p.Regions {
+		region := region
+		l := p.Lister
+		l.SkipDaemon = true
+		l.Fetch = func(ctx context.Context, _ string, _ func(Instance)) ([]Instance, error) {
+			return p.fetch(ctx, region)
+		}
+
+		// Cache the lists per profile and region.
+		key := "ec2-" + region
+		if profile := os.Getenv("AWS_PROFILE"); profile != "" {
+			key = "ec2-" + profile + "-" + region
+		}
+
+		listed, _, err := l.List(ctx, key, func(Instance) {})
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		instances = append(instances, listed...)
+	}
+
+	if len(instances) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+
+	return instances, nil
+}
+
+// ec2Instance is an instance in the aws ec2 describe-instances output.
+type ec2Instance struct {
+	InstanceID   string `json:"InstanceId"`
+	InstanceType string
+	Placement    struct {
+		AvailabilityZone string
+	}
+	State struct {
+		Name string
+	}
+	PrivateIPAddress string `json:"PrivateIpAddress"`
+	PublicIPAddress  string `json:"PublicIpAddress"`
+	Tags             []struct {
+		Key   string
+		Value string
+	}
+}
+
+// fetch returns the non-terminated EC2 instances of the region.
+func (p EC2) fetch(ctx context.Context, region string) ([]Instance, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	r := p.Runner
+	if r == nil {
+		r = runner.Exec{}
+	}
+
+	output, err := runner.Output(ctx, r, runner.Cmd{Name: AWSBin, Args: []string{
+		"ec2", "describe-instances", "--region", region, "--output", "json",
+		"--filters", "Name=instance-state-name,Values=pending,running,stopping,stopped",
+		"--query", "Reservations[].Instances[]",
+	}})
+	if err != nil {
+		return nil, fmt.Errorf("aws ec2 describe-instances error: %w, %s", err, output)
+	}
+
+	var resp []ec2Instance
+	if err := json.Unmarshal(output, &resp); err != nil {
+		return nil, fmt.Errorf("unmarshal ec2 instances error: %w", err)
+	}
+
+	var instances []Instance
+	for _, e := range resp {
+		inst := Instance{
+			Name:        e.InstanceID,
+			ID:          e.InstanceID,
+			Zone:        e.Placement.AvailabilityZone,
+			Status:      strings.ToUpper(e.State.Name),
+			MachineType: e.InstanceType,
+			Project:     region,
+			Provider:    ProviderEC2,
+		}
+		for _, tag := range e.Tags {
+			if tag.Key == "Name" && tag.Value != "" {
+				inst.Name = tag.Value
+				continue
+			}
+			if inst.Labels == nil {
+				inst.Labels = make(map[string]string)
+			}
+			inst.Labels[tag.Key] = tag.Value
+		}
+
+		nic := NetworkInterface{NetworkIP: e.PrivateIPAddress}
+		if e.PublicIPAddress != "" {
+			nic.AccessConfigs = append(nic.AccessConfigs, struct {
+				NatIP string `json:",omitempty"`
+			}{NatIP: e.PublicIPAddress})
+		}
+		inst.NetworkInterfaces = []NetworkInterface{nic}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,82 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Fetcher fetches the instances of a project, calling found for each as it arrives.
+// An empty project fetches the instances of the gcloud config project, if supported.
+type Fetcher func(ctx context.Context, project string, found func(Instance)) ([]Instance, error)
+
+// NewFetcher returns a Fetcher that lists instances via gcloud or the Compute Engine API if useAPI.
+func NewFetcher(gc Gcloud, useAPI bool) Fetcher {
+	if useAPI {
+		return FetchAPI
+	}
+
+	return func(ctx context.Context, project string, found func(Instance)) ([]Instance, error) {
+		return FetchGcloud(ctx, gc, project, found)
+	}
+}
+
+// FetchGcloud returns the instances of the project listed by gcloud.
+// The JSON output is decoded incrementally and found is called for each
+// instance as it arrives.
+func FetchGcloud(ctx context.Context, gc Gcloud, project string, found func(Instance)) ([]Instance, error) {
+	var stderr bytes.Buffer
+	pr, pw := io.Pipe()
+	errc := make(chan error, 1)
+	go func() {
+		args := []string{"compute", "instances", "list", "--format=json(" + gcloudFields + ")"}
+		if project != "" {
+			args = append(args, "--project="+project)
+		}
+		err := gc.Run(ctx, pw, &stderr, args...)
+		_ = pw.Close()
+		errc <- err
+	}()
+
+	instances, decodeErr := decodeInstances(pr, found)
+
+	// Drain the pipe so gcloud can exit even if decoding failed.
+	_, _ = io.Copy(io.Discard, pr)
+
+	if err := <-errc; err != nil {
+		return nil, fmt.Errorf("gcloud compute instances list error: %w, %s", err, stderr.Bytes())
+	} else if decodeErr != nil {
+		return nil, fmt.Errorf("unmarshal instances error: %w", decodeErr)
+	}
+
+	return instances, nil
+}
+
+// decodeInstances decodes a JSON array of instances one element at a time,
+// calling found for each.
+func decodeInstances(r io.Reader, found func(Instance)) ([]Instance, error) {
+	dec := json.NewDecoder(r)
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	var instances []Instance
+	for dec.More() {
+		var inst Instance
+		if err := dec.Decode(&inst); err != nil {
+			return nil, err
+		}
+
+		found(inst)
+		instances = append(instances, inst)
+	}
+
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,291 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// GcloudBin is the gcloud binary, it defaults to gcloud in the PATH.
+var GcloudBin = defaultGcloudBin()
+
+// defaultGcloudBin returns gcloud, or gcloud.cmd on windows where the Cloud SDK
+// ships a batch file that ssh's ProxyCommand doesn't resolve without its extension.
+func defaultGcloudBin() string {
+	if runtime.GOOS == "windows" {
+		return "gcloud.cmd"
+	}
+
+	return "gcloud"
+}
+
+// minGcloudVersion is the oldest supported gcloud version.
+const minGcloudVersion = "400.0.0"
+
+// Gcloud runs gcloud subcommands.
+type Gcloud struct {
+	// Timeout is the maximum duration of a single gcloud invocation.
+	Timeout time.Duration
+	// Runner runs the gcloud subprocesses, it defaults to runner.Exec.
+	Runner runner.Runner
+}
+
+// Output runs the gcloud subcommand and returns its combined output.
+func (g Gcloud) Output(ctx context.Context, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
+	defer cancel()
+
+	output, err := runner.Output(ctx, g.runner(), runner.Cmd{Name: GcloudBin, Args: args})
+	if err != nil {
+		return output, g.err(ctx, err)
+	}
+
+	return output, nil
+}
+
+// Run runs the gcloud subcommand writing its output to stdout and stderr.
+func (g Gcloud) Run(ctx context.Context, stdout, stderr io.Writer, args ...string) error {
+	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
+	defer cancel()
+
+	err := g.runner().Run(ctx, runner.Cmd{Name: GcloudBin, Args: args, Stdout: stdout, Stderr: stderr})
+	if err != nil {
+		return g.err(ctx, err)
+	}
+
+	return nil
+}
+
+// Version returns the gcloud version and an error if it is older than the
+// oldest supported version.
+func (g Gcloud) Version(ctx context.Context) (string, error) {
+	var resp struct {
+		Version string `json:"Google Cloud SDK"`
+	}
+	if err := g.JSON(ctx, &resp, "version", "--format=json"); err != nil {
+		return "", err
+	}
+
+	if compareVersions(resp.Version, minGcloudVersion) < 0 {
+		return resp.Version, fmt.Errorf("gcloud %s is older than the oldest supported version %s, run `gcloud components update`", resp.Version, minGcloudVersion)
+	}
+
+	return resp.Version, nil
+}
+
+// compareVersions compares the dot separated numeric versions, returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < max(len(as), len(bs)); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// Describe returns the current state of the instance.
+func (g Gcloud) Describe(ctx context.Context, inst Instance) (Instance, error) {
+	var resp Instance
+	err := g.JSON(ctx, &resp, "compute", "instances", "describe", inst.Name,
+		"--zone="+inst.TrimZone(), "--project="+inst.Project, "--format=json("+gcloudFields+")")
+	if err != nil {
+		return Instance{}, err
+	}
+	resp.Project = inst.Project
+
+	return resp, nil
+}
+
+// InstanceOp runs the gcloud compute instances operation, e.g. start or stop,
+// on the instance with the extra flags and waits for it to complete.
+func (g Gcloud) InstanceOp(ctx context.Context, op string, inst Instance, flags ...string) error {
+	args := append([]string{"compute", "instances", op, inst.Name, "--zone=" + inst.TrimZone(), "--project=" + inst.Project}, flags...)
+	output, err := g.Output(ctx, args...)
+	if err != nil {
+		return fmt.Errorf("gcloud compute instances %s error: %w, %s", op, err, bytes.TrimSpace(output))
+	}
+
+	return nil
+}
+
+// JSON runs the gcloud subcommand and unmarshals its JSON output into v.
+func (g Gcloud) JSON(ctx context.Context, v any, args ...string) error {
+	cmd := "gcloud " + strings.Join(args[:min(len(args), 3)], " ")
+
+	var stdout, stderr bytes.Buffer
+	err := retry(ctx, cmd, func() error {
+		stdout.Reset()
+		stderr.Reset()
+		if err := g.Run(ctx, &stdout, &stderr, args...); err != nil {
+			return fmt.Errorf("%s error: %w, %s", cmd, err, bytes.TrimSpace(stderr.Bytes()))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(stdout.Bytes(), v); err != nil {
+		return fmt.Errorf("unmarshal %s output error: %w", cmd, err)
+	}
+
+	return nil
+}
+
+func (g Gcloud) runner() runner.Runner {
+	if g.Runner == nil {
+		return runner.Exec{}
+	}
+
+	return g.Runner
+}
+
+// err returns a descriptive error if the command failed due to the context being done.
+func (g Gcloud) err(ctx context.Context, err error) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %s: %w", g.Timeout, err)
+	} else if errors.Is(ctx.Err(), context.Canceled) {
+		return fmt.Errorf("aborted: %w", ctx.Err())
+	}
+
+	return err
+}
+
+// ConfigGet returns the value of a gcloud config property.
+func (g Gcloud) ConfigGet(ctx context.Context, name string) (string, error) {
+	var output []byte
+	err := retry(ctx, "gcloud config get "+name, func() error {
+		var err error
+		output, err = g.Output(ctx, "config", "get", name)
+		if err != nil {
+			return fmt.Errorf("gcloud config get %s error: %w, %s", name, err, output)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	value := strings.TrimSpace(string(output))
+	if value == "(unset)" {
+		return "", nil
+	}
+
+	return value, nil
+}
+
+// Projects returns the IDs of the projects accessible to the active gcloud account.
+func (g Gcloud) Projects(ctx context.Context) ([]string, error) {
+	var stdout, stderr bytes.Buffer
+	err := retry(ctx, "gcloud projects list", func() error {
+		stdout.Reset()
+		stderr.Reset()
+		err := g.Run(ctx, &stdout, &stderr, "projects", "list", "--format=value(projectId)", "--sort-by=projectId")
+		if err != nil {
+			return fmt.Errorf("gcloud projects list error: %w, %s", err, stderr.Bytes())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(stdout.String()), nil
+}
+
+// ActiveConfig returns the name of the active gcloud configuration.
+func ActiveConfig() string {
+	if name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME"); name != "" {
+		return name
+	}
+
+	b, err := os.ReadFile(filepath.Join(gcloudConfigDir(), "active_config"))
+	if err != nil || len(bytes.TrimSpace(b)) == 0 {
+		return "default"
+	}
+
+	return strings.TrimSpace(string(b))
+}
+
+// Configurations returns the names of the gcloud configurations by reading the
+// gcloud config dir directly.
+func Configurations() []string {
+	files, _ := filepath.Glob(filepath.Join(gcloudConfigDir(), "configurations", "config_*"))
+
+	var names []string
+	for _, file := range files {
+		names = append(names, strings.TrimPrefix(filepath.Base(file), "config_"))
+	}
+
+	return names
+}
+
+// ActiveProject returns the project of the active gcloud configuration by reading
+// the gcloud config files directly, which avoids gcloud's startup latency. It returns
+// false if the project is not found, in which case ConfigGet should be used.
+func ActiveProject() (string, bool) {
+	if p := os.Getenv("CLOUDSDK_CORE_PROJECT"); p != "" {
+		return p, true
+	}
+
+	name := ActiveConfig()
+	b, err := os.ReadFile(filepath.Join(gcloudConfigDir(), "configurations", "config_"+name))
+	if err != nil {
+		return "", false
+	}
+
+	var section string
+	for _, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.Trim(line, "[]")
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "core" && strings.TrimSpace(key) == "project" {
+			value = strings.TrimSpace(value)
+			return value, value != ""
+		}
+	}
+
+	return "", false
+}
+
+// gcloudConfigDir returns the gcloud config directory.
+func gcloudConfigDir() string {
+	if dir, ok := os.LookupEnv("CLOUDSDK_CONFIG"); ok {
+		return dir
+	}
+
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "gcloud")
+	}
+
+	home, _ := os.UserHomeDir()
+
+	return filepath.Join(home, ".config", "gcloud")
+}
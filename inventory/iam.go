@@ -0,0 +1,159 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	computeAPI = "https://compute.googleapis.com/compute/v1"
+	iapAPI     = "https://iap.googleapis.com/v1"
+)
+
+var httpClient = &http.Client{Timeout: 30 * time.Second}
+
+// apiError is a structured Google API error.
+type apiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+func (e apiError) Error() string {
+	return fmt.Sprintf("google api error: %d %s: %s", e.Code, e.Status, e.Message)
+}
+
+// Permissions required to connect to instances.
+const (
+	permGet         = "compute.instances.get"
+	permOSLogin     = "compute.instances.osLogin"
+	permSetMetadata = "compute.instances.setMetadata"
+	permIAP         = "iap.tunnelInstances.accessViaIAP"
+)
+
+// PermissionRoles are the predefined roles granting the permissions required to connect.
+var PermissionRoles = map[string]string{
+	permGet:         "roles/compute.viewer",
+	permOSLogin:     "roles/compute.osLogin",
+	permSetMetadata: "roles/compute.instanceAdmin.v1",
+	permIAP:         "roles/iap.tunnelResourceAccessor",
+}
+
+// AccessToken returns the access token of the active gcloud account.
+func (g Gcloud) AccessToken(ctx context.Context) (string, error) {
+	var stdout, stderr bytes.Buffer
+	if err := g.Run(ctx, &stdout, &stderr, "auth", "print-access-token"); err != nil {
+		return "", fmt.Errorf("gcloud auth print-access-token error: %w, %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// MissingPermissions returns the permissions required to connect to the
+// instance that the active gcloud account lacks: OS Login or setting metadata
+// ssh keys, and IAP tunneling if the instance has no external IP.
+func (g Gcloud) MissingPermissions(ctx context.Context, inst Instance, osLogin bool) ([]string, error) {
+	token, err := g.AccessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	perms := []string{permGet, permSetMetadata}
+	if osLogin {
+		perms = []string{permGet, permOSLogin}
+	}
+
+	resource := fmt.Sprintf("projects/%s/zones/%s/instances/%s", url.PathEscape(inst.Project), inst.TrimZone(), url.PathEscape(inst.Name))
+	granted, err := testPermissions(ctx, computeAPI+"/"+resource+"/testIamPermissions", token, perms)
+	if err != nil {
+		return nil, err
+	}
+
+	if inst.ExternalIP() == "" {
+		iapResource := fmt.Sprintf("projects/%s/iap_tunnel/zones/%s/instances/%s", url.PathEscape(inst.Project), inst.TrimZone(), url.PathEscape(inst.Name))
+		iapGranted, err := testPermissions(ctx, iapAPI+"/"+iapResource+":testIamPermissions", token, []string{permIAP})
+		if err != nil {
+			return nil, err
+		}
+		perms = append(perms, permIAP)
+		granted = append(granted, iapGranted...)
+	}
+
+	has := make(map[string]bool)
+	for _, p := range granted {
+		has[p] = true
+	}
+
+	var missing []string
+	for _, p := range perms {
+		if !has[p] {
+			missing = append(missing, p)
+		}
+	}
+
+	return missing, nil
+}
+
+// testPermissions returns the subset of the permissions granted on the resource.
+func testPermissions(ctx context.Context, u string, token string, perms []string) ([]string, error) {
+	var resp struct {
+		Permissions []string `json:"permissions"`
+	}
+	err := postJSON(ctx, u, token, struct {
+		Permissions []string `json:"permissions"`
+	}{Permissions: perms}, &resp)
+	if err != nil {
+		return nil, fmt.Errorf("test iam permissions error: %w", err)
+	}
+
+	return resp.Permissions, nil
+}
+
+// postJSON performs an authenticated POST request with the JSON body and unmarshals the JSON response.
+func postJSON(ctx context.Context, u string, token string, body any, v any) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("marshal request error: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(b))
+	if err != nil {
+		return fmt.Errorf("new request error: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("google api request error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	b, err = io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("read response error: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var errResp struct {
+			Error apiError `json:"error"`
+		}
+		if err := json.Unmarshal(b, &errResp); err != nil || errResp.Error.Code == 0 {
+			return apiError{Code: resp.StatusCode, Status: resp.Status, Message: strings.TrimSpace(string(b))}
+		}
+		return errResp.Error
+	}
+
+	if err := json.Unmarshal(b, v); err != nil {
+		return fmt.Errorf("unmarshal response error: %w", err)
+	}
+
+	return nil
+}
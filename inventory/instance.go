@@ -0,0 +1,196 @@
+// Package inventory lists, caches and filters gcloud compute instances.
+package inventory
+
+import (
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// Instance is a gcloud compute instance, or a host of another provider.
+type Instance struct {
+	Name              string
+	ID                string `json:",omitempty"`
+	Zone              string
+	Status            string             `json:",omitempty"`
+	MachineType       string             `json:",omitempty"`
+	Labels            map[string]string  `json:",omitempty"`
+	NetworkInterfaces []NetworkInterface `json:",omitempty"`
+	Project           string             `json:",omitempty"`
+	// Provider is empty for GCE instances, otherwise the source of the host, e.g. ProviderSSHConfig.
+	Provider string `json:",omitempty"`
+	// SSHConfig is the ssh_config file declaring the host, if imported from one.
+	SSHConfig string `json:",omitempty"`
+	// SSHUser, SSHPort and ProxyJump are the ssh defaults of the host, e.g. from a static inventory.
+	SSHUser   string `json:",omitempty"`
+	SSHPort   int    `json:",omitempty"`
+	ProxyJump string `json:",omitempty"`
+	// Plugin is the executable of the plugin connecting to the host, if not ssh.
+	Plugin string `json:",omitempty"`
+
+	ShieldedInstanceConfig *struct {
+		EnableSecureBoot bool `json:",omitempty"`
+		EnableVtpm       bool `json:",omitempty"`
+	} `json:",omitempty"`
+	ConfidentialInstanceConfig *struct {
+		EnableConfidentialCompute bool `json:",omitempty"`
+	} `json:",omitempty"`
+}
+
+// NetworkInterface is a gcloud compute instance network interface.
+type NetworkInterface struct {
+	NetworkIP     string
+	AccessConfigs []struct {
+		NatIP string `json:",omitempty"`
+	} `json:",omitempty"`
+}
+
+// gcloudFields is the gcloud format projection of the instance fields used by gssh.
+const gcloudFields = "name,id,zone,status,machineType,labels,networkInterfaces[].networkIP,networkInterfaces[].accessConfigs[].natIP," +
+	"shieldedInstanceConfig.enableSecureBoot,shieldedInstanceConfig.enableVtpm,confidentialInstanceConfig.enableConfidentialCompute"
+
+// TrimZone returns the zone name without the URL prefix.
+func (i Instance) TrimZone() string {
+	return filepath.Base(i.Zone)
+}
+
+// GCE returns true if the instance is a GCE instance, not a host of another provider.
+func (i Instance) GCE() bool {
+	return i.Provider == ""
+}
+
+// Location returns the zone of the instance, or its provider if it has none.
+func (i Instance) Location() string {
+	if i.Zone == "" {
+		return i.Provider
+	}
+
+	return i.TrimZone()
+}
+
+// InternalIP returns the internal IP of the first network interface, if any.
+func (i Instance) InternalIP() string {
+	if len(i.NetworkInterfaces) == 0 {
+		return ""
+	}
+
+	return i.NetworkInterfaces[0].NetworkIP
+}
+
+// ExternalIP returns the first external (NAT) IP of the instance, if any.
+func (i Instance) ExternalIP() string {
+	for _, nic := range i.NetworkInterfaces {
+		for _, ac := range nic.AccessConfigs {
+			if ac.NatIP != "" {
+				return ac.NatIP
+			}
+		}
+	}
+
+	return ""
+}
+
+// Shielded returns true if the instance is a Shielded VM with secure boot or vTPM enabled.
+func (i Instance) Shielded() bool {
+	c := i.ShieldedInstanceConfig
+	return c != nil && (c.EnableSecureBoot || c.EnableVtpm)
+}
+
+// Confidential returns true if the instance is a Confidential VM.
+func (i Instance) Confidential() bool {
+	c := i.ConfidentialInstanceConfig
+	return c != nil && c.EnableConfidentialCompute
+}
+
+// BrowserSSHURL returns the URL of the SSH-in-browser session of the instance.
+func (i Instance) BrowserSSHURL() string {
+	return fmt.Sprintf("https://ssh.cloud.google.com/v2/ssh/projects/%s/zones/%s/instances/%s",
+		url.PathEscape(i.Project), i.TrimZone(), url.PathEscape(i.Name))
+}
+
+// SelfLink returns the Compute Engine API URL of the instance.
+func (i Instance) SelfLink() string {
+	return fmt.Sprintf("https://www.googleapis.com/compute/v1/projects/%s/zones/%s/instances/%s", i.Project, i.TrimZone(), i.Name)
+}
+
+// consoleURL is the URL of the Google Cloud Console.
+const consoleURL = "https://console.cloud.google.com"
+
+// ConsoleURL returns the URL of the instance's Cloud Console page.
+func (i Instance) ConsoleURL() string {
+	return fmt.Sprintf("%s/compute/instancesDetail/zones/%s/instances/%s?project=%s",
+		consoleURL, i.TrimZone(), url.PathEscape(i.Name), url.QueryEscape(i.Project))
+}
+
+// MetricsURL returns the URL of the instance's Cloud Console monitoring tab.
+func (i Instance) MetricsURL() string {
+	return i.ConsoleURL() + "&tab=monitoring"
+}
+
+// LogsURL returns the URL of the Logs Explorer query for the instance's logs.
+func (i Instance) LogsURL() string {
+	return fmt.Sprintf("%s/logs/query;query=%s?project=%s", consoleURL, url.PathEscape(i.logFilter()), url.QueryEscape(i.Project))
+}
+
+// logFilter returns the Cloud Logging filter of the instance's logs.
+func (i Instance) logFilter() string {
+	return fmt.Sprintf("resource.type=\"gce_instance\"\nlabels.\"compute.googleapis.com/resource_name\"=\"%s\"", i.Name)
+}
+
+// GKE node labels set on the instances of GKE node pools.
+const (
+	labelGKECluster  = "goog-k8s-cluster-name"
+	labelGKENodePool = "goog-k8s-node-pool-name"
+)
+
+// GKECluster returns the GKE cluster of the instance if it is a GKE node.
+func (i Instance) GKECluster() string {
+	return i.Labels[labelGKECluster]
+}
+
+// GKENodePool returns the GKE node pool of the instance if it is a GKE node.
+func (i Instance) GKENodePool() string {
+	return i.Labels[labelGKENodePool]
+}
+
+// GKENodes returns the instances that are GKE nodes.
+func GKENodes(instances []Instance) []Instance {
+	var nodes []Instance
+	for _, inst := range instances {
+		if inst.GKECluster() != "" {
+			nodes = append(nodes, inst)
+		}
+	}
+
+	return nodes
+}
+
+// Filter filters instances by name regex.
+func Filter(instances []Instance, regex *regexp.Regexp) []Instance {
+	if regex.String() == "" {
+		return instances
+	}
+
+	var filtered []Instance
+	for _, inst := range instances {
+		if regex.MatchString(inst.Name) {
+			filtered = append(filtered, inst)
+		}
+	}
+
+	return filtered
+}
+
+// Sort sorts instances by name and project.
+func Sort(instances []Instance) []Instance {
+	sort.Slice(instances, func(i, j int) bool {
+		if instances[i].Name != instances[j].Name {
+			return instances[i].Name < instances[j].Name
+		}
+		return instances[i].Project < instances[j].Project
+	})
+
+	return instances
+}
@@ -0,0 +1,252 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// maxConcurrentListings is the maximum number of projects listed concurrently.
+const maxConcurrentListings = 4
+
+// ErrNoProject is returned by ListDefault if the gcloud config has no project.
+var ErrNoProject = errors.New("no gcloud config project")
+
+// Lister lists instances from the daemon, the cache or by fetching them.
+type Lister struct {
+	Fetch Fetcher
+	// TTL is the max age of cached lists.
+	TTL time.Duration
+	// Refresh ignores cached lists.
+	Refresh bool
+	// Offline uses the cached lists regardless of their age and never fetches.
+	Offline bool
+	// SkipDaemon doesn't query the daemon, e.g. for lists of other providers.
+	SkipDaemon bool
+}
+
+// ListProjects returns the merged instances of the projects listed concurrently
+// by a bounded pool of workers. Projects that fail to list are logged and skipped
+// unless all of them fail. It also returns the age of the oldest cached list.
+// Found is called concurrently for each fetched instance as it arrives.
+func (l Lister) ListProjects(ctx context.Context, projects []string, found func(Instance)) ([]Instance, time.Duration, error) {
+	type result struct {
+		project   string
+		instances []Instance
+		age       time.Duration
+		err       error
+	}
+
+	work := make(chan string)
+	results := make(chan result)
+
+	var wg sync.WaitGroup
+	for i := 0; i < min(maxConcurrentListings, len(projects)); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for project := range work {
+				instances, age, err := l.List(ctx, project, func(inst Instance) {
+					inst.Project = project
+					found(inst)
+				})
+				results <- result{project: project, instances: instances, age: age, err: err}
+			}
+		}()
+	}
+
+	go func() {
+		for _, project := range projects {
+			work <- project
+		}
+		close(work)
+		wg.Wait()
+		close(results)
+	}()
+
+	var (
+		instances []Instance
+		maxAge    time.Duration
+		errs      []error
+	)
+	for res := range results {
+		if res.err != nil {
+			errs = append(errs, fmt.Errorf("project %s: %w", res.project, res.err))
+			continue
+		}
+
+		for _, inst := range res.instances {
+			inst.Project = res.project
+			instances = append(instances, inst)
+		}
+
+		maxAge = max(maxAge, res.age)
+	}
+
+	if len(errs) == len(projects) {
+		return nil, 0, errors.Join(errs...)
+	}
+
+	for _, err := range errs {
+		slog.Warn("Skipping project", "err", err)
+	}
+
+	return instances, maxAge, nil
+}
+
+// List returns the instances of the project from the daemon or cache if younger
+// than the TTL, otherwise it fetches them, calling found for each as it arrives,
+// and updates the cache. If fetching fails, it falls back to the cached list
+// regardless of its age. It also returns the age of the cached list or zero if
+// it was fetched.
+func (l Lister) List(ctx context.Context, project string, found func(Instance)) ([]Instance, time.Duration, error) {
+	if l.Offline {
+		c, err := LoadCache(project)
+		if err != nil {
+			return nil, 0, fmt.Errorf("no cached VM list for offline mode: %w", err)
+		}
+		return c.Instances, time.Since(c.Fetched), nil
+	}
+
+	if !l.Refresh {
+		if c, err := l.queryDaemon(project); err == nil && time.Since(c.Fetched) < l.TTL {
+			slog.Debug("Using daemon VM list", "project", project, "age", time.Since(c.Fetched).Truncate(time.Second))
+			return c.Instances, time.Since(c.Fetched), nil
+		}
+
+		if c, err := LoadCache(project); err == nil && time.Since(c.Fetched) < l.TTL {
+			slog.Debug("Using cached VM list", "project", project, "age", time.Since(c.Fetched).Truncate(time.Second))
+			return c.Instances, time.Since(c.Fetched), nil
+		}
+	}
+
+	slog.Debug("Fetching VM list", "project", project)
+
+	var instances []Instance
+	err := retry(ctx, "listing "+project, func() error {
+		var err error
+		instances, err = l.Fetch(ctx, project, found)
+		return err
+	})
+	if err != nil && ctx.Err() == nil {
+		if c, cerr := LoadCache(project); cerr == nil {
+			slog.Warn("Using stale cached VM list", "project", project, "age", time.Since(c.Fetched).Truncate(time.Second), "err", err)
+			return c.Instances, time.Since(c.Fetched), nil
+		}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if err := StoreCache(project, instances); err != nil {
+		slog.Debug("Failed to store cache", "err", err)
+	}
+
+	return instances, 0, nil
+}
+
+// queryDaemon returns the instance list of the project from the daemon, unless
+// SkipDaemon, since the daemon would start warming it with its own fetcher.
+func (l Lister) queryDaemon(project string) (Cache, error) {
+	if l.SkipDaemon {
+		return Cache{}, errors.New("daemon skipped")
+	}
+
+	return QueryDaemon(project)
+}
+
+// ListDefault returns the instances of the gcloud config project and the project.
+// The project is looked up concurrently with fetching the instances of gcloud's
+// default project, the fetch is cancelled if the cached list can be used instead.
+// It returns ErrNoProject if the gcloud config has no project.
+func (l Lister) ListDefault(ctx context.Context, gc Gcloud, found func(Instance)) (string, []Instance, time.Duration, error) {
+	if l.Offline {
+		project, err := gc.ConfigGet(ctx, "project")
+		if err != nil {
+			return "", nil, 0, err
+		} else if project == "" {
+			return "", nil, 0, ErrNoProject
+		}
+		instances, age, err := l.ListProjects(ctx, []string{project}, found)
+		return project, instances, age, err
+	}
+
+	type result struct {
+		instances []Instance
+		err       error
+	}
+
+	fetchCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu      sync.Mutex
+		project string
+		pending []Instance
+	)
+	annotate := func(inst Instance) {
+		mu.Lock()
+		defer mu.Unlock()
+		if project == "" {
+			pending = append(pending, inst)
+			return
+		}
+		inst.Project = project
+		found(inst)
+	}
+
+	resc := make(chan result, 1)
+	go func() {
+		instances, err := l.Fetch(fetchCtx, "", annotate)
+		resc <- result{instances: instances, err: err}
+	}()
+
+	p, err := gc.ConfigGet(ctx, "project")
+	if err != nil {
+		return "", nil, 0, err
+	} else if p == "" {
+		return "", nil, 0, ErrNoProject
+	}
+
+	if !l.Refresh {
+		if c, err := QueryDaemon(p); err == nil && time.Since(c.Fetched) < l.TTL {
+			return p, withProject(c.Instances, p), time.Since(c.Fetched), nil
+		}
+		if c, err := LoadCache(p); err == nil && time.Since(c.Fetched) < l.TTL {
+			return p, withProject(c.Instances, p), time.Since(c.Fetched), nil
+		}
+	}
+
+	mu.Lock()
+	project = p
+	for _, inst := range pending {
+		inst.Project = p
+		found(inst)
+	}
+	mu.Unlock()
+
+	res := <-resc
+	if res.err != nil {
+		// Fallback to listing the project with retries and the stale cache.
+		instances, age, err := l.ListProjects(ctx, []string{p}, found)
+		return p, instances, age, err
+	}
+
+	if err := StoreCache(p, res.instances); err != nil {
+		slog.Debug("Failed to store cache", "err", err)
+	}
+
+	return p, withProject(res.instances, p), 0, nil
+}
+
+// withProject returns the instances annotated with the project.
+func withProject(instances []Instance, project string) []Instance {
+	for i := range instances {
+		instances[i].Project = project
+	}
+
+	return instances
+}
@@ -0,0 +1,52 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LogEntry is a Cloud Logging entry of an instance.
+type LogEntry struct {
+	Timestamp   time.Time       `json:"timestamp"`
+	Severity    string          `json:"severity"`
+	TextPayload string          `json:"textPayload"`
+	JSONPayload json.RawMessage `json:"jsonPayload"`
+}
+
+// Message returns the text payload or the message of the JSON payload.
+func (e LogEntry) Message() string {
+	if e.TextPayload != "" {
+		return strings.TrimSpace(e.TextPayload)
+	}
+
+	var payload struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(e.JSONPayload, &payload); err == nil && payload.Message != "" {
+		return strings.TrimSpace(payload.Message)
+	}
+
+	return string(e.JSONPayload)
+}
+
+// LogEntries returns the most recent Cloud Logging entries of the instance from
+// the last day, e.g. serial port output or syslog, oldest first.
+func (g Gcloud) LogEntries(ctx context.Context, inst Instance, limit int) ([]LogEntry, error) {
+	var entries []LogEntry
+	err := g.JSON(ctx, &entries, "logging", "read", inst.logFilter(),
+		"--project="+inst.Project, fmt.Sprintf("--limit=%d", limit), "--freshness=1d",
+		"--format=json(timestamp,severity,textPayload,jsonPayload)")
+	if err != nil {
+		return nil, err
+	}
+
+	// gcloud returns the most recent entries first.
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,112 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// projectStats are the daemon's statistics of a project exposed as metrics.
+type projectStats struct {
+	listDuration time.Duration
+	listErrors   int
+	requests     int
+	connections  int
+}
+
+// projectStats returns the statistics of the project, adding them if not present.
+// The caller must hold the lock.
+func (d *daemon) projectStats(project string) *projectStats {
+	s, ok := d.stats[project]
+	if !ok {
+		s = &projectStats{}
+		d.stats[project] = s
+	}
+
+	return s
+}
+
+// serveMetrics serves the daemon's Prometheus metrics on the address until the context is cancelled.
+func (d *daemon) serveMetrics(ctx context.Context, addr string) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("listen metrics error: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		d.writeMetrics(w)
+	})
+	srv := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	slog.Info("Serving metrics", "addr", "http://"+ln.Addr().String()+"/metrics")
+
+	go func() {
+		if err := srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Failed to serve metrics", "err", err)
+		}
+	}()
+
+	return nil
+}
+
+// writeMetrics writes the metrics in the Prometheus text exposition format.
+func (d *daemon) writeMetrics(w io.Writer) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var projects []string
+	for project := range d.stats {
+		projects = append(projects, project)
+	}
+	sort.Strings(projects)
+
+	gauge := func(name, help string, value func(project string) (float64, bool)) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, project := range projects {
+			if v, ok := value(project); ok {
+				fmt.Fprintf(w, "%s{project=%q} %g\n", name, project, v)
+			}
+		}
+	}
+	counter := func(name, help string, value func(project string) int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, project := range projects {
+			fmt.Fprintf(w, "%s{project=%q} %d\n", name, project, value(project))
+		}
+	}
+
+	gauge("gssh_cache_age_seconds", "Age of the served instance list.", func(project string) (float64, bool) {
+		c, ok := d.caches[project]
+		return time.Since(c.Fetched).Seconds(), ok
+	})
+	gauge("gssh_instances", "Number of instances in the served instance list.", func(project string) (float64, bool) {
+		c, ok := d.caches[project]
+		return float64(len(c.Instances)), ok
+	})
+	gauge("gssh_list_duration_seconds", "Duration of the last instance list refresh.", func(project string) (float64, bool) {
+		s := d.stats[project]
+		return s.listDuration.Seconds(), s.listDuration > 0
+	})
+	counter("gssh_list_errors_total", "Number of failed instance list refreshes.", func(project string) int {
+		return d.stats[project].listErrors
+	})
+	counter("gssh_requests_total", "Number of instance lists served to gssh invocations.", func(project string) int {
+		return d.stats[project].requests
+	})
+	counter("gssh_connections_total", "Number of connections to VMs reported by gssh invocations.", func(project string) int {
+		return d.stats[project].connections
+	})
+}
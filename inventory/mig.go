@@ -0,0 +1,67 @@
+package inventory
+
+import (
+	"context"
+	"path/filepath"
+)
+
+// MIG is a managed instance group.
+type MIG struct {
+	Name    string
+	Zone    string `json:",omitempty"`
+	Region  string `json:",omitempty"`
+	Project string `json:",omitempty"`
+}
+
+// Location returns the zone or region name of the managed instance group.
+func (m MIG) Location() string {
+	if m.Zone != "" {
+		return filepath.Base(m.Zone)
+	}
+
+	return filepath.Base(m.Region)
+}
+
+// locationFlag returns the gcloud flag selecting the zone or region of the managed instance group.
+func (m MIG) locationFlag() string {
+	if m.Zone != "" {
+		return "--zone=" + m.Location()
+	}
+
+	return "--region=" + m.Location()
+}
+
+// MIGs returns the managed instance groups of the project.
+func (g Gcloud) MIGs(ctx context.Context, project string) ([]MIG, error) {
+	var migs []MIG
+	err := g.JSON(ctx, &migs, "compute", "instance-groups", "managed", "list",
+		"--project="+project, "--format=json(name,zone,region)")
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range migs {
+		migs[i].Project = project
+	}
+
+	return migs, nil
+}
+
+// MIGInstances returns the names of the current member instances of the managed instance group.
+func (g Gcloud) MIGInstances(ctx context.Context, mig MIG) ([]string, error) {
+	var members []struct {
+		Instance string `json:"instance"`
+	}
+	err := g.JSON(ctx, &members, "compute", "instance-groups", "managed", "list-instances", mig.Name,
+		mig.locationFlag(), "--project="+mig.Project, "--format=json(instance)")
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, m := range members {
+		names = append(names, filepath.Base(m.Instance))
+	}
+
+	return names, nil
+}
@@ -0,0 +1,136 @@
+package inventory
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Metadata is the metadata of an instance or the common instance metadata of a project.
+type Metadata struct {
+	Items []struct {
+		Key   string `json:"key"`
+		Value string `json:"value"`
+	} `json:"items"`
+}
+
+// Get returns the value of the metadata key.
+func (m Metadata) Get(key string) (string, bool) {
+	for _, item := range m.Items {
+		if item.Key == key {
+			return item.Value, true
+		}
+	}
+
+	return "", false
+}
+
+// InstanceMetadata returns the metadata of the instance.
+func (g Gcloud) InstanceMetadata(ctx context.Context, inst Instance) (Metadata, error) {
+	var resp struct {
+		Metadata Metadata `json:"metadata"`
+	}
+	err := g.JSON(ctx, &resp, "compute", "instances", "describe", inst.Name,
+		"--zone="+inst.TrimZone(), "--project="+inst.Project, "--format=json(metadata)")
+
+	return resp.Metadata, err
+}
+
+// ProjectMetadata returns the common instance metadata of the project.
+func (g Gcloud) ProjectMetadata(ctx context.Context, project string) (Metadata, error) {
+	var resp struct {
+		Metadata Metadata `json:"commonInstanceMetadata"`
+	}
+	err := g.JSON(ctx, &resp, "compute", "project-info", "describe",
+		"--project="+project, "--format=json(commonInstanceMetadata)")
+
+	return resp.Metadata, err
+}
+
+// OSLogin returns whether OS Login is enabled for the instance, i.e. whether the
+// enable-oslogin metadata of the instance, or else of its project, is true.
+func (g Gcloud) OSLogin(ctx context.Context, inst Instance) (bool, error) {
+	meta, err := g.InstanceMetadata(ctx, inst)
+	if err != nil {
+		return false, err
+	}
+	if v, ok := meta.Get("enable-oslogin"); ok {
+		return strings.EqualFold(v, "true"), nil
+	}
+
+	meta, err = g.ProjectMetadata(ctx, inst.Project)
+	if err != nil {
+		return false, err
+	}
+	v, _ := meta.Get("enable-oslogin")
+
+	return strings.EqualFold(v, "true"), nil
+}
+
+// loginProfile is the OS Login profile of the active gcloud account.
+type loginProfile struct {
+	PosixAccounts []struct {
+		Primary  bool   `json:"primary"`
+		Username string `json:"username"`
+	} `json:"posixAccounts"`
+}
+
+// username returns the primary POSIX username of the profile.
+func (p loginProfile) username() (string, error) {
+	for _, acc := range p.PosixAccounts {
+		if acc.Primary {
+			return acc.Username, nil
+		}
+	}
+	if len(p.PosixAccounts) > 0 {
+		return p.PosixAccounts[0].Username, nil
+	}
+
+	return "", fmt.Errorf("no OS Login POSIX account")
+}
+
+// AddOSLoginKey adds the public key file to the OS Login profile of the active
+// gcloud account and returns its primary POSIX username.
+func (g Gcloud) AddOSLoginKey(ctx context.Context, keyFile string) (string, error) {
+	var resp struct {
+		LoginProfile loginProfile `json:"loginProfile"`
+	}
+	err := g.JSON(ctx, &resp, "compute", "os-login", "ssh-keys", "add", "--key-file="+keyFile, "--format=json")
+	if err != nil {
+		return "", err
+	}
+
+	return resp.LoginProfile.username()
+}
+
+// OSLoginUser returns the primary POSIX username of the OS Login profile of the
+// active gcloud account without modifying the profile.
+func (g Gcloud) OSLoginUser(ctx context.Context) (string, error) {
+	var resp loginProfile
+	err := g.JSON(ctx, &resp, "compute", "os-login", "describe-profile", "--format=json")
+	if err != nil {
+		return "", err
+	}
+
+	return resp.username()
+}
+
+// OSLoginKeys returns the public keys in the OS Login profile of the active gcloud account.
+func (g Gcloud) OSLoginKeys(ctx context.Context) ([]string, error) {
+	var resp struct {
+		SSHPublicKeys map[string]struct {
+			Key string `json:"key"`
+		} `json:"sshPublicKeys"`
+	}
+	err := g.JSON(ctx, &resp, "compute", "os-login", "describe-profile", "--format=json")
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []string
+	for _, k := range resp.SSHPublicKeys {
+		keys = append(keys, k.Key)
+	}
+
+	return keys, nil
+}
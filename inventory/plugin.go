@@ -0,0 +1,142 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// pluginPrefix is the prefix of the executables of plugins referenced by name.
+const pluginPrefix = "gssh-provider-"
+
+// Plugin is the provider of the hosts listed by an external executable, so that
+// providers can be added without changing gssh. The protocol is:
+//
+//	<plugin> list
+//
+// prints a JSON array of PluginHost to stdout and exits zero. Hosts with
+// connect true are connected to by running, attached to the terminal:
+//
+//	<plugin> connect [--user user] <id> [-- remote args ...]
+//
+// Other hosts are connected to with plain ssh to their address.
+type Plugin struct {
+	// Path is the plugin executable, or its name if the executable is gssh-provider-<name> in the PATH.
+	Path string
+	// Lister configures the caching of the host lists, its Fetch is ignored.
+	Lister Lister
+	// Timeout is the maximum duration of the list invocation.
+	Timeout time.Duration
+	// Runner runs the plugin, it defaults to runner.Exec.
+	Runner runner.Runner
+}
+
+// PluginHost is a host listed by a plugin.
+type PluginHost struct {
+	StaticHost
+	// ID identifies the host to the plugin's connect command, it defaults to the name.
+	ID      string `json:"id,omitempty"`
+	Status  string `json:"status,omitempty"`
+	Zone    string `json:"zone,omitempty"`
+	Project string `json:"project,omitempty"`
+	// Connect connects to the host via the plugin's connect command instead of ssh.
+	Connect bool `json:"connect,omitempty"`
+}
+
+// Name returns the name of the plugin.
+func (p Plugin) Name() string {
+	return strings.TrimPrefix(filepath.Base(p.Path), pluginPrefix)
+}
+
+// executable returns the path of the plugin executable.
+func (p Plugin) executable() (string, error) {
+	name := p.Path
+	if !strings.ContainsAny(name, `/\`) {
+		name = pluginPrefix + strings.TrimPrefix(name, pluginPrefix)
+	}
+
+	path, err := exec.LookPath(ExpandHome(name))
+	if err != nil {
+		return "", fmt.Errorf("plugin %s not found: %w", p.Name(), err)
+	}
+
+	return path, nil
+}
+
+// List returns the hosts listed by the plugin.
+func (p Plugin) List(ctx context.Context) ([]Instance, error) {
+	path, err := p.executable()
+	if err != nil {
+		return nil, err
+	}
+
+	l := p.Lister
+	l.SkipDaemon = true
+	l.Fetch = func(ctx context.Context, _ string, _ func(Instance)) ([]Instance, error) {
+		return p.fetch(ctx, path)
+	}
+
+	instances, _, err := l.List(ctx, "plugin-"+p.Name(), func(Instance) {})
+
+	return instances, err
+}
+
+// fetch runs the plugin's list command and returns its hosts.
+func (p Plugin) fetch(ctx context.Context, path string) ([]Instance, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	r := p.Runner
+	if r == nil {
+		r = runner.Exec{}
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := r.Run(ctx, runner.Cmd{Name: path, Args: []string{"list"}, Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		return nil, fmt.Errorf("plugin %s list error: %w, %s", p.Name(), err, stderr.Bytes())
+	}
+
+	var hosts []PluginHost
+	if err := json.Unmarshal(stdout.Bytes(), &hosts); err != nil {
+		return nil, fmt.Errorf("unmarshal plugin %s hosts error: %w", p.Name(), err)
+	}
+
+	var instances []Instance
+	for _, h := range hosts {
+		if h.Name == "" || (h.Address == "" && !h.Connect) {
+			return nil, fmt.Errorf("plugin %s host without name or address: %+v", p.Name(), h)
+		}
+
+		inst := h.instance(p.Name())
+		inst.ID = withDefault(h.ID, h.Name)
+		inst.Status = h.Status
+		inst.Zone = h.Zone
+		inst.Project = h.Project
+		if h.Connect {
+			inst.Plugin = path
+		}
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
+
+// withDefault returns the value or the default if empty.
+func withDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
@@ -0,0 +1,208 @@
+package inventory
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// maxConcurrentProbes limits the number of concurrent reachability probes.
+const maxConcurrentProbes = 32
+
+// maxConcurrentIAPProbes limits the number of concurrent gcloud IAP tunnel probes.
+const maxConcurrentIAPProbes = 8
+
+// LatencyIAP is the latency of running instances without an external IP whose
+// internal IP isn't reachable, which gcloud connects to through IAP.
+const LatencyIAP time.Duration = -1
+
+// ProbeResult is the reachability of the ssh port of an instance.
+type ProbeResult int
+
+const (
+	// ProbeUnreachable instances refused or didn't accept the connection in time.
+	ProbeUnreachable ProbeResult = iota
+	// ProbeReachable instances accepted the connection, directly or through IAP.
+	ProbeReachable
+	// ProbeUnknown instances couldn't be probed through IAP, e.g. without permission.
+	ProbeUnknown
+)
+
+// Reachable concurrently probes the ssh port of the instances and returns
+// whether each is reachable. Instances that are not running or have no IP are
+// unreachable. The external IP is probed if present, otherwise the internal IP
+// and, if that fails, the port is probed through IAP like gcloud connects.
+// IAP probes are bounded by the gcloud timeout rather than the probe timeout
+// since starting gcloud alone takes longer. Without gcloud, i.e. if gc is nil,
+// or if the IAP probe fails, IAP-only instances are unknown.
+func Reachable(ctx context.Context, gc *Gcloud, instances []Instance, port string, timeout time.Duration) []ProbeResult {
+	var (
+		results = make([]ProbeResult, len(instances))
+		sem     = make(chan struct{}, maxConcurrentIAPProbes)
+		wg      sync.WaitGroup
+	)
+	for i, rtt := range Latency(ctx, instances, port, timeout) {
+		if rtt > 0 {
+			results[i] = ProbeReachable
+			continue
+		} else if rtt != LatencyIAP {
+			continue
+		}
+
+		p, err := strconv.Atoi(port)
+		if gc == nil || err != nil {
+			results[i] = ProbeUnknown
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			if ok, err := gc.IAPReachable(ctx, instances[i], p); err != nil {
+				results[i] = ProbeUnknown
+			} else if ok {
+				results[i] = ProbeReachable
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// Latency concurrently measures the TCP connect time to the ssh port of the
+// instances, probing the external IP if present, otherwise the internal IP. It
+// returns zero for unreachable instances and LatencyIAP for instances only
+// reachable through IAP, whose connect time isn't comparable. ICMP isn't used
+// since it requires raw sockets and is often blocked by firewalls.
+func Latency(ctx context.Context, instances []Instance, port string, timeout time.Duration) []time.Duration {
+	var (
+		rtts = make([]time.Duration, len(instances))
+		sem  = make(chan struct{}, maxConcurrentProbes)
+		wg   sync.WaitGroup
+	)
+	for i, inst := range instances {
+		ip := inst.ExternalIP()
+		if ip == "" {
+			ip = inst.InternalIP()
+		}
+		if ip == "" || (inst.Status != "" && inst.Status != "RUNNING") {
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, addr string, iap bool) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			var ok bool
+			if rtts[i], ok = probe(ctx, addr, timeout); !ok && iap {
+				rtts[i] = LatencyIAP
+			}
+		}(i, net.JoinHostPort(ip, port), inst.ExternalIP() == "" && inst.GCE())
+	}
+	wg.Wait()
+
+	return rtts
+}
+
+// ReachableIP returns true if the port of the IP accepts TCP connections within the timeout.
+func ReachableIP(ctx context.Context, ip, port string, timeout time.Duration) bool {
+	_, ok := probe(ctx, net.JoinHostPort(ip, port), timeout)
+	return ok
+}
+
+// probe returns the connect time and true if a TCP connection to the address
+// succeeds within the timeout.
+func probe(ctx context.Context, addr string, timeout time.Duration) (time.Duration, bool) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	t0 := time.Now()
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return 0, false
+	}
+	rtt := time.Since(t0)
+	_ = conn.Close()
+
+	return rtt, true
+}
+
+// IAPReachable returns true if IAP can tunnel to the port of the instance, i.e.
+// gcloud's tunnel connection test succeeds, and false if the backend refused
+// the connection, e.g. due to a firewall or no listening process.
+func (g Gcloud) IAPReachable(ctx context.Context, inst Instance, port int) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.Timeout)
+	defer cancel()
+
+	// The tunnel listens on a random local port once its connection test succeeds.
+	w := &matchWriter{match: "Listening on port", found: cancel}
+	err := g.runner().Run(ctx, runner.Cmd{
+		Name: GcloudBin,
+		Args: []string{"compute", "start-iap-tunnel", inst.Name, strconv.Itoa(port), "--local-host-port=localhost:0",
+			"--zone=" + inst.TrimZone(), "--project=" + inst.Project},
+		Stdout: w,
+		Stderr: w,
+	})
+	if w.matched() {
+		return true, nil
+	} else if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return false, fmt.Errorf("gcloud compute start-iap-tunnel timed out after %s", g.Timeout)
+	} else if strings.Contains(w.String(), "4003") || strings.Contains(w.String(), "failed to connect to backend") {
+		return false, nil
+	}
+
+	return false, fmt.Errorf("gcloud compute start-iap-tunnel error: %w, %s", err, strings.TrimSpace(w.String()))
+}
+
+// matchWriter buffers the output and calls found once it contains match.
+type matchWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	match string
+	found func()
+	ok    bool
+}
+
+func (w *matchWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n, _ := w.buf.Write(b)
+	if !w.ok && strings.Contains(w.buf.String(), w.match) {
+		w.ok = true
+		w.found()
+	}
+
+	return n, nil
+}
+
+func (w *matchWriter) matched() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.ok
+}
+
+func (w *matchWriter) String() string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	return w.buf.String()
+}
@@ -0,0 +1,72 @@
+package inventory
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+)
+
+// Provider lists the hosts of an inventory other than GCE, which are merged
+// with the VMs.
+type Provider interface {
+	// Name returns the name of the provider, e.g. ProviderSSHConfig.
+	Name() string
+	// List returns the hosts of the provider.
+	List(ctx context.Context) ([]Instance, error)
+}
+
+// ListProviders returns the merged hosts of the providers listed concurrently.
+// Providers that fail to list are logged and skipped, since the VMs and other
+// providers' hosts are still useful.
+func ListProviders(ctx context.Context, providers []Provider) []Instance {
+	results := make([][]Instance, len(providers))
+
+	var wg sync.WaitGroup
+	for i, p := range providers {
+		i, p := i, p
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			hosts, err := p.List(ctx)
+			if err != nil {
+				slog.Warn("Skipping hosts of provider", "provider", p.Name(), "err", err)
+				return
+			}
+			results[i] = hosts
+		}()
+	}
+	wg.Wait()
+
+	var hosts []Instance
+	for _, r := range results {
+		hosts = append(hosts, r...)
+	}
+
+	return hosts
+}
+
+// SSHConfig is the provider of the hosts declared by ssh_config files.
+type SSHConfig struct {
+	Files []string
+}
+
+// Name returns ProviderSSHConfig.
+func (SSHConfig) Name() string {
+	return ProviderSSHConfig
+}
+
+// List returns the hosts of the ssh_config files, skipping files that fail to read.
+func (p SSHConfig) List(context.Context) ([]Instance, error) {
+	var hosts []Instance
+	for _, filename := range p.Files {
+		h, err := SSHConfigHosts(filename)
+		if err != nil {
+			slog.Warn("Skipping ssh_config hosts", "file", filename, "err", err)
+			continue
+		}
+		hosts = append(hosts, h...)
+	}
+
+	return hosts, nil
+}
@@ -0,0 +1,134 @@
+package inventory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// retryAttempts is the maximum number of attempts of a retried call.
+	retryAttempts = 4
+	// retryBackoff is the backoff before the first retry, it doubles for each subsequent retry.
+	retryBackoff = 500 * time.Millisecond
+)
+
+// gcloudStatus matches the HTTP status code of gcloud errors, e.g. "HTTPError 503: ...",
+// "ResponseError: code=503, message=..." or "googleapi: Error 503: ...".
+var gcloudStatus = regexp.MustCompile(`(?:HTTPError |ResponseError: code=|googleapi: Error )(\d{3})\b`)
+
+// transientStatus are the HTTP status codes worth retrying.
+var transientStatus = map[int]bool{429: true, 500: true, 502: true, 503: true, 504: true}
+
+// transientErrors are lowercase gcloud and network error messages without a
+// status code that are worth retrying. They are full phrases so that they
+// don't match VM names, IDs or zones also contained in the errors.
+var transientErrors = []string{
+	"the service is currently unavailable",
+	"internal error. please try again",
+	"connection reset by peer",
+	"connection aborted",
+	"problem refreshing your current auth tokens",
+}
+
+// retry calls fn until it succeeds, returns a non-transient error or the attempts are
+// exhausted. It backs off exponentially with jitter between attempts.
+func retry(ctx context.Context, name string, fn func() error) error {
+	backoff := retryBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil {
+			return nil
+		} else if !isTransient(err) || ctx.Err() != nil {
+			return err
+		} else if attempt == retryAttempts {
+			return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+		}
+
+		// Full jitter in [backoff/2, backoff).
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)))
+		slog.Warn("Retrying after transient error", "call", name, "attempt", attempt, "max", retryAttempts, "delay", delay.Truncate(time.Millisecond))
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+
+		backoff *= 2
+	}
+}
+
+// isTransient returns true if the error is likely transient and worth retrying.
+func isTransient(err error) bool {
+	if code, ok := apiStatus(err); ok {
+		return transientStatus[code]
+	}
+
+	if m := gcloudStatus.FindStringSubmatch(err.Error()); m != nil {
+		code, _ := strconv.Atoi(m[1])
+		return transientStatus[code]
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range transientErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authErrors are lowercase substrings of gcloud errors caused by missing or expired credentials.
+var authErrors = []string{
+	"gcloud auth login",
+	"application-default login",
+	"reauthentication",
+	"invalid_grant",
+	"no credentialed accounts",
+	"refresh token",
+	"unauthenticated",
+	"no application default credentials",
+}
+
+// IsAuthError returns true if the gcloud or Compute Engine API error is caused
+// by missing or expired credentials.
+func IsAuthError(err error) bool {
+	var retrieveErr *oauth2.RetrieveError
+	if errors.As(err, &retrieveErr) {
+		// E.g. an expired or revoked ADC refresh token.
+		return true
+	} else if code, ok := apiStatus(err); ok {
+		return code == 401
+	}
+
+	msg := strings.ToLower(err.Error())
+	for _, s := range authErrors {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ReauthCommand returns the gcloud command that refreshes the credentials
+// causing the auth error, i.e. the Application Default Credentials used by the
+// Compute Engine API or the gcloud user credentials.
+func ReauthCommand(err error) []string {
+	var retrieveErr *oauth2.RetrieveError
+	if _, ok := apiStatus(err); ok || errors.As(err, &retrieveErr) || strings.Contains(strings.ToLower(err.Error()), "application-default") {
+		return []string{GcloudBin, "auth", "application-default", "login"}
+	}
+
+	return []string{GcloudBin, "auth", "login"}
+}
@@ -0,0 +1,80 @@
+package inventory
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ProviderSSHConfig is the provider of hosts imported from ssh_config files.
+const ProviderSSHConfig = "ssh_config"
+
+// SSHConfigHosts returns the hosts declared by the Host entries of the ssh_config
+// file, excluding patterns, with their HostName as internal IP.
+func SSHConfigHosts(filename string) ([]Instance, error) {
+	f, err := os.Open(ExpandHome(filename))
+	if err != nil {
+		return nil, fmt.Errorf("open ssh config error: %w", err)
+	}
+	defer f.Close()
+
+	var (
+		hosts   []Instance
+		current []int // Indexes of the hosts of the current Host entry.
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		// Keywords are separated from their arguments by whitespace or '='.
+		key, value, _ := strings.Cut(strings.Replace(line, "=", " ", 1), " ")
+		value = strings.TrimSpace(value)
+
+		switch strings.ToLower(key) {
+		case "host":
+			current = nil
+			for _, name := range strings.Fields(value) {
+				if strings.ContainsAny(name, "*?!") {
+					continue
+				}
+				current = append(current, len(hosts))
+				hosts = append(hosts, Instance{
+					Name:      name,
+					Provider:  ProviderSSHConfig,
+					SSHConfig: filename,
+				})
+			}
+		case "match":
+			current = nil
+		case "hostname":
+			for _, i := range current {
+				hosts[i].NetworkInterfaces = []NetworkInterface{{NetworkIP: value}}
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read ssh config error: %w", err)
+	}
+
+	return hosts, nil
+}
+
+// ExpandHome returns the path with a leading ~ replaced by the home directory.
+func ExpandHome(path string) string {
+	rest, ok := strings.CutPrefix(path, "~")
+	if !ok || (rest != "" && rest[0] != '/' && rest[0] != filepath.Separator) {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, rest)
+}
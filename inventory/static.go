@@ -0,0 +1,86 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// ProviderStatic is the provider of hosts of static inventory files.
+const ProviderStatic = "static"
+
+// Static is the provider of the hosts of static inventory files, e.g. lab
+// machines and on-prem servers. The files are JSON arrays of StaticHost.
+type Static struct {
+	Files []string
+}
+
+// StaticHost is a host in a static inventory file.
+type StaticHost struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+	User    string `json:"user,omitempty"`
+	Port    int    `json:"port,omitempty"`
+	// Jump is the ssh jump host, as in 'ssh -J'.
+	Jump string            `json:"jump,omitempty"`
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// Name returns ProviderStatic.
+func (Static) Name() string {
+	return ProviderStatic
+}
+
+// List returns the hosts of the files, skipping files that fail to load.
+func (p Static) List(context.Context) ([]Instance, error) {
+	var hosts []Instance
+	for _, filename := range p.Files {
+		h, err := loadStatic(filename)
+		if err != nil {
+			slog.Warn("Skipping static inventory", "file", filename, "err", err)
+			continue
+		}
+		hosts = append(hosts, h...)
+	}
+
+	return hosts, nil
+}
+
+// loadStatic returns the hosts of the static inventory file.
+func loadStatic(filename string) ([]Instance, error) {
+	b, err := os.ReadFile(ExpandHome(filename))
+	if err != nil {
+		return nil, fmt.Errorf("read static inventory error: %w", err)
+	}
+
+	var static []StaticHost
+	if err := json.Unmarshal(b, &static); err != nil {
+		return nil, fmt.Errorf("unmarshal static inventory error: %w", err)
+	}
+
+	var hosts []Instance
+	for _, h := range static {
+		if h.Name == "" || h.Address == "" {
+			return nil, fmt.Errorf("static host without name or address: %+v", h)
+		}
+
+		hosts = append(hosts, h.instance(ProviderStatic))
+	}
+
+	return hosts, nil
+}
+
+// instance returns the host as an instance of the provider.
+func (h StaticHost) instance(provider string) Instance {
+	return Instance{
+		Name:              h.Name,
+		Labels:            h.Tags,
+		NetworkInterfaces: []NetworkInterface{{NetworkIP: h.Address}},
+		Provider:          provider,
+		SSHUser:           h.User,
+		SSHPort:           h.Port,
+		ProxyJump:         h.Jump,
+	}
+}
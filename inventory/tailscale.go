@@ -0,0 +1,110 @@
+package inventory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// ProviderTailscale is the provider of tailnet machines.
+const ProviderTailscale = "tailscale"
+
+// TailscaleBin is the tailscale cli binary, it defaults to tailscale in the PATH.
+var TailscaleBin = "tailscale"
+
+// Tailscale is the provider of the machines of the tailnet, listed via the
+// local tailscale cli. The instances' internal IP is their tailnet IP, their
+// project the tailnet and offline machines have the status OFFLINE.
+type Tailscale struct {
+	// Timeout is the maximum duration of the tailscale invocation.
+	Timeout time.Duration
+	// Runner runs the tailscale subprocess, it defaults to runner.Exec.
+	Runner runner.Runner
+}
+
+// Name returns ProviderTailscale.
+func (Tailscale) Name() string {
+	return ProviderTailscale
+}
+
+// tailscaleStatus is the tailscale status --json output.
+type tailscaleStatus struct {
+	CurrentTailnet *struct {
+		Name string
+	}
+	Peer map[string]struct {
+		ID           string
+		HostName     string
+		DNSName      string
+		OS           string
+		TailscaleIPs []string
+		Online       bool
+		Tags         []string
+	}
+}
+
+// List returns the peers of the local machine in the tailnet.
+func (p Tailscale) List(ctx context.Context) ([]Instance, error) {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+
+	r := p.Runner
+	if r == nil {
+		r = runner.Exec{}
+	}
+
+	// Ignore stderr warnings, e.g. about a newer version.
+	var stdout strings.Builder
+	err := r.Run(ctx, runner.Cmd{Name: TailscaleBin, Args: []string{"status", "--json"}, Stdout: &stdout})
+	if err != nil {
+		return nil, fmt.Errorf("tailscale status error: %w", err)
+	}
+
+	var status tailscaleStatus
+	if err := json.Unmarshal([]byte(stdout.String()), &status); err != nil {
+		return nil, fmt.Errorf("unmarshal tailscale status error: %w", err)
+	}
+
+	var tailnet string
+	if status.CurrentTailnet != nil {
+		tailnet = status.CurrentTailnet.Name
+	}
+
+	var instances []Instance
+	for _, peer := range status.Peer {
+		// The MagicDNS short name is unique in the tailnet, unlike the host name.
+		name, _, _ := strings.Cut(peer.DNSName, ".")
+		if name == "" {
+			name = peer.HostName
+		}
+
+		inst := Instance{
+			Name:     name,
+			ID:       peer.ID,
+			Status:   "RUNNING",
+			Labels:   map[string]string{"os": peer.OS},
+			Project:  tailnet,
+			Provider: ProviderTailscale,
+		}
+		if !peer.Online {
+			inst.Status = "OFFLINE"
+		}
+		for _, tag := range peer.Tags {
+			inst.Labels[strings.TrimPrefix(tag, "tag:")] = ""
+		}
+		if len(peer.TailscaleIPs) > 0 {
+			inst.NetworkInterfaces = []NetworkInterface{{NetworkIP: peer.TailscaleIPs[0]}}
+		}
+
+		instances = append(instances, inst)
+	}
+
+	return instances, nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// runKeys shows the ssh identity used to connect, the keys loaded in the ssh agent
+// and whether the identity is in the OS Login profile of the active gcloud account.
+func runKeys(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	identity := fs.String("i", "", "ssh private key file (default the identities.<gcloud configuration> config or the gcloud generated key)")
+	timeout := fs.Duration("gcloud-timeout", time.Minute, "max duration of each gcloud invocation")
+	addGcloudFlags(fs)
+	parseFlags(fs, args)
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	opts := sshrunner.Options{Identity: *identity}
+	if opts.Identity == "" {
+		opts.Identity = conf.Identities[inventory.ActiveConfig()]
+	}
+	keyFile, err := sshrunner.IdentityFile(opts)
+	if err != nil {
+		return err
+	}
+
+	pub, err := os.ReadFile(keyFile + ".pub")
+	if err != nil {
+		return fmt.Errorf("read public key error: %w", err)
+	}
+
+	r := runner.Exec{}
+	fingerprint := keyFingerprint(ctx, r, keyFile+".pub")
+	fmt.Printf("Identity: %s %s\n", keyFile, fingerprint)
+
+	fmt.Println("Agent keys:")
+	out, err := runner.Output(ctx, r, runner.Cmd{Name: "ssh-add", Args: []string{"-l"}})
+	if err != nil {
+		// Exits 1 if the agent has no keys and 2 if it isn't running.
+		fmt.Printf("  none (%s)\n", strings.TrimSpace(string(out)))
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if fields := strings.Fields(line); err == nil && len(fields) > 1 {
+			mark := " "
+			if fields[1] == fingerprint {
+				mark = "*"
+			}
+			fmt.Printf("%s %s\n", mark, line)
+		}
+	}
+
+	profile, err := inventory.Gcloud{Timeout: *timeout, Runner: r}.OSLoginKeys(ctx)
+	if err != nil {
+		return gcloudErr(err)
+	}
+
+	var inProfile bool
+	for _, key := range profile {
+		inProfile = inProfile || keyMaterial(key) == keyMaterial(string(pub))
+	}
+	fmt.Printf("OS Login profile: %d keys, identity included: %t\n", len(profile), inProfile)
+
+	if !inProfile && len(profile) > 0 {
+		slog.Warn("OS Login VMs authenticate the profile keys, not the identity, until it is added by connecting to one")
+	}
+
+	return nil
+}
+
+// keyFingerprint returns the SHA256 fingerprint of the public key file, or empty if it cannot be computed.
+func keyFingerprint(ctx context.Context, r runner.Runner, pubFile string) string {
+	out, err := runner.Output(ctx, r, runner.Cmd{Name: "ssh-keygen", Args: []string{"-lf", pubFile}})
+	if fields := strings.Fields(string(out)); err == nil && len(fields) > 1 {
+		return fields[1]
+	}
+
+	return ""
+}
+
+// keyMaterial returns the base64 key of an authorized_keys formatted public key, ignoring its type and comment.
+func keyMaterial(key string) string {
+	if fields := strings.Fields(key); len(fields) > 1 {
+		return fields[1]
+	}
+
+	return ""
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/manifoldco/promptui"
+)
+
+// sshWaitTimeout is the max duration to wait for a started VM to accept ssh connections.
+const sshWaitTimeout = 2 * time.Minute
+
+// disruptiveOps are the instance operations interrupting the VM's workload,
+// which are confirmed before running them.
+var disruptiveOps = map[string]bool{"stop": true, "reset": true, "suspend": true}
+
+// runInstanceOp returns the subcommand running the gcloud compute instances
+// operation, e.g. start or stop, on the selected VM. Disruptive operations are
+// confirmed unless -y is specified.
+func runInstanceOp(op string) func(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	return func(ctx context.Context, fs *flag.FlagSet, args []string) error {
+		opts := addListFlags(fs)
+		fs.BoolVar(&opts.usePrev, "p", false, "use previously selected VM (if any) as filter")
+		fs.StringVar(&opts.ticket, "ticket", "", "change ticket ID required by a ticket policy of the VM, instead of prompting for it")
+		var yes bool
+		if disruptiveOps[op] {
+			fs.BoolVar(&yes, "y", false, "don't prompt for confirmation")
+		}
+		parseFlags(fs, args)
+
+		if fs.NArg() > 0 {
+			return errUsage
+		}
+
+		// selectVM enforces the policy of the selected VM.
+		opts.noStart = true
+		selected, _, err := selectVM(ctx, *opts, "op", op)
+		if err != nil {
+			return err
+		}
+		if !selected.GCE() {
+			return notGCE(selected)
+		}
+
+		if disruptiveOps[op] && !yes {
+			if err := confirmOp(op, selected); err != nil {
+				return err
+			}
+		}
+
+		stop := spin(fmt.Sprintf("Waiting for VM %s to %s", selected.Name, op))
+		err = opts.gcloud().InstanceOp(ctx, op, selected)
+		stop()
+		if err != nil {
+			return gcloudErr(err)
+		}
+
+		return nil
+	}
+}
+
+// confirmOp prompts the user to confirm the operation on the VM.
+func confirmOp(op string, inst inventory.Instance) error {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return withExitCode(exitAbort, fmt.Errorf("confirm to %s VM %s in a terminal or use -y", op, inst.Name))
+	}
+
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("%s VM %s in %s (%s)", strings.ToUpper(op[:1])+op[1:], inst.Name, inst.Project, inst.Status),
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return withExitCode(exitAbort, fmt.Errorf("%s VM %s: %w", op, inst.Name, err))
+	}
+
+	return nil
+}
+
+// ensureRunning starts or resumes the VM if it is stopped or suspended, after
+// prompting the user unless -start was specified, and waits for it to accept
+// ssh connections, through IAP if iap is set. It returns the current state of the VM.
+func ensureRunning(ctx context.Context, opts options, inst inventory.Instance, iap bool) (inventory.Instance, error) {
+	var op string
+	switch inst.Status {
+	case "TERMINATED", "STOPPED":
+		op = "start"
+	case "SUSPENDED":
+		op = "resume"
+	default:
+		return inst, nil
+	}
+
+	if opts.offline {
+		return inst, nil
+	}
+
+	if !opts.start {
+		if !readline.IsTerminal(int(os.Stdin.Fd())) {
+			return inventory.Instance{}, fmt.Errorf("VM %s is %s, use -start to %s it", inst.Name, inst.Status, op)
+		}
+
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("VM %s is %s, %s it", inst.Name, inst.Status, op),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return inventory.Instance{}, fmt.Errorf("VM %s is %s: %w", inst.Name, inst.Status, err)
+		}
+	}
+
+	gc := opts.gcloud()
+
+	stop := spin(fmt.Sprintf("Waiting for VM %s to %s", inst.Name, op))
+	err := gc.InstanceOp(ctx, op, inst)
+	stop()
+	if err != nil {
+		return inventory.Instance{}, gcloudErr(err)
+	}
+
+	return waitSSH(ctx, gc, inst, opts.sshPort(), iap, sshWaitTimeout)
+}
+
+// waitVMs relists the VMs with backoff until any match the options or the
+// deadline is reached.
+func waitVMs(ctx context.Context, opts options, deadline time.Time) (listing, error) {
+	backoff := time.Second
+	for i := 0; ; i++ {
+		l, err := listVMs(ctx, opts)
+		if err == nil && len(l.instances) > 0 {
+			return l, nil
+		} else if err != nil && ctx.Err() != nil {
+			return listing{}, err
+		}
+
+		if i == 0 {
+			slog.Info("Waiting for a matching VM", "timeout", time.Until(deadline).Truncate(time.Second))
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			if err != nil {
+				return listing{}, err
+			}
+			return l, nil
+		}
+
+		slog.Debug("Waiting for a matching VM", "err", err)
+
+		select {
+		case <-ctx.Done():
+			return listing{}, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+		opts.refresh = true
+	}
+}
+
+// waitSSH polls until the VM is running and the ssh port accepts connections,
+// returning its current state. The port is probed through IAP if iap is set or
+// the VM has no external IP and its internal IP isn't reachable. If the timeout
+// is reached, it warns and returns the last known state.
+func waitSSH(ctx context.Context, gc inventory.Gcloud, inst inventory.Instance, port string, iap bool, timeout time.Duration) (inventory.Instance, error) {
+	stop := spin(fmt.Sprintf("Waiting for ssh on VM %s", inst.Name))
+	defer stop()
+
+	deadline := time.Now().Add(timeout)
+	backoff := time.Second
+	for {
+		current, err := gc.Describe(ctx, inst)
+		if err == nil {
+			inst = current
+			if inst.Status == "RUNNING" && sshReady(ctx, gc, inst, port, iap) {
+				return inst, nil
+			}
+		} else if ctx.Err() != nil {
+			return inventory.Instance{}, ctx.Err()
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			slog.Warn("Timed out waiting for ssh, connecting anyway", "vm", inst.Name, "timeout", timeout, "err", err)
+			return inst, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return inventory.Instance{}, ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// iapProbeTimeout is the max duration of probing the ssh port of a VM through IAP.
+const iapProbeTimeout = 15 * time.Second
+
+// sshReady returns true if the ssh port of the running VM accepts connections,
+// directly or, if iap is set or it has no external IP, through IAP. If IAP
+// can't be probed, e.g. without permission, it relies on the VM's status.
+func sshReady(ctx context.Context, gc inventory.Gcloud, inst inventory.Instance, port string, iap bool) bool {
+	if !iap && inventory.Latency(ctx, []inventory.Instance{inst}, port, 2*time.Second)[0] > 0 {
+		return true
+	} else if !iap && inst.ExternalIP() != "" {
+		return false
+	}
+
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return true
+	}
+
+	gc.Timeout = iapProbeTimeout
+	ok, err := gc.IAPReachable(ctx, inst, p)
+	if err != nil {
+		slog.Debug("Failed to probe ssh through IAP, assuming the running VM is ready", "vm", inst.Name, "err", err)
+		return true
+	}
+
+	return ok
+}
+
+// instanceOpOnExit stops or suspends the VM after the session ended, even if it was aborted.
+func instanceOpOnExit(ctx context.Context, opts options, inst inventory.Instance) error {
+	ctx = context.WithoutCancel(ctx)
+
+	stop := spin(fmt.Sprintf("Waiting for VM %s to %s", inst.Name, opts.exitOp))
+	err := opts.gcloud().InstanceOp(ctx, opts.exitOp, inst)
+	stop()
+	if err != nil {
+		return gcloudErr(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+	"sync"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// logLevel is the level of the default logger, set by the verbosity flags.
+var logLevel = new(slog.LevelVar)
+
+// addLogFlags registers the verbosity flags which set the log level when parsed.
+func addLogFlags(fs *flag.FlagSet) {
+	fs.BoolFunc("v", "verbose: log debug diagnostics", func(string) error {
+		logLevel.Set(slog.LevelDebug)
+		return nil
+	})
+	fs.BoolFunc("vv", "very verbose: also log every executed subprocess", func(string) error {
+		logLevel.Set(runner.LevelTrace)
+		return nil
+	})
+	fs.BoolFunc("quiet", "only log warnings and errors", func(string) error {
+		logLevel.Set(slog.LevelWarn)
+		return nil
+	})
+}
+
+// cliHandler is a slog.Handler that writes human readable "Message: key=value" lines.
+type cliHandler struct {
+	mu    *sync.Mutex
+	w     io.Writer
+	level slog.Leveler
+	attrs []slog.Attr
+}
+
+func newCLIHandler(w io.Writer, level slog.Leveler) *cliHandler {
+	return &cliHandler{mu: new(sync.Mutex), w: w, level: level}
+}
+
+func (h *cliHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *cliHandler) Handle(_ context.Context, r slog.Record) error {
+	var b bytes.Buffer
+	switch {
+	case r.Level >= slog.LevelError:
+		b.WriteString("Error: ")
+	case r.Level >= slog.LevelWarn:
+		b.WriteString("Warning: ")
+	case r.Level < slog.LevelDebug:
+		b.WriteString("Trace: ")
+	case r.Level < slog.LevelInfo:
+		b.WriteString("Debug: ")
+	}
+
+	b.WriteString(r.Message)
+
+	sep := ": "
+	write := func(a slog.Attr) bool {
+		b.WriteString(sep)
+		sep = ", "
+
+		v := a.Value.Resolve().String()
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		b.WriteString(a.Key + "=" + v)
+
+		return true
+	}
+
+	for _, a := range h.attrs {
+		write(a)
+	}
+	r.Attrs(write)
+	b.WriteByte('\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	_, err := h.w.Write(b.Bytes())
+
+	return err
+}
+
+func (h *cliHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr(nil), h.attrs...), attrs...)
+
+	return &clone
+}
+
+// WithGroup is not supported, groups are flattened.
+func (h *cliHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// Remote search scripts, the first arg is the pattern and the others are passed
+// to journalctl or grep. Grep exits with 1 if nothing matches, which isn't an error.
+const (
+	journalSearchScript = `p=$1; shift; sudo journalctl --no-pager -o short-iso-precise "$@" | grep -E -e "$p"; [ $? -le 1 ]`
+	fileSearchScript    = `p=$1; shift; sudo grep -h -E -e "$p" "$@"; [ $? -le 1 ]`
+)
+
+// logTimeLayouts are the timestamp layouts of journalctl -o short-iso-precise
+// and of log files with RFC 3339 timestamps.
+var logTimeLayouts = []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999-0700"}
+
+// logHit is a log line matching the search.
+type logHit struct {
+	host string
+	time time.Time
+	line string
+}
+
+// runGrep searches the journal, or the log files, of the matching VMs in
+// parallel and prints the matching lines merged by timestamp, prefixed with
+// the VM name.
+func runGrep(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	since := fs.Duration("since", time.Hour, "only search journal entries of this recent duration")
+	unit := fs.String("unit", "", "only search the journal of this systemd unit")
+	var files []string
+	fs.Func("file", "search this log file instead of the journal, may be repeated", func(s string) error {
+		files = append(files, s)
+		return nil
+	})
+	maxVMs := fs.Int("max", 50, "max number of VMs to search, to guard against a too broad filter")
+	parallel := fs.Int("parallel", 10, "max number of VMs searched concurrently")
+	parseFlags(fs, args)
+
+	if fs.NArg() != 1 || *parallel < 1 {
+		return errUsage
+	}
+
+	l, err := listVMs(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	var instances []inventory.Instance
+	for _, inst := range l.instances {
+		if inst.Status == "" || inst.Status == "RUNNING" {
+			instances = append(instances, inst)
+		}
+	}
+	if len(instances) == 0 {
+		return withExitCode(exitNoMatch, fmt.Errorf("no running VMs found for filter '%s'", l.filter))
+	} else if len(instances) > *maxVMs {
+		return fmt.Errorf("%d VMs match the filter, more than -max %d", len(instances), *maxVMs)
+	}
+
+	// Check the policies of all VMs before searching any of them, prompting for
+	// a change ticket ID at most once.
+	for _, inst := range instances {
+		if err := enforcePolicy(*opts, l.conf, inst); err != nil {
+			return err
+		}
+		if policyTicket != "" {
+			opts.ticket = policyTicket
+		}
+	}
+
+	script := journalSearchScript
+	searchArgs := []string{fs.Arg(0)}
+	if len(files) > 0 {
+		script = fileSearchScript
+		searchArgs = append(searchArgs, files...)
+	} else {
+		searchArgs = append(searchArgs, fmt.Sprintf("--since=-%ds", int(since.Seconds())))
+		if *unit != "" {
+			searchArgs = append(searchArgs, "--unit="+*unit)
+		}
+	}
+
+	slog.Info("Searching logs", "vms", len(instances), "pattern", fs.Arg(0))
+
+	var (
+		hits   []logHit
+		failed int
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		sem    = make(chan struct{}, *parallel)
+	)
+	for _, inst := range instances {
+		// Prepare sequentially since it may prompt, e.g. to add the OS Login key.
+		sshOpts := sshrunner.Options{Args: append([]string{"sh", "-c", script, "gssh-grep"}, searchArgs...)}
+		if err := prepareSSH(ctx, *opts, l.conf, inst, &sshOpts); err != nil {
+			return err
+		}
+		sshOpts.TTY = "false"
+
+		wg.Add(1)
+		go func(inst inventory.Instance) {
+			defer wg.Done()
+
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			found, err := searchLogs(ctx, *opts, inst, sshOpts)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				failed++
+				slog.Warn("Failed to search logs", "vm", inst.Name, "err", err)
+			}
+			hits = append(hits, found...)
+		}(inst)
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	// Lines without a timestamp, e.g. of log files, are printed last.
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].time.IsZero() || hits[j].time.IsZero() {
+			return !hits[i].time.IsZero() && hits[j].time.IsZero()
+		}
+		return hits[i].time.Before(hits[j].time)
+	})
+
+	var width int
+	for _, inst := range instances {
+		width = max(width, len(inst.Name))
+	}
+	for _, hit := range hits {
+		fmt.Printf("%-*s | %s\n", width, hit.host, hit.line)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("searching %d of %d VMs failed", failed, len(instances))
+	} else if len(hits) == 0 {
+		return withExitCode(exitNoMatch, fmt.Errorf("no log lines match %q", fs.Arg(0)))
+	}
+
+	return nil
+}
+
+// searchLogs runs the search command on the VM and returns the matching lines.
+func searchLogs(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options) ([]logHit, error) {
+	cmds, err := sshrunner.Command(inst, sshOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	var stdout, stderr bytes.Buffer
+	err = opts.runner.Run(ctx, runner.Cmd{Name: cmds[0], Args: cmds[1:], Stdout: &stdout, Stderr: &stderr})
+	if err != nil {
+		return nil, fmt.Errorf("%w, %s", err, bytes.TrimSpace(stderr.Bytes()))
+	}
+
+	var hits []logHit
+	s := bufio.NewScanner(&stdout)
+	for s.Scan() {
+		hit := logHit{host: inst.Name, line: s.Text()}
+		stamp, _, _ := strings.Cut(hit.line, " ")
+		for _, layout := range logTimeLayouts {
+			if t, err := time.Parse(layout, stamp); err == nil {
+				hit.time = t
+				break
+			}
+		}
+		hits = append(hits, hit)
+	}
+
+	return hits, s.Err()
+}
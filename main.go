@@ -3,285 +3,158 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"github.com/manifoldco/promptui"
 	"log/slog"
 	"os"
-	"os/exec"
-	"path"
-	"path/filepath"
-	"regexp"
-	"sort"
-	"strings"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/selector"
 )
 
-const noUserFlag = " "
+// command is a gssh subcommand.
+type command struct {
+	name  string
+	usage string
+	desc  string
+	// run registers the subcommand flags, parses the args and executes it.
+	run func(ctx context.Context, fs *flag.FlagSet, args []string) error
+}
 
-var (
-	flagUser   = flag.String("u", noUserFlag, "ssh username (overrides $GSSH_USER env var)")
-	flagFilter = flag.String("f", "", "regex filter VMs by name")
-	flagHost   = flag.String("h", "", "specific VM host name (alias for -f '^host$')")
-	flagPrev   = flag.Bool("p", false, "use previously selected VM (if any) as filter")
-	flagFwd    = flag.String("L", "", "configures SSH port forwarding. Equivalent to 'ssh -L <value>'")
-)
+// commands are the gssh subcommands, the first is the default.
+var commands = []command{
+	{"connect", "[-h host] [-f filter_regex] [-p] [-u user] [-P projects] [-L spec] [ssh_args ...]", "SSH to a VM (default)", runConnect},
+	{"list", "[-h host] [-f filter_regex] [-P projects] [-o table|json|csv|names]", "List VMs without connecting", runList},
+	{"exec", "[-h host] [-f filter_regex] [-p] [-u user] command [args ...]", "Execute a command on a VM", runExec},
+	{"run", "[-h host] [-f filter_regex] [-p] [-u user] [-list] [snippet [args ...]]", "Run a named remote command snippet from the config on a VM, prompting for one if not specified", runSnippet},
+	{"cp", "[-h host] [-f filter_regex] [-p] [-u user] [-r] [-compress] src ... dst", "Copy files to/from a VM, remote paths are prefixed with ':'", runCopy},
+	{"docker", "[-h host] [-f filter_regex] [-p] [-u user] [command [args ...]]", "Select a VM and one of its running containers and exec a shell (or the command) in it", runDocker},
+	{"grep", "[-f filter_regex] [-P projects] [-u user] [-since duration] [-unit unit] [-file path] pattern", "Search the journal (or log files) of the matching VMs in parallel, printing the matches merged by timestamp", runGrep},
+	{"systemd", "[-h host] [-f filter_regex] [-p] [-u user] [-all] [unit_pattern]", "List the failed (or matching) systemd units of a VM and run status, restart and journal actions on them", runSystemd},
+	{"tunnel", "[-h host] [-f filter_regex] [-p] [-u user] spec ...", "Forward ports to a VM without a shell, spec as in 'ssh -L spec'", runTunnel},
+	{"ports", "[-h host] [-f filter_regex] [-p] [-u user] [-local-port port]", "List the listening TCP ports of a VM with their processes and forward the selected one", runPorts},
+	{"port-check", "[-h host] [-f filter_regex] [-p] [-u user] [-timeout duration] port ...", "Check whether TCP ports of a VM are open from this machine, via IAP and from the VM itself", runPortCheck},
+	{"panes", "[-f filter_regex] [-P projects] [-u user] [-layout tmux|iterm2|wezterm|kitty] [-tabs]", "Open a session to each matching VM in split panes or tabs of the terminal", runPanes},
+	{"start", "[-h host] [-f filter_regex] [-p]", "Start a stopped VM", runInstanceOp("start")},
+	{"stop", "[-h host] [-f filter_regex] [-p] [-y]", "Stop a VM", runInstanceOp("stop")},
+	{"reset", "[-h host] [-f filter_regex] [-p] [-y]", "Hard reset a VM", runInstanceOp("reset")},
+	{"suspend", "[-h host] [-f filter_regex] [-p] [-y]", "Suspend a VM", runInstanceOp("suspend")},
+	{"resume", "[-h host] [-f filter_regex] [-p]", "Resume a suspended VM", runInstanceOp("resume")},
+	{"scratch", "[-machine-type type] [-image-family family] [-zone zone] [-keep]", "Create a short-lived VM, connect to it and delete it when the session ends", runScratch},
+	{"code", "[-h host] [-f filter_regex] [-p] [-u user] [path]", "Open VS Code connected to a VM via Remote-SSH", runCode},
+	{"gateway", "[-h host] [-f filter_regex] [-p] [-u user] [-print-url] [path]", "Open JetBrains Gateway connected to a VM via ssh", runGateway},
+	{"ssh-config", "[-h host] [-f filter_regex] [-P projects] [-u user] [-o file]", "Write an ssh_config fragment with a Host per VM, for plain ssh and tools like VS Code", runSSHConfig},
+	{"ansible", "[-h host] [-f filter_regex] [-P projects] [-internal]", "Print the VMs as Ansible dynamic inventory JSON, grouped by zone, project and label", runAnsible},
+	{"setup", "", "Interactively configure the default projects, ssh user, IAP preference and cache TTL", runSetup},
+	{"config", "[show|path|set key value]", "Show or update the gssh config", runConfig},
+	{"doctor", "[-probe-timeout duration]", "Check gcloud, credentials, IAP connectivity, OS Login keys, the config and the cache, printing fixes for failures", runDoctor},
+	{"keys", "[-i identity_file]", "Show the ssh identity, the keys loaded in the ssh agent and the OS Login profile", runKeys},
+	{"update", "[-check-only]", "Replace the gssh binary with the latest GitHub release after verifying its checksum", runUpdate},
+	{"completion", "bash|zsh|fish", "Print the shell completion script, completing commands, flags, configurations and cached VM names", runCompletion},
+	{"stats", "[-team-report] [-days n]", "Show the locally recorded usage (opt-in via the usage_stats config), or an anonymized report for platform teams", runStats},
+	{"history", "[-n count]", "Show previously selected VMs", runHistory},
+	{"daemon", "[-cache-ttl duration] [-api] [-metrics-addr addr]", "Keep VM lists warm in the background", runDaemon},
+	{"prefetch", "[-cache-ttl duration] [-api]", "Silently refresh the cached VM lists, e.g. from shell init or a timer", runPrefetch},
+}
+
+// errUsage is returned by subcommands invoked with invalid positional args.
+var errUsage = errors.New("invalid usage")
 
 func main() {
 	o := flag.CommandLine.Output()
-	flag.Usage = func() {
+	usage := func() {
 		fmt.Fprint(o, "gssh is a wrapper around `gcloud compute ssh` that autocompletes VM names\n")
 		fmt.Fprint(o, "\n")
-		fmt.Fprint(o, "Usage: gssh [-h host] [-f filter_regex] [-p] [-u user] [ssh_args ...]\n")
-		fmt.Fprint(o, "\n")
-		fmt.Fprint(o, "Arguments:\n")
-		fmt.Fprint(o, "  ssh_args\tFlags and positionals passed to the underlying ssh implementation.\n")
+		fmt.Fprint(o, "Usage: gssh [command] [flags] [args ...]\n")
 		fmt.Fprint(o, "\n")
-		fmt.Fprint(o, "Flags:\n")
-		flag.PrintDefaults()
-	}
-	flag.Parse()
-
-	var user string
-	if u, ok := os.LookupEnv("GSSH_USER"); ok {
-		user = u
-	}
-	if *flagUser != noUserFlag {
-		user = *flagUser
-	}
-
-	err := run(*flagHost, *flagFilter, user, *flagPrev, *flagFwd, flag.Args())
-	if err != nil {
-		fmt.Fprintf(o, "Fatal error: %v", err)
-		os.Exit(1)
-	}
-}
-
-// run executes the gssh command.
-func run(hostname string, filter string, user string, usePrev bool, flagFwd string, args []string) error {
-	if hostname != "" && filter != "" {
-		return fmt.Errorf("cannot use both -h and -f flags")
-	} else if hostname != "" {
-		filter = fmt.Sprintf("^%s$", hostname)
-	}
-
-	filterExp, err := regexp.Compile(filter)
-	if err != nil {
-		return fmt.Errorf("invalid filter regex: %w", err)
-	}
-
-	project, err := getGcloudConfig("project")
-	if err != nil {
-		return err
-	}
-
-	fmt.Printf("Using: project=%q, user=%q, filter=%q, prev=%v, portfwd=%v, len(args)=%d\n", project, user, filter, usePrev, flagFwd, len(args))
-
-	var prev instance
-	if conf, err := loadConfig(); err == nil {
-		prev = conf.Previous
-	} else if usePrev {
-		return fmt.Errorf("cannot connect to previous VM, load config error: %w", err)
-	}
-
-	var instances []instance
-	if usePrev {
-		instances = []instance{prev}
-	} else {
-		output, err := exec.Command("gcloud", "compute", "instances", "list", "--format=json").CombinedOutput()
-		if err != nil {
-			return fmt.Errorf("gcloud compute instances list error: %w, %s", err, output)
+		fmt.Fprint(o, "Commands:\n")
+		for _, cmd := range commands {
+			fmt.Fprintf(o, "  %-12s%s\n", cmd.name, cmd.desc)
 		}
-
-		err = json.Unmarshal(output, &instances)
-		if err != nil {
-			return fmt.Errorf("unmarshal instances error: %w", err)
-		}
-
-		instances = sortInstances(instances)
+		fmt.Fprint(o, "\n")
+		fmt.Fprint(o, "Run 'gssh <command> -help' for the command's flags, 'gssh -version' for the build info.\n")
+		fmt.Fprint(o, "\n")
+		fmt.Fprint(o, "Exit codes:\n")
+		fmt.Fprint(o, "  241 error, 242 usage, 243 no matching VM, 244 multiple VMs for -h, 245 gcloud failure, 246 auth failure, 247 aborted\n")
+		fmt.Fprint(o, "  otherwise the exit code of the ssh session, i.e. of the remote command or 255 if ssh failed\n")
 	}
 
-	instances = filterInstances(instances, filterExp)
+	slog.SetDefault(slog.New(newCLIHandler(os.Stderr, logLevel)))
+	selector.EnableVT()
 
-	if len(instances) == 0 {
-		msg := "no VMs found"
-		if filter != "" {
-			msg += fmt.Sprintf(" for filter '%s'", filter)
-		}
-		return fmt.Errorf(msg)
-	}
+	// Abort promptly on Ctrl-C or SIGTERM, killing any running gcloud subprocesses
+	// and restoring the terminal if the selector is active.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	selected := instances[0]
-	if len(instances) > 1 {
-		if hostname != "" {
-			return fmt.Errorf("multiple VMs found for hostname %q", hostname)
+	cmd, args := commands[0], os.Args[1:]
+	if len(args) > 0 {
+		if args[0] == "help" {
+			usage()
+			return
 		}
-
-		selected, err = selectInstance(instances, prev)
-		if err != nil {
-			return fmt.Errorf("select instance error: %w", err)
+		if args[0] == "-version" || args[0] == "--version" {
+			fmt.Println(buildInfo())
+			return
 		}
-	}
-
-	zone := selected.TrimZone()
-	host := selected.Name
-	fmt.Printf("Selected VM: %s (zone=%s)\n", host, zone)
-
-	if err = storeConfig(config{Previous: selected}); err != nil {
-		slog.Debug("Failed to store config", "err", err)
-	}
-
-	if user != "" {
-		host = user + "@" + host
-	}
-
-	cmds := []string{"gcloud", "compute", "ssh", fmt.Sprintf("--zone=%s", zone)}
-	if len(flagFwd) > 0 {
-		cmds = append(cmds, fmt.Sprintf("--ssh-flag=-L %s", flagFwd))
-	}
-	cmds = append(cmds, host)
-	if len(args) > 0 {
-		cmds = append(cmds, "--", strings.Join(args, " "))
-	}
-
-	fmt.Printf("Executing: %s\n\n", strings.Join(cmds, " "))
-
-	c := exec.Command(cmds[0], cmds[1:]...)
-	c.Stdin = os.Stdin
-	c.Stdout = os.Stdout
-	c.Stderr = os.Stderr
-	return c.Run()
-}
-
-// selectInstance prompts the user to select one of the given instances,
-// preselecting the previous instance if possible.
-func selectInstance(instances []instance, prev instance) (instance, error) {
-	var labels []string
-	var cursor int
-	for i, inst := range instances {
-		label := fmt.Sprintf("%-40s%s", inst.Name, inst.TrimZone())
-
-		labels = append(labels, label)
 
-		if inst.Name == prev.Name {
-			cursor = i
+		for _, c := range commands {
+			if c.name == args[0] {
+				cmd, args = c, args[1:]
+				break
+			}
 		}
 	}
 
-	selector := promptui.Select{
-		Label: "Select VM",
-		Items: labels,
-		Size:  len(labels),
-	}
-
-	idx, _, err := selector.RunCursorAt(cursor, 0)
-	if err != nil {
-		return instance{}, fmt.Errorf("selector error: %w", err)
-	}
-
-	return instances[idx], nil
-}
-
-// filterInstances filters instances by name regex.
-func filterInstances(instances []instance, regex *regexp.Regexp) []instance {
-	if regex.String() == "" {
-		return instances
-	}
-
-	var filtered []instance
-	for _, inst := range instances {
-		if regex.MatchString(inst.Name) {
-			filtered = append(filtered, inst)
+	fs := flag.NewFlagSet("gssh "+cmd.name, flag.ContinueOnError)
+	fs.Usage = func() {
+		if cmd.name == commands[0].name {
+			usage()
+			fmt.Fprint(o, "\n")
 		}
+		fmt.Fprintf(o, "Usage: gssh %s %s\n", cmd.name, cmd.usage)
+		fmt.Fprint(o, "\n")
+		fmt.Fprint(o, "Flags:\n")
+		fs.PrintDefaults()
 	}
 
-	return filtered
-}
-
-// sortInstances sorts instances by name.
-func sortInstances(instances []instance) []instance {
-	sort.Slice(instances, func(i, j int) bool {
-		return instances[i].Name < instances[j].Name
-	})
-
-	return instances
-}
-
-// instance is a gcloud compute instance.
-type instance struct {
-	Name string
-	Zone string
-}
-
-func (i instance) TrimZone() string {
-	return filepath.Base(i.Zone)
-}
+	addLogFlags(fs)
+	jsonErrors := fs.Bool("json-errors", false, "print fatal errors as JSON objects with a machine-readable kind and exit code")
 
-// getGcloudConfig returns the value of a gcloud config property.
-func getGcloudConfig(name string) (string, error) {
-	output, err := exec.Command("gcloud", "config", "get", name).CombinedOutput()
-	if err != nil {
-		return "", fmt.Errorf("gcloud config get %s error: %w, %s", name, err, output)
+	if cmd.name != "completion" && cmd.name != "update" {
+		updateNotice(ctx)
 	}
-
-	return strings.TrimSpace(string(output)), nil
-}
-
-// loadConfig loads the gssh config file.
-func loadConfig() (config, error) {
-	filename, ok := configPath()
-	if !ok {
-		return config{}, fmt.Errorf("HOME env var not present, cannot read config")
+	if cmd.name == commands[0].name {
+		firstRun(ctx)
 	}
 
-	b, err := os.ReadFile(filename)
-	if os.IsNotExist(err) {
-		return config{}, nil
+	t0 := time.Now()
+	err := cmd.run(ctx, fs, args)
+	recordUsage(cmd.name, fs, err, t0)
+	runner.Cleanup()
+	if errors.Is(err, errUsage) {
+		fs.Usage()
+		os.Exit(exitUsage)
+	} else if errors.As(err, new(sessionExit)) {
+		os.Exit(exitCode(err))
 	} else if err != nil {
-		return config{}, fmt.Errorf("read config error: %w", err)
+		printError(o, err, *jsonErrors)
+		os.Exit(exitCode(err))
 	}
-
-	var conf config
-	err = json.Unmarshal(b, &conf)
-	if err != nil {
-		return config{}, fmt.Errorf("unmarshal config error: %w", err)
-	}
-
-	return conf, nil
 }
 
-// storeConfig stores the gssh config file.
-func storeConfig(conf config) error {
-	b, err := json.MarshalIndent(conf, "", "  ")
-	if err != nil {
-		return fmt.Errorf("marshal config error: %w", err)
-	}
-
-	filename, ok := configPath()
-	if !ok {
-		return fmt.Errorf("HOME env var not present, cannot store config")
-	}
-
-	err = os.WriteFile(filename, b, 0666)
-	if err != nil {
-		return fmt.Errorf("write config error: %w", err)
-	}
-
-	return nil
-}
-
-// configPath returns true and the path to the gssh config file or false if
-// the HOME env var is not present.
-func configPath() (string, bool) {
-	home, ok := os.LookupEnv("HOME")
-	if !ok {
-		return "", false
+// parseFlags parses the command's flags like flag.ExitOnError, but exits with
+// gssh's usage exit code if they are invalid, after the flag set printed the error and usage.
+func parseFlags(fs *flag.FlagSet, args []string) {
+	err := fs.Parse(args)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	} else if err != nil {
+		os.Exit(exitUsage)
 	}
-
-	return path.Join(home, ".gssh.json"), true
-}
-
-// config is the gssh config file format.
-type config struct {
-	Previous instance `json:"previous"`
 }
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// layouts open a gssh session per VM as panes or tabs of the terminal.
+var layouts = map[string]func(ctx context.Context, r runner.Runner, sessions []paneSession, tabs bool) error{
+	"tmux":    tmuxPanes,
+	"iterm2":  itermPanes,
+	"wezterm": weztermPanes,
+	"kitty":   kittyPanes,
+}
+
+// paneSession is the gssh session of a VM opened in a pane.
+type paneSession struct {
+	name string
+	cmd  []string
+}
+
+// runPanes opens an interactive session to each VM matching the filters as
+// split panes (or tabs) of tmux, iTerm2, WezTerm or kitty.
+func runPanes(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addListFlags(fs)
+	fs.StringVar(&opts.user, "u", os.Getenv("GSSH_USER"), "ssh username (overrides $GSSH_USER env var)")
+	layout := fs.String("layout", "", "terminal to open the panes in: tmux, iterm2, wezterm or kitty (default detected from the running terminal)")
+	tabs := fs.Bool("tabs", false, "open a tab (tmux window) per VM instead of split panes")
+	maxVMs := fs.Int("max", 16, "max number of VMs to open, to guard against a too broad filter")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	if *layout == "" {
+		*layout = detectLayout()
+		if *layout == "" {
+			return fmt.Errorf("no supported terminal detected, specify -layout")
+		}
+	}
+	openPanes, ok := layouts[*layout]
+	if !ok {
+		return fmt.Errorf("unknown layout %q, expected tmux, iterm2, wezterm or kitty", *layout)
+	}
+
+	l, err := listVMs(ctx, *opts)
+	if err != nil {
+		return err
+	} else if len(l.instances) == 0 {
+		return withExitCode(exitNoMatch, fmt.Errorf("no VMs found for filter '%s'", l.filter))
+	} else if len(l.instances) > *maxVMs {
+		return fmt.Errorf("%d VMs match the filter, more than -max %d", len(l.instances), *maxVMs)
+	}
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("executable error: %w", err)
+	}
+
+	var sessions []paneSession
+	for _, inst := range l.instances {
+		sessions = append(sessions, paneSession{name: inst.Name, cmd: paneCommand(self, *opts, inst)})
+	}
+
+	slog.Info("Opening panes", "layout", *layout, "vms", len(sessions))
+
+	return openPanes(ctx, opts.runner, sessions, *tabs)
+}
+
+// paneCommand returns the gssh command connecting to the instance in a pane.
+func paneCommand(self string, opts options, inst inventory.Instance) []string {
+	cmd := []string{self, "connect", "-h", inst.Name}
+	if inst.Project != "" {
+		cmd = append(cmd, "-P", inst.Project)
+	}
+	if opts.user != "" {
+		cmd = append(cmd, "-u", opts.user)
+	}
+	if opts.offline {
+		cmd = append(cmd, "-offline")
+	}
+	// Panes don't necessarily inherit the environment, e.g. of the tmux server.
+	if name := os.Getenv("CLOUDSDK_ACTIVE_CONFIG_NAME"); name != "" {
+		cmd = append(cmd, "-configuration", name)
+	}
+
+	return cmd
+}
+
+// detectLayout returns the layout of the running terminal, or empty if not supported.
+func detectLayout() string {
+	switch {
+	case os.Getenv("TMUX") != "":
+		return "tmux"
+	case os.Getenv("TERM_PROGRAM") == "iTerm.app":
+		return "iterm2"
+	case os.Getenv("TERM_PROGRAM") == "WezTerm":
+		return "wezterm"
+	case os.Getenv("KITTY_WINDOW_ID") != "":
+		return "kitty"
+	default:
+		return ""
+	}
+}
+
+// tmuxPanes opens the sessions as panes of a new tmux window, tiled, or as windows.
+func tmuxPanes(ctx context.Context, r runner.Runner, sessions []paneSession, tabs bool) error {
+	var window string
+	for i, s := range sessions {
+		args := []string{"split-window", "-t", window}
+		if tabs || i == 0 {
+			args = []string{"new-window", "-n", s.name}
+		}
+		args = append(args, "-P", "-F", "#{window_id}", sshrunner.Quote(s.cmd))
+
+		out, err := runner.Output(ctx, r, runner.Cmd{Name: "tmux", Args: args})
+		if err != nil {
+			return fmt.Errorf("tmux error: %w", err)
+		}
+		window = strings.TrimSpace(string(out))
+
+		if !tabs && i > 0 {
+			// Re-tile after each split so that the window doesn't run out of space.
+			err = r.Run(ctx, runner.Cmd{Name: "tmux", Args: []string{"select-layout", "-t", window, "tiled"}})
+			if err != nil {
+				return fmt.Errorf("tmux error: %w", err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// weztermPanes opens the sessions as panes of a new WezTerm tab, or as tabs.
+func weztermPanes(ctx context.Context, r runner.Runner, sessions []paneSession, tabs bool) error {
+	var pane string
+	for i, s := range sessions {
+		args := []string{"cli", "split-pane", "--pane-id", pane}
+		if tabs || i == 0 {
+			args = []string{"cli", "spawn"}
+		}
+		args = append(append(args, "--"), s.cmd...)
+
+		out, err := runner.Output(ctx, r, runner.Cmd{Name: "wezterm", Args: args})
+		if err != nil {
+			return fmt.Errorf("wezterm error: %w", err)
+		}
+		if i == 0 {
+			pane = strings.TrimSpace(string(out))
+		}
+	}
+
+	return nil
+}
+
+// kittyPanes opens the sessions as windows of a new kitty tab, or as tabs.
+// It requires allow_remote_control in kitty.conf.
+func kittyPanes(ctx context.Context, r runner.Runner, sessions []paneSession, tabs bool) error {
+	var window string
+	for i, s := range sessions {
+		args := []string{"@", "launch", "--type=window", "--match", "window_id:" + window, "--title", s.name}
+		if tabs || i == 0 {
+			args = []string{"@", "launch", "--type=tab", "--tab-title", s.name, "--title", s.name}
+		}
+		args = append(args, s.cmd...)
+
+		out, err := runner.Output(ctx, r, runner.Cmd{Name: "kitty", Args: args})
+		if err != nil {
+			return fmt.Errorf("kitty error: %w", err)
+		}
+		if i == 0 {
+			window = strings.TrimSpace(string(out))
+		}
+	}
+
+	return nil
+}
+
+// itermPanes opens the sessions as split panes of a new iTerm2 tab, or as tabs,
+// via its AppleScript API since the escape-code API can't run commands.
+func itermPanes(ctx context.Context, r runner.Runner, sessions []paneSession, tabs bool) error {
+	var b strings.Builder
+	b.WriteString("tell application \"iTerm2\"\n  tell current window\n")
+	for i, s := range sessions {
+		command := appleScriptString(sshrunner.Quote(s.cmd))
+		if tabs || i == 0 {
+			fmt.Fprintf(&b, "    create tab with default profile command %s\n", command)
+		} else {
+			// Alternate the split direction so that the panes tile.
+			direction := "vertically"
+			if i%2 == 0 {
+				direction = "horizontally"
+			}
+			fmt.Fprintf(&b, "    tell current session of current tab to split %s with default profile command %s\n", direction, command)
+		}
+	}
+	b.WriteString("  end tell\nend tell\n")
+
+	err := r.Run(ctx, runner.Cmd{Name: "osascript", Args: []string{"-e", b.String()}})
+	if err != nil {
+		return fmt.Errorf("osascript error: %w", err)
+	}
+
+	return nil
+}
+
+// appleScriptString returns the string as an AppleScript string literal.
+func appleScriptString(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/manifoldco/promptui"
+)
+
+// policyTicket is the change ticket ID entered for the selected VM, included in audit events.
+var policyTicket string
+
+// enforcePolicy applies the strictest policy matching the selected VM: it
+// prompts for confirmation or a change ticket ID, or refuses to connect.
+// Without a terminal, a ticket ID can be provided with -ticket.
+func enforcePolicy(opts options, conf config.Config, inst inventory.Instance) error {
+	policies, err := config.LoadPolicies(conf)
+	if err != nil {
+		// Fail closed, a broken policy file shouldn't grant access.
+		return fmt.Errorf("load policies error: %w", err)
+	}
+
+	p, ok := config.StrictestPolicy(policies, inst)
+	if !ok {
+		return nil
+	}
+
+	reason := fmt.Sprintf("VM %s is protected by a %s policy", inst.Name, p.Action)
+	if p.Message != "" {
+		reason += ": " + p.Message
+	}
+
+	terminal := readline.IsTerminal(int(os.Stdin.Fd()))
+
+	switch p.Action {
+	case config.PolicyRefuse:
+		return withExitCode(exitAbort, errors.New(reason))
+	case config.PolicyConfirm:
+		if !terminal {
+			return withExitCode(exitAbort, fmt.Errorf("%s, confirm it in a terminal", reason))
+		}
+
+		prompt := promptui.Prompt{
+			Label:     reason + ", continue",
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return withExitCode(exitAbort, fmt.Errorf("%s: %w", reason, err))
+		}
+
+		return nil
+	}
+
+	pattern := regexp.MustCompile(p.TicketPattern) // Validated by LoadPolicies.
+	validate := func(s string) error {
+		if strings.TrimSpace(s) == "" {
+			return errors.New("ticket ID required")
+		} else if !pattern.MatchString(strings.TrimSpace(s)) {
+			return fmt.Errorf("ticket ID must match %s", p.TicketPattern)
+		}
+		return nil
+	}
+
+	ticket := opts.ticket
+	if ticket == "" {
+		if !terminal {
+			return withExitCode(exitAbort, fmt.Errorf("%s, provide a change ticket ID with -ticket", reason))
+		}
+
+		prompt := promptui.Prompt{
+			Label:    reason + ", change ticket ID",
+			Validate: validate,
+		}
+		if ticket, err = prompt.Run(); err != nil {
+			return withExitCode(exitAbort, fmt.Errorf("%s: %w", reason, err))
+		}
+	} else if err := validate(ticket); err != nil {
+		return withExitCode(exitUsage, fmt.Errorf("invalid -ticket: %w", err))
+	}
+
+	policyTicket = strings.TrimSpace(ticket)
+
+	return nil
+}
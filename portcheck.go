@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// portCheckScript prints "<port> open" or "<port> closed" for each port arg
+// using bash's /dev/tcp, which doesn't require nc or ss on the VM.
+const portCheckScript = `for p in "$@"; do if (echo > /dev/tcp/127.0.0.1/$p) 2>/dev/null; then echo "$p open"; else echo "$p closed"; fi; done`
+
+// runPortCheck checks whether the TCP ports of the selected VM are open from
+// this machine, via IAP and from the VM itself, which tells firewall rules
+// apart from processes not listening or only listening on localhost.
+func runPortCheck(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	timeout := fs.Duration("timeout", 20*time.Second, "max duration of each check")
+	parseFlags(fs, args)
+
+	if fs.NArg() == 0 {
+		return errUsage
+	}
+
+	var ports []int
+	for _, arg := range fs.Args() {
+		port, err := strconv.Atoi(arg)
+		if err != nil || port < 1 || port > 65535 {
+			return errUsage
+		}
+		ports = append(ports, port)
+	}
+
+	selected, conf, err := selectVM(ctx, *opts, "ports", ports)
+	if err != nil {
+		return err
+	}
+
+	var (
+		direct = make([]string, len(ports))
+		iap    = make([]string, len(ports))
+		local  = make(map[int]string)
+		wg     sync.WaitGroup
+	)
+
+	ip := selected.ExternalIP()
+	if ip == "" {
+		ip = selected.InternalIP()
+	}
+
+	gc := opts.gcloud()
+	gc.Timeout = *timeout
+	for i, port := range ports {
+		wg.Add(1)
+		go func(i, port int) {
+			defer wg.Done()
+
+			direct[i] = "n/a"
+			if ip != "" {
+				direct[i] = openOrClosed(inventory.ReachableIP(ctx, ip, strconv.Itoa(port), *timeout), nil)
+			}
+
+			iap[i] = "n/a"
+			if selected.GCE() && !noGcloud {
+				iap[i] = openOrClosed(gc.IAPReachable(ctx, selected, port))
+			}
+		}(i, port)
+	}
+
+	localErr := checkLocalPorts(ctx, *opts, conf, selected, ports, *timeout, local)
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	fmt.Printf("VM %s (%s)\n\n", selected.Name, withDefault(ip, "no IP"))
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PORT\tDIRECT\tIAP\tVM-LOCALHOST")
+	for i, port := range ports {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", port, direct[i], iap[i], withDefault(local[port], "error"))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	if localErr != nil {
+		return fmt.Errorf("check ports on the VM error: %w", localErr)
+	}
+
+	return nil
+}
+
+// checkLocalPorts populates the result of each port connected to from the VM
+// itself, via a single ssh command.
+func checkLocalPorts(ctx context.Context, opts options, conf config.Config, inst inventory.Instance,
+	ports []int, timeout time.Duration, result map[int]string,
+) error {
+	// Multiple args are quoted individually, so the script is passed as is.
+	sshOpts := sshrunner.Options{Args: []string{"bash", "-c", portCheckScript, "port-check"}}
+	for _, port := range ports {
+		sshOpts.Args = append(sshOpts.Args, strconv.Itoa(port))
+	}
+
+	if err := prepareSSH(ctx, opts, conf, inst, &sshOpts); err != nil {
+		return err
+	}
+	sshOpts.TTY = "false"
+
+	cmds, err := sshrunner.Command(inst, sshOpts)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	output, err := runner.Output(ctx, opts.runner, runner.Cmd{Name: cmds[0], Args: cmds[1:]})
+	if err != nil {
+		return fmt.Errorf("%w, %s", err, strings.TrimSpace(string(output)))
+	}
+
+	s := bufio.NewScanner(strings.NewReader(string(output)))
+	for s.Scan() {
+		port, state, ok := strings.Cut(s.Text(), " ")
+		if p, err := strconv.Atoi(port); err == nil && ok {
+			result[p] = state
+		}
+	}
+
+	return nil
+}
+
+// openOrClosed returns the port state of the check result, logging errors.
+func openOrClosed(open bool, err error) string {
+	if err != nil {
+		slog.Warn("Port check failed", "err", err)
+		return "error"
+	} else if open {
+		return "open"
+	}
+
+	return "closed"
+}
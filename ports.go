@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/corverroos/gssh/selector"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// listeningScript lists the listening TCP sockets with their processes, which
+// requires root for processes of other users, so sudo is tried first.
+const listeningScript = `sudo -n ss -Hltnp 2>/dev/null || ss -Hltnp`
+
+// listeningPort is a TCP port listening on the VM.
+type listeningPort struct {
+	addr    string
+	port    int
+	process string
+}
+
+// ssProcess matches the first process name of the ss -p output, e.g. users:(("jupyter",pid=1,fd=5)).
+var ssProcess = regexp.MustCompile(`\(\("([^"]+)"`)
+
+// runPorts lists the listening TCP ports of the selected VM with their process
+// names and forwards the port selected by the user to localhost.
+func runPorts(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	localPort := fs.Int("local-port", 0, "local port to forward to (default the remote port if available, else a free port)")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	selected, conf, err := selectVM(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	var sshOpts sshrunner.Options
+	if err := prepareSSH(ctx, *opts, conf, selected, &sshOpts); err != nil {
+		return err
+	}
+
+	output, err := remoteOutput(ctx, *opts, selected, sshOpts, listeningScript)
+	if err != nil {
+		return fmt.Errorf("list listening ports error: %w", err)
+	}
+
+	ports := parseListening(string(output))
+	if len(ports) == 0 {
+		return withExitCode(exitNoMatch, fmt.Errorf("no listening TCP ports on VM %s", selected.Name))
+	}
+
+	var items []string
+	for _, p := range ports {
+		items = append(items, fmt.Sprintf("%-8d%-30s%s", p.port, p.addr, p.process))
+	}
+	item, err := selector.SelectItem(ctx, "Select port to forward", items, "")
+	if err != nil {
+		return fmt.Errorf("select port error: %w", err)
+	}
+	port := ports[slices.Index(items, item)]
+
+	local := *localPort
+	if local == 0 {
+		if local, err = freeLocalPort(port.port); err != nil {
+			return err
+		}
+	}
+
+	// Ports listening on all interfaces or loopback are forwarded via localhost.
+	host := port.addr
+	switch host {
+	case "0.0.0.0", "*", "[::]", "127.0.0.1", "[::1]":
+		host = "localhost"
+	}
+
+	slog.Info("Forwarding port", "remote", net.JoinHostPort(strings.Trim(host, "[]"), strconv.Itoa(port.port)),
+		"process", port.process, "url", fmt.Sprintf("http://localhost:%d", local))
+
+	sshOpts.PortFwds = []string{fmt.Sprintf("%d:%s:%d", local, host, port.port)}
+	sshOpts.NoShell = true
+
+	return connectSelected(ctx, *opts, conf, selected, sshOpts)
+}
+
+// parseListening returns the unique listening ports of the ss -Hltnp output, sorted by port.
+func parseListening(output string) []listeningPort {
+	var ports []listeningPort
+	seen := make(map[int]bool)
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		// State Recv-Q Send-Q Local-Address:Port Peer-Address:Port [Process]
+		fields := strings.Fields(s.Text())
+		if len(fields) < 4 {
+			continue
+		}
+
+		i := strings.LastIndex(fields[3], ":")
+		if i < 0 {
+			continue
+		}
+		port, err := strconv.Atoi(fields[3][i+1:])
+		if err != nil || seen[port] {
+			continue
+		}
+		seen[port] = true
+
+		// Strip the interface of addresses like 127.0.0.53%lo or [fe80::1%eth0].
+		addr, _, zoned := strings.Cut(fields[3][:i], "%")
+		if zoned && strings.HasPrefix(addr, "[") {
+			addr += "]"
+		}
+		p := listeningPort{addr: addr, port: port}
+		if m := ssProcess.FindStringSubmatch(s.Text()); m != nil {
+			p.process = m[1]
+		}
+		ports = append(ports, p)
+	}
+
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].port < ports[j].port
+	})
+
+	return ports
+}
+
+// freeLocalPort returns the port if it is available locally, otherwise a free port.
+func freeLocalPort(port int) (int, error) {
+	l, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		if l, err = net.Listen("tcp", "localhost:0"); err != nil {
+			return 0, fmt.Errorf("find free local port error: %w", err)
+		}
+	}
+	defer l.Close()
+
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// progressInterval is the minimum interval between progress updates.
+const progressInterval = 250 * time.Millisecond
+
+// progress prints the number of listed and matching instances to stderr while they arrive.
+// Nothing is printed if info logs are disabled.
+type progress struct {
+	filter *regexp.Regexp
+
+	mu       sync.Mutex
+	listed   int
+	matching int
+	printed  time.Time
+	shown    bool
+	hidden   bool
+}
+
+// newProgress returns a progress that counts instances matching the filter.
+func newProgress(filter *regexp.Regexp) *progress {
+	return &progress{filter: filter, printed: time.Now()}
+}
+
+// Found records an arrived instance. It is safe for concurrent use.
+func (p *progress) Found(inst inventory.Instance) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.listed++
+	if p.filter.MatchString(inst.Name) {
+		p.matching++
+	}
+
+	if !p.hidden && time.Since(p.printed) >= progressInterval && slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		p.print()
+	}
+}
+
+// Hide clears the printed progress and stops printing it, e.g. since the selector is shown.
+func (p *progress) Hide() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.shown {
+		fmt.Fprint(os.Stderr, "\r\033[K")
+	}
+	p.shown, p.hidden = false, true
+}
+
+// Done prints the final counts if any progress was printed.
+func (p *progress) Done() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.shown {
+		return
+	}
+
+	p.print()
+	fmt.Fprint(os.Stderr, "\n")
+}
+
+func (p *progress) print() {
+	fmt.Fprintf(os.Stderr, "\rListing VMs: %d listed, %d matching", p.listed, p.matching)
+	p.printed = time.Now()
+	p.shown = true
+}
+
+// spinnerFrames are the frames of the spinner animation.
+var spinnerFrames = []string{"|", "/", "-", "\\"}
+
+// spin prints the message with a spinner to stderr until the returned function is called.
+// Nothing is printed if info logs are disabled.
+func spin(msg string) (stop func()) {
+	if !slog.Default().Enabled(context.Background(), slog.LevelInfo) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+
+		for i := 0; ; i++ {
+			fmt.Fprintf(os.Stderr, "\r%s %s", msg, spinnerFrames[i%len(spinnerFrames)])
+			select {
+			case <-done:
+				fmt.Fprintf(os.Stderr, "\r%s\n", msg)
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// forwardSignals are the signals forwarded to interactive commands.
+var forwardSignals = []os.Signal{syscall.SIGTERM, syscall.SIGHUP}
+
+// killProcessGroup starts the command in its own process group and kills the
+// whole group on cancellation so that no orphaned children are left behind.
+func killProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build windows
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+)
+
+// forwardSignals are the signals forwarded to interactive commands, windows only supports interrupts.
+var forwardSignals = []os.Signal{os.Interrupt}
+
+// killProcessGroup is a no-op on windows, the command's process is killed on cancellation.
+func killProcessGroup(*exec.Cmd) {}
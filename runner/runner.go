@@ -0,0 +1,156 @@
+// Package runner abstracts running external commands so that gcloud and ssh
+// invocations can be mocked in tests or swapped for alternative backends.
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LevelTrace is the log level of executed subprocesses, more verbose than slog.LevelDebug.
+const LevelTrace = slog.LevelDebug - 4
+
+// Cmd is an external command.
+type Cmd struct {
+	Name string
+	Args []string
+	// Env are additional environment variables in the form "key=value".
+	Env    []string
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	// Interactive commands are attached to the terminal, they share the gssh
+	// process group and are not killed when the context is cancelled.
+	Interactive bool
+	// Stop terminates interactive commands when closed, if not nil.
+	Stop <-chan struct{}
+}
+
+// Runner runs external commands.
+type Runner interface {
+	// Run runs the command and waits for it to complete.
+	Run(ctx context.Context, cmd Cmd) error
+}
+
+// Exec is a Runner that executes commands as local subprocesses.
+type Exec struct{}
+
+// Run runs the command as a local subprocess. Non-interactive subprocesses
+// and their children are killed when the context is done.
+func (Exec) Run(ctx context.Context, cmd Cmd) error {
+	var c *exec.Cmd
+	if cmd.Interactive {
+		c = exec.Command(cmd.Name, cmd.Args...)
+	} else {
+		c = exec.CommandContext(ctx, cmd.Name, cmd.Args...)
+		killProcessGroup(c)
+	}
+	// Don't wait for children still holding captured output open, e.g. ssh of a stopped gcloud.
+	c.WaitDelay = time.Second
+
+	if len(cmd.Env) > 0 {
+		c.Env = append(os.Environ(), cmd.Env...)
+	}
+	c.Stdin = cmd.Stdin
+	c.Stdout = cmd.Stdout
+	c.Stderr = cmd.Stderr
+
+	t0 := time.Now()
+	err := run(c, cmd.Interactive, cmd.Stop)
+	slog.Log(ctx, LevelTrace, "Executed", "cmd", strings.Join(append([]string{cmd.Name}, cmd.Args...), " "), "duration", time.Since(t0).Round(time.Millisecond), "err", err)
+
+	return err
+}
+
+// run runs the command. Termination signals received by gssh are forwarded to
+// interactive commands, interrupts are not since the terminal already sends them
+// to the whole foreground process group. Interactive commands are terminated
+// gracefully, allowing them to restore the terminal, when stop is closed.
+func run(c *exec.Cmd, interactive bool, stop <-chan struct{}) error {
+	if !interactive {
+		return c.Run()
+	}
+
+	if err := c.Start(); err != nil {
+		return err
+	}
+
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, forwardSignals...)
+	defer signal.Stop(sigc)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-sigc:
+				_ = c.Process.Signal(sig)
+			case <-stop:
+				if err := c.Process.Signal(forwardSignals[0]); err != nil {
+					_ = c.Process.Kill()
+				}
+				stop = nil
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return c.Wait()
+}
+
+var (
+	cleanupMu sync.Mutex
+	cleanups  []func()
+)
+
+// OnExit registers a function that cleans up resources like temp files or
+// background tunnels when Cleanup is called before gssh exits.
+func OnExit(fn func()) {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	cleanups = append(cleanups, fn)
+}
+
+// Cleanup calls the registered cleanup functions in reverse order.
+func Cleanup() {
+	cleanupMu.Lock()
+	defer cleanupMu.Unlock()
+
+	for i := len(cleanups) - 1; i >= 0; i-- {
+		cleanups[i]()
+	}
+	cleanups = nil
+}
+
+// Output runs the command and returns its combined output.
+func Output(ctx context.Context, r Runner, cmd Cmd) ([]byte, error) {
+	var b bytes.Buffer
+	cmd.Stdout = &b
+	cmd.Stderr = &b
+	err := r.Run(ctx, cmd)
+
+	return b.Bytes(), err
+}
+
+// Terminal runs the command interactively attached to the current terminal.
+func Terminal(ctx context.Context, r Runner, name string, args ...string) error {
+	return r.Run(ctx, Cmd{
+		Name:        name,
+		Args:        args,
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		Interactive: true,
+	})
+}
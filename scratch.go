@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/sshrunner"
+	"github.com/manifoldco/promptui"
+)
+
+// scratchLabel is the label of VMs created by gssh scratch.
+const scratchLabel = "gssh-scratch"
+
+// runScratch creates a short-lived VM from the configured template, connects
+// to it and deletes it when the session ends.
+func runScratch(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	tmpl := conf.Scratch
+	name := fs.String("name", scratchName(), "name of the scratch VM")
+	project := fs.String("project", "", "project of the scratch VM (defaults to the gcloud config project)")
+	fs.StringVar(&tmpl.MachineType, "machine-type", withDefault(tmpl.MachineType, "e2-standard-2"), "machine type of the scratch VM")
+	fs.StringVar(&tmpl.ImageFamily, "image-family", withDefault(tmpl.ImageFamily, "debian-12"), "image family of the scratch VM")
+	fs.StringVar(&tmpl.ImageProject, "image-project", withDefault(tmpl.ImageProject, "debian-cloud"), "project of the image family")
+	fs.StringVar(&tmpl.Zone, "zone", withDefault(tmpl.Zone, "us-central1-a"), "zone of the scratch VM")
+	keep := fs.Bool("keep", false, "don't delete the scratch VM when the session ends")
+	u := fs.String("u", os.Getenv("GSSH_USER"), "ssh username (overrides $GSSH_USER env var)")
+	timeout := fs.Duration("gcloud-timeout", 2*time.Minute, "max duration of each gcloud invocation (excluding the ssh session)")
+	addGcloudFlags(fs)
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	gc := inventory.Gcloud{Timeout: *timeout, Runner: runner.Exec{}}
+
+	if *project == "" {
+		p, ok := inventory.ActiveProject()
+		if !ok {
+			if p, err = gc.ConfigGet(ctx, "project"); err != nil {
+				return gcloudErr(err)
+			}
+		}
+		if p == "" {
+			return errors.New("no gcloud config project, specify one with -project")
+		}
+		*project = p
+	}
+
+	inst := inventory.Instance{Name: *name, Zone: tmpl.Zone, Project: *project}
+	slog.Info("Creating scratch VM", "name", inst.Name, "zone", inst.Zone, "project", inst.Project,
+		"machine_type", tmpl.MachineType, "image", tmpl.ImageProject+"/"+tmpl.ImageFamily)
+
+	stop := spin(fmt.Sprintf("Waiting for VM %s to be created", inst.Name))
+	err = gc.InstanceOp(ctx, "create", inst,
+		"--machine-type="+tmpl.MachineType,
+		"--image-family="+tmpl.ImageFamily,
+		"--image-project="+tmpl.ImageProject,
+		"--labels="+scratchLabel+"=true")
+	stop()
+	if err != nil {
+		return gcloudErr(err)
+	}
+
+	if !*keep {
+		defer deleteScratch(ctx, gc, inst)
+	}
+
+	inst, err = waitSSH(ctx, gc, inst, "22", false, sshWaitTimeout)
+	if err != nil {
+		return err
+	}
+
+	cmds, err := sshrunner.Command(inst, sshrunner.Options{User: *u})
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Executing", "cmd", sshrunner.Quote(cmds))
+
+	return sshrunner.Run(ctx, runner.Exec{}, cmds)
+}
+
+// deleteScratch deletes the scratch VM after confirming in a terminal, even if
+// the session was aborted.
+func deleteScratch(ctx context.Context, gc inventory.Gcloud, inst inventory.Instance) {
+	ctx = context.WithoutCancel(ctx)
+
+	if readline.IsTerminal(int(os.Stdin.Fd())) {
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("Delete scratch VM %s", inst.Name),
+			IsConfirm: true,
+			Default:   "y",
+		}
+		if _, err := prompt.Run(); errors.Is(err, promptui.ErrAbort) {
+			slog.Info("Keeping scratch VM", "name", inst.Name)
+			return
+		}
+	}
+
+	stop := spin(fmt.Sprintf("Waiting for VM %s to be deleted", inst.Name))
+	err := gc.InstanceOp(ctx, "delete", inst, "--quiet")
+	stop()
+	if err != nil {
+		slog.Error("Failed to delete scratch VM", "name", inst.Name, "err", err)
+	}
+}
+
+// scratchName returns the default name of a scratch VM.
+func scratchName() string {
+	name := "user"
+	if u, err := user.Current(); err == nil {
+		name = strings.ToLower(u.Username)
+	}
+
+	// VM names must be lowercase letters, digits and dashes.
+	name = strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '-'
+	}, name)
+
+	return fmt.Sprintf("scratch-%s-%s", name, time.Now().Format("0102-150405"))
+}
+
+// withDefault returns the value or the default if empty.
+func withDefault(value, def string) string {
+	if value == "" {
+		return def
+	}
+
+	return value
+}
@@ -0,0 +1,839 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/selector"
+	"github.com/corverroos/gssh/sshrunner"
+	"github.com/manifoldco/promptui"
+)
+
+// options are the VM selection options shared by subcommands.
+type options struct {
+	host          string
+	filter        string
+	user          string
+	userSet       bool
+	usePrev       bool
+	check         bool
+	latency       bool
+	ticket        string
+	start         bool
+	noStart       bool
+	exitOp        string
+	wait          time.Duration
+	container     string
+	pickContainer bool
+	open          string
+	recentLogs    int
+	health        bool
+	preflight     bool
+	hints         bool
+	native        bool
+	sshFlags      []string
+	tty           string
+	forwardAgent  bool
+	forwardX11    string
+	keepAlive     *time.Duration
+	reconnect     bool
+	tmux          string
+	sudo          string
+	printCommand  bool
+	copy          bool
+	debugSSH      bool
+	idleTimeout   time.Duration
+	selectOnly    string
+	multiplex     bool
+	port          int
+	address       string
+	identity      string
+	sendEnv       []string
+	osLogin       string
+	checkTimeout  time.Duration
+	cacheTTL      time.Duration
+	refresh       bool
+	api           bool
+	projects      []string
+	allProjects   bool
+	searchAll     bool
+	scope         string
+	gke           bool
+	cost          bool
+	mig           string
+	pickMIG       bool
+	offline       bool
+	timeout       time.Duration
+	runner        runner.Runner
+	timing        *timing
+	stream        *listStream
+}
+
+// addListFlags registers the VM listing and filtering flags and returns the options they populate.
+func addListFlags(fs *flag.FlagSet) *options {
+	opts := options{runner: runner.Exec{}, cacheTTL: -1}
+	fs.StringVar(&opts.filter, "f", "", "regex filter VMs by name")
+	fs.StringVar(&opts.host, "h", "", "specific VM host name (alias for -f '^host$')")
+	fs.Func("cache-ttl", "max age of the cached VM list before it is refetched (default 1m or the cache_ttl config)", func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		opts.cacheTTL = d
+		return nil
+	})
+	fs.BoolVar(&opts.refresh, "refresh", false, "ignore the cached VM list and refetch it")
+	fs.BoolVar(&opts.api, "api", false, "list VMs via the Compute Engine API using Application Default Credentials instead of gcloud")
+	setProjects := func(s string) error {
+		opts.projects = strings.Split(s, ",")
+		return nil
+	}
+	fs.Func("P", "comma separated list of projects to list VMs from and connect to (defaults to the gcloud config project)", setProjects)
+	fs.Func("project", "alias for -P", setProjects)
+	fs.BoolVar(&opts.allProjects, "all-projects", false, "list VMs from all projects configured in ~/.gssh.json")
+	fs.Func("org", "list VMs of all projects under the organization ID via Cloud Asset Inventory", func(s string) error {
+		opts.scope = "organizations/" + s
+		return nil
+	})
+	fs.Func("folder", "list VMs of all projects under the folder ID via Cloud Asset Inventory", func(s string) error {
+		opts.scope = "folders/" + s
+		return nil
+	})
+	fs.BoolVar(&opts.searchAll, "search-projects", false, "if -h matches no VM, search the projects configured in ~/.gssh.json without prompting")
+	fs.BoolVar(&opts.gke, "gke", false, "only list GKE nodes, showing their cluster and node pool")
+	fs.BoolVar(&opts.cost, "cost", false, "show the approximate hourly on-demand cost of each VM (excluding disks, GPUs and discounts)")
+	fs.StringVar(&opts.mig, "mig", "", "only list the current members of the managed instance group")
+	fs.BoolVar(&opts.pickMIG, "pick-mig", false, "select one of the managed instance groups and only list its current members")
+	fs.BoolVar(&opts.offline, "offline", false, "use the last cached VM list regardless of its age and connect with plain ssh to the VM's IP")
+	fs.DurationVar(&opts.timeout, "gcloud-timeout", time.Minute, "max duration of each gcloud invocation (excluding the ssh session)")
+	addGcloudFlags(fs)
+	fs.BoolFunc("timing", "print a phase-by-phase latency breakdown", func(string) error {
+		opts.timing = newTiming()
+		return nil
+	})
+
+	return &opts
+}
+
+// addGcloudFlags registers the flags selecting the gcloud binary, named gcloud
+// configuration and the impersonated service account used by all gcloud
+// invocations, including the ssh session, and the Compute Engine API.
+func addGcloudFlags(fs *flag.FlagSet) {
+	fs.Func("configuration", "named gcloud configuration to use (defaults to the active configuration)", func(s string) error {
+		return os.Setenv("CLOUDSDK_ACTIVE_CONFIG_NAME", s)
+	})
+	fs.Func("gcloud-bin", "path of the gcloud binary to use, its version is validated", func(s string) error {
+		inventory.GcloudBin = s
+
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+
+		version, err := inventory.Gcloud{Timeout: 30 * time.Second}.Version(ctx)
+		if err != nil {
+			return err
+		}
+		slog.Debug("Using gcloud", "bin", s, "version", version)
+
+		return nil
+	})
+	fs.Func("account", "gcloud account to use for this invocation, e.g. an admin identity (the -api backend uses the application default credentials instead)", func(s string) error {
+		return os.Setenv("CLOUDSDK_CORE_ACCOUNT", s)
+	})
+	fs.Func("impersonate-service-account", "service account (or comma separated delegation chain) to impersonate", func(s string) error {
+		return os.Setenv("CLOUDSDK_AUTH_IMPERSONATE_SERVICE_ACCOUNT", s)
+	})
+}
+
+// addSelectFlags registers the listing flags and the VM selection and ssh user flags.
+func addSelectFlags(fs *flag.FlagSet) *options {
+	opts := addListFlags(fs)
+	fs.BoolVar(&opts.usePrev, "p", false, "use previously selected VM (if any) as filter")
+	fs.BoolVar(&opts.check, "check", false, "probe the ssh port of matching VMs concurrently and mark unreachable ones in the selector")
+	fs.DurationVar(&opts.checkTimeout, "check-timeout", 2*time.Second, "max duration of each -check or -latency probe")
+	fs.BoolVar(&opts.latency, "latency", false, "measure the TCP connect time to the ssh port of matching VMs concurrently and show it in the selector, e.g. to pick the closest replica")
+	fs.StringVar(&opts.ticket, "ticket", "", "change ticket ID required by a ticket policy of the VM, instead of prompting for it")
+	fs.Func("u", "ssh username (overrides $GSSH_USER env var and the user config)", func(s string) error {
+		opts.user, opts.userSet = s, true
+		return nil
+	})
+
+	fs.DurationVar(&opts.wait, "wait", 0, "wait up to this duration for a matching VM to exist and accept ssh connections, e.g. after creating it")
+	fs.Func("ssh-flag", "flag passed to ssh itself, e.g. '-o ConnectTimeout=5', may be repeated", func(s string) error {
+		if _, err := sshrunner.SplitWords(s); err != nil {
+			return err
+		}
+		opts.sshFlags = append(opts.sshFlags, s)
+		return nil
+	})
+	fs.Func("keepalive", "interval of ssh keepalive messages, 0 to disable (default 30s or the keepalive config)", func(s string) error {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		opts.keepAlive = &d
+		return nil
+	})
+	fs.IntVar(&opts.port, "port", 0, "ssh port of the VM (default 22, the ports.<vm> config or the gssh-port metadata with -hints)")
+	for addr, usage := range map[string]string{
+		"internal": "connect to the internal IP of the VM, e.g. via a VPN",
+		"external": "connect to the external IP of the VM",
+		"auto":     "connect to the internal IP of the VM if reachable, otherwise the external IP or IAP",
+	} {
+		addr := addr
+		fs.BoolFunc(addr+"-ip", usage, func(string) error {
+			opts.address = addr
+			return nil
+		})
+	}
+	fs.StringVar(&opts.identity, "i", "", "ssh private key file (default the identities.<gcloud configuration> config or the gcloud generated key). Equivalent to 'ssh -i'")
+	fs.BoolVar(&opts.multiplex, "multiplex", false, "share one ssh connection per VM between invocations via a ControlMaster socket, kept open for 10m")
+	fs.Func("send-env", "send the local environment variable to the VM, may contain wildcards like 'LC_*' or be NAME=value, may be repeated (requires AcceptEnv in sshd_config)", func(s string) error {
+		opts.sendEnv = append(opts.sendEnv, s)
+		return nil
+	})
+	fs.BoolVar(&opts.printCommand, "print-command", false, "print the gcloud or ssh command instead of running it")
+	fs.BoolVar(&opts.printCommand, "dry-run", false, "alias of -print-command")
+	fs.BoolVar(&opts.copy, "copy", false, "copy the -print-command (or -select-only) output to the clipboard instead of printing it, via OSC 52 in ssh sessions")
+	fs.BoolVar(&opts.native, "native", false, "connect with the system ssh to the VM's IP (via an IAP tunnel if it has no external IP) instead of gcloud compute ssh")
+	fs.BoolVar(&opts.hints, "hints", false, "apply the gssh-user, gssh-port and gssh-init metadata of the VM as defaults")
+	fs.BoolVar(&opts.preflight, "preflight", false, "check the required IAM permissions on the VM before connecting")
+	fs.BoolVar(&opts.start, "start", false, "start a stopped (or resume a suspended) VM without prompting")
+	fs.BoolFunc("os-login", "assume OS Login is enabled, skipping detection (plain ssh backend only)", func(string) error {
+		opts.osLogin = "true"
+		return nil
+	})
+	fs.BoolFunc("no-os-login", "assume metadata ssh keys are used, skipping OS Login detection (plain ssh backend only)", func(string) error {
+		opts.osLogin = "false"
+		return nil
+	})
+
+	if u, ok := os.LookupEnv("GSSH_USER"); ok {
+		opts.user, opts.userSet = u, true
+	}
+
+	return opts
+}
+
+// sshPort returns the ssh port probed by -check and -wait.
+func (o options) sshPort() string {
+	if o.port != 0 {
+		return strconv.Itoa(o.port)
+	}
+
+	return "22"
+}
+
+// gcloud returns the gcloud runner configured by the options.
+func (o options) gcloud() inventory.Gcloud {
+	return inventory.Gcloud{Timeout: o.timeout, Runner: o.runner}
+}
+
+// listing is the result of listing and filtering VMs.
+type listing struct {
+	conf      config.Config
+	projects  []string
+	filter    string
+	cacheAge  string
+	instances []inventory.Instance
+}
+
+// logGcloudEnv logs the CLOUDSDK_* environment, e.g. proxy, region or project
+// overrides, which gcloud subprocesses inherit.
+var logGcloudEnv sync.Once
+
+// listVMs returns the sorted VMs matching the options. If listing fails due to
+// missing or expired credentials, it offers to re-authenticate and retries once.
+func listVMs(ctx context.Context, opts options) (listing, error) {
+	logGcloudEnv.Do(func() {
+		var env []any
+		for _, kv := range os.Environ() {
+			if k, v, _ := strings.Cut(kv, "="); strings.HasPrefix(k, "CLOUDSDK_") {
+				if strings.Contains(k, "PASSWORD") {
+					v = "***"
+				}
+				env = append(env, k, v)
+			}
+		}
+		slog.Debug("Using gcloud environment", env...)
+	})
+
+	if err := checkGcloud(ctx, opts); err != nil {
+		return listing{}, err
+	}
+
+	l, err := listOnce(ctx, opts)
+	if err == nil || !inventory.IsAuthError(err) {
+		return l, err
+	}
+
+	if err := reauth(ctx, opts, err); err != nil {
+		return listing{}, err
+	}
+
+	return listOnce(ctx, opts)
+}
+
+// reauth prompts the user to run the gcloud command refreshing the credentials
+// that caused the auth error. It returns a concise error including the command
+// if not running in a terminal or if the user declines.
+func reauth(ctx context.Context, opts options, authErr error) error {
+	cmd := inventory.ReauthCommand(authErr)
+	slog.Debug("Listing failed due to credentials", "err", authErr)
+
+	concise := withExitCode(exitAuth, fmt.Errorf("credentials missing or expired, run `%s`", strings.Join(cmd, " ")))
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return concise
+	}
+
+	prompt := promptui.Prompt{
+		Label:     fmt.Sprintf("Credentials missing or expired, run `%s` now", strings.Join(cmd, " ")),
+		IsConfirm: true,
+	}
+	if _, err := prompt.Run(); err != nil {
+		return concise
+	}
+
+	if err := runner.Terminal(ctx, opts.runner, cmd[0], cmd[1:]...); err != nil {
+		return withExitCode(exitAuth, fmt.Errorf("%s error: %w", strings.Join(cmd, " "), err))
+	}
+
+	return nil
+}
+
+// scopeCacheTTL is the min max age of cached organization or folder VM lists.
+const scopeCacheTTL = 15 * time.Minute
+
+// projectsOf returns the sorted unique projects of the instances.
+func projectsOf(instances []inventory.Instance) []string {
+	seen := make(map[string]bool)
+	var projects []string
+	for _, inst := range instances {
+		if !seen[inst.Project] {
+			seen[inst.Project] = true
+			projects = append(projects, inst.Project)
+		}
+	}
+	sort.Strings(projects)
+
+	return projects
+}
+
+// listOnce returns the sorted VMs matching the options.
+func listOnce(ctx context.Context, opts options) (listing, error) {
+	hostname, filter := opts.host, opts.filter
+	if hostname != "" && filter != "" {
+		return listing{}, fmt.Errorf("cannot use both -h and -f flags")
+	} else if hostname != "" {
+		filter = fmt.Sprintf("^%s$", hostname)
+	}
+
+	filterExp, err := regexp.Compile(filter)
+	if err != nil {
+		return listing{}, fmt.Errorf("invalid filter regex: %w", err)
+	}
+
+	t := opts.timing
+
+	conf, err := config.Load()
+	if err != nil {
+		// Fail closed, ignoring a broken config would skip its policies and
+		// storing the history would overwrite the user's settings.
+		return listing{}, fmt.Errorf("%w, fix or remove ~/%s", err, config.File)
+	}
+	prev := conf.Previous
+	if opts.cacheTTL < 0 {
+		opts.cacheTTL = time.Minute
+		if conf.CacheTTL != "" {
+			opts.cacheTTL, _ = time.ParseDuration(conf.CacheTTL) // Validated by config set.
+		}
+	}
+	t.Phase("config")
+
+	var (
+		gc        = opts.gcloud()
+		projects  = opts.projects
+		instances []inventory.Instance
+		cacheAge  = "none"
+		age       time.Duration
+	)
+	if opts.allProjects {
+		if len(conf.Projects) == 0 {
+			return listing{}, fmt.Errorf("no projects configured in %s", config.File)
+		}
+		projects = conf.Projects
+	}
+
+	if inst, ok := historyHost(opts, conf, projects); ok {
+		// Reconnecting to a known VM doesn't require listing, it is verified if connecting fails.
+		slog.Debug("Using zone of VM from history", "zone", inst.TrimZone(), "project", inst.Project)
+		projects, instances, cacheAge = []string{inst.Project}, []inventory.Instance{inst}, "history"
+		fromHistory = true
+	} else if opts.usePrev {
+		// No need to lookup the project or list VMs.
+		if prev.Project != "" {
+			projects = []string{prev.Project}
+		} else if len(projects) == 1 {
+			prev.Project = projects[0]
+		}
+		instances = []inventory.Instance{prev}
+	} else {
+		// List the hosts of other providers concurrently with the VMs.
+		hostsc := make(chan []inventory.Instance, 1)
+		go func() {
+			hostsc <- inventory.ListProviders(ctx, providers(opts, conf))
+		}()
+
+		prog := newProgress(filterExp)
+		found := prog.Found
+		if opts.stream != nil {
+			found = opts.stream.listing(prog)
+		}
+		l := inventory.Lister{
+			Fetch:   inventory.NewFetcher(gc, opts.api || conf.ListBackend == "api" || noGcloud),
+			TTL:     opts.cacheTTL,
+			Refresh: opts.refresh,
+			Offline: opts.offline,
+		}
+
+		if len(projects) == 0 && opts.scope == "" {
+			if project, ok := inventory.ActiveProject(); ok {
+				projects = []string{project}
+				t.Phase("project")
+			}
+		}
+		if len(projects) == 0 && opts.scope == "" && noGcloud {
+			if projects, err = projectsWithoutGcloud(conf); err != nil {
+				return listing{}, err
+			}
+		}
+
+		switch {
+		case opts.scope != "":
+			// Listing all projects under the scope is slow, so cache it for longer.
+			l.Fetch, l.TTL = inventory.NewAssetFetcher(gc), max(opts.cacheTTL, scopeCacheTTL)
+			instances, age, err = l.List(ctx, opts.scope, found)
+			projects = projectsOf(instances)
+		case len(projects) > 0:
+			instances, age, err = l.ListProjects(ctx, projects, found)
+		default:
+			// Lookup the project concurrently with listing its VMs.
+			var project string
+			project, instances, age, err = l.ListDefault(ctx, gc, found)
+			if errors.Is(err, inventory.ErrNoProject) {
+				if project, err = selectProject(ctx, gc, &conf, opts.offline); err == nil {
+					instances, age, err = l.ListProjects(ctx, []string{project}, found)
+				}
+			}
+			if err != nil && opts.offline && prev.Project != "" {
+				slog.Warn("Using project of previous VM", "err", err)
+				project = prev.Project
+				instances, age, err = l.ListProjects(ctx, []string{project}, found)
+			}
+			projects = []string{project}
+		}
+		prog.Done()
+		if err != nil {
+			return listing{}, gcloudErr(err)
+		}
+
+		if age > 0 {
+			cacheAge = age.Truncate(time.Second).String()
+		}
+
+		instances = inventory.Sort(append(instances, <-hostsc...))
+		t.Phase("list")
+	}
+
+	instances = inventory.Filter(instances, filterExp)
+	if opts.gke {
+		instances = inventory.GKENodes(instances)
+	}
+	if (opts.mig != "" || opts.pickMIG) && !opts.usePrev {
+		if instances, err = migMembers(ctx, opts, projects, instances); err != nil {
+			return listing{}, err
+		}
+	}
+
+	return listing{
+		conf:      conf,
+		projects:  projects,
+		filter:    filter,
+		cacheAge:  cacheAge,
+		instances: instances,
+	}, nil
+}
+
+// fromHistory is true if the VM selected by -h was taken from the history
+// without listing VMs.
+var fromHistory bool
+
+// historyHost returns the most recently selected VM named by -h from the
+// history in the searched projects, skipping the listing. It returns false if
+// the VM isn't in the history, if VMs with that name were selected in different
+// zones or projects, or if the options require an up to date listing.
+func historyHost(opts options, conf config.Config, projects []string) (inventory.Instance, bool) {
+	if opts.host == "" || opts.usePrev || opts.refresh || opts.wait > 0 || opts.start || opts.scope != "" ||
+		opts.gke || opts.mig != "" || opts.pickMIG {
+		return inventory.Instance{}, false
+	}
+
+	if len(projects) == 0 {
+		// Only consider the projects that listing and -search-projects would search.
+		projects = conf.Projects
+		if project, ok := inventory.ActiveProject(); ok {
+			projects = append([]string{project}, projects...)
+		}
+	}
+
+	var found inventory.Instance
+	for i := len(conf.History) - 1; i >= 0; i-- {
+		inst := conf.History[i].Instance
+		if inst.Name != opts.host || !inst.GCE() || inst.Project == "" {
+			continue
+		} else if !slices.Contains(projects, inst.Project) {
+			continue
+		} else if found.Name != "" && (found.Zone != inst.Zone || found.Project != inst.Project) {
+			return inventory.Instance{}, false
+		} else if found.Name == "" {
+			found = inst
+		}
+	}
+	if found.Name == "" {
+		return inventory.Instance{}, false
+	}
+
+	// The status may be outdated, assume it is running instead of offering to start it.
+	found.Status = ""
+
+	return found, true
+}
+
+// verifyHistoryVM returns a descriptive error if the VM taken from the history
+// no longer exists in its zone or isn't running, e.g. if connecting failed.
+func verifyHistoryVM(ctx context.Context, opts options, inst inventory.Instance) error {
+	current, err := opts.gcloud().Describe(ctx, inst)
+	if err != nil {
+		return fmt.Errorf("VM %s not found in zone %s of project %s, it may have been deleted or moved, retry with -refresh: %w",
+			inst.Name, inst.TrimZone(), inst.Project, gcloudErr(err))
+	} else if current.Status != "RUNNING" {
+		return fmt.Errorf("VM %s is %s, retry with -start", inst.Name, current.Status)
+	}
+
+	return nil
+}
+
+// providers returns the configured providers of the hosts listed alongside the VMs.
+func providers(opts options, conf config.Config) []inventory.Provider {
+	var ps []inventory.Provider
+	if len(conf.SSHHosts) > 0 {
+		ps = append(ps, inventory.SSHConfig{Files: conf.SSHHosts})
+	}
+	if len(conf.StaticInventory) > 0 {
+		ps = append(ps, inventory.Static{Files: conf.StaticInventory})
+	}
+	if len(conf.EC2Regions) > 0 {
+		if _, ok := os.LookupEnv("AWS_PROFILE"); !ok && conf.EC2Profile != "" {
+			// Also used by the aws ssm sessions.
+			_ = os.Setenv("AWS_PROFILE", conf.EC2Profile)
+		}
+		ps = append(ps, inventory.EC2{
+			Regions: conf.EC2Regions,
+			Lister:  inventory.Lister{TTL: opts.cacheTTL, Refresh: opts.refresh, Offline: opts.offline},
+			Timeout: opts.timeout,
+			Runner:  opts.runner,
+		})
+	}
+
+	if len(conf.AzureSubscriptions) > 0 {
+		ps = append(ps, inventory.Azure{
+			Subscriptions: conf.AzureSubscriptions,
+			Lister:        inventory.Lister{TTL: opts.cacheTTL, Refresh: opts.refresh, Offline: opts.offline},
+			Timeout:       opts.timeout,
+			Runner:        opts.runner,
+		})
+	}
+
+	if conf.Tailscale {
+		ps = append(ps, inventory.Tailscale{Timeout: opts.timeout, Runner: opts.runner})
+	}
+
+	for _, plugin := range conf.Plugins {
+		ps = append(ps, inventory.Plugin{
+			Path:    plugin,
+			Lister:  inventory.Lister{TTL: opts.cacheTTL, Refresh: opts.refresh, Offline: opts.offline},
+			Timeout: opts.timeout,
+			Runner:  opts.runner,
+		})
+	}
+
+	return ps
+}
+
+// migMembers returns the instances that are current members of the managed
+// instance group named by -mig or selected via -pick-mig.
+func migMembers(ctx context.Context, opts options, projects []string, instances []inventory.Instance) ([]inventory.Instance, error) {
+	gc := opts.gcloud()
+
+	var migs []inventory.MIG
+	for _, project := range projects {
+		ms, err := gc.MIGs(ctx, project)
+		if err != nil {
+			return nil, gcloudErr(err)
+		}
+		for _, m := range ms {
+			if opts.mig == "" || m.Name == opts.mig {
+				migs = append(migs, m)
+			}
+		}
+	}
+
+	if len(migs) == 0 {
+		msg := "no managed instance groups found"
+		if opts.mig != "" {
+			msg += fmt.Sprintf(" named %q", opts.mig)
+		}
+		return nil, withExitCode(exitNoMatch, errors.New(msg))
+	}
+
+	mig := migs[0]
+	if len(migs) > 1 {
+		var items []string
+		for _, m := range migs {
+			items = append(items, fmt.Sprintf("%-40s%-30s%s", m.Name, m.Location(), m.Project))
+		}
+		item, err := selector.SelectItem(ctx, "Select managed instance group", items, "")
+		if err != nil {
+			return nil, fmt.Errorf("select managed instance group error: %w", err)
+		}
+		for i := range items {
+			if items[i] == item {
+				mig = migs[i]
+			}
+		}
+	}
+
+	names, err := gc.MIGInstances(ctx, mig)
+	if err != nil {
+		return nil, gcloudErr(err)
+	}
+
+	members := make(map[string]bool)
+	for _, name := range names {
+		members[name] = true
+	}
+
+	var filtered []inventory.Instance
+	for _, inst := range instances {
+		if members[inst.Name] && (inst.Project == "" || inst.Project == mig.Project) {
+			filtered = append(filtered, inst)
+		}
+	}
+
+	if len(filtered) < len(names) && !opts.refresh && opts.filter == "" && opts.host == "" {
+		slog.Warn("Some managed instance group members are not in the VM list, use -refresh to refetch it", "mig", mig.Name, "members", len(names), "listed", len(filtered))
+	}
+
+	return filtered, nil
+}
+
+// searchProjects lists the VMs matching -h in the configured projects not yet
+// searched, after prompting the user unless -search-projects was specified.
+// It returns false if there are no other projects or the user declined.
+func searchProjects(ctx context.Context, opts options, l listing) (listing, bool, error) {
+	searched := make(map[string]bool)
+	for _, p := range l.projects {
+		searched[p] = true
+	}
+
+	var others []string
+	for _, p := range l.conf.Projects {
+		if !searched[p] {
+			others = append(others, p)
+		}
+	}
+	if len(others) == 0 || opts.usePrev {
+		return listing{}, false, nil
+	}
+
+	if !opts.searchAll {
+		if !readline.IsTerminal(int(os.Stdin.Fd())) {
+			return listing{}, false, nil
+		}
+
+		prompt := promptui.Prompt{
+			Label:     fmt.Sprintf("No VM %q in %s, search the %d other configured projects", opts.host, strings.Join(l.projects, ","), len(others)),
+			IsConfirm: true,
+		}
+		if _, err := prompt.Run(); err != nil {
+			return listing{}, false, nil
+		}
+	}
+
+	opts.projects, opts.allProjects = others, false
+	other, err := listVMs(ctx, opts)
+	if err != nil {
+		return listing{}, false, err
+	}
+
+	return other, true, nil
+}
+
+// selectProject returns the project remembered for the active gcloud configuration
+// or prompts the user to select one of the accessible projects and remembers it.
+// It returns inventory.ErrNoProject if offline and no project is remembered.
+func selectProject(ctx context.Context, gc inventory.Gcloud, conf *config.Config, offline bool) (string, error) {
+	name := inventory.ActiveConfig()
+	if p := conf.DefaultProjects[name]; p != "" {
+		return p, nil
+	} else if offline {
+		return "", inventory.ErrNoProject
+	}
+
+	projects, err := gc.Projects(ctx)
+	if err != nil {
+		return "", err
+	} else if len(projects) == 0 {
+		return "", errors.New("no gcloud config project and no accessible projects")
+	}
+
+	slog.Info("No project in gcloud configuration, select one to remember", "configuration", name)
+
+	project, err := selector.SelectItem(ctx, "Select project", projects, "")
+	if err != nil {
+		return "", fmt.Errorf("select project error: %w", err)
+	}
+
+	if conf.DefaultProjects == nil {
+		conf.DefaultProjects = make(map[string]string)
+	}
+	conf.DefaultProjects[name] = project
+	if err := config.Store(*conf); err != nil {
+		slog.Debug("Failed to store config", "err", err)
+	}
+
+	return project, nil
+}
+
+// selectVM lists the VMs matching the options and returns the only match or
+// prompts the user to select one. The selection is stored as the previous VM
+// and in the history. It also returns the config.
+// Extra key-value pairs are included in the header log line.
+func selectVM(ctx context.Context, opts options, extra ...any) (inventory.Instance, config.Config, error) {
+	deadline := time.Now().Add(opts.wait)
+
+	var (
+		l        listing
+		selected inventory.Instance
+		chosen   bool
+		err      error
+	)
+	switch {
+	case opts.wait > 0:
+		l, err = waitVMs(ctx, opts, deadline)
+	case streamSelect(opts):
+		l, selected, chosen, err = listAndSelect(ctx, opts)
+	default:
+		l, err = listVMs(ctx, opts)
+	}
+	if err != nil {
+		return inventory.Instance{}, config.Config{}, err
+	}
+
+	slog.Info("Using", append([]any{"project", strings.Join(l.projects, ","), "user", opts.user, "filter", l.filter, "prev", opts.usePrev, "cache", l.cacheAge, "offline", opts.offline}, extra...)...)
+
+	if !chosen {
+		var crossProject bool
+		if len(l.instances) == 0 && opts.host != "" {
+			if other, ok, err := searchProjects(ctx, opts, l); err != nil {
+				return inventory.Instance{}, config.Config{}, err
+			} else if ok {
+				l, crossProject = other, true
+			}
+		}
+
+		instances := l.instances
+		if len(instances) == 0 {
+			msg := "no VMs found"
+			if l.filter != "" {
+				msg += fmt.Sprintf(" for filter '%s'", l.filter)
+			}
+			return inventory.Instance{}, config.Config{}, withExitCode(exitNoMatch, errors.New(msg))
+		}
+
+		selected = instances[0]
+		if len(instances) > 1 {
+			if opts.host != "" && !crossProject {
+				return inventory.Instance{}, config.Config{}, withExitCode(exitMultiple, fmt.Errorf("multiple VMs found for hostname %q", opts.host))
+			}
+
+			sopts := selector.Options{Previous: l.conf.Previous, ShowProject: len(l.projects) > 1, ShowCost: opts.cost, Keys: selectorKeys(opts)}
+			if opts.latency {
+				sopts.Latency = inventory.Latency(ctx, instances, opts.sshPort(), opts.checkTimeout)
+				opts.timing.Phase("latency")
+			} else if opts.check {
+				var gc *inventory.Gcloud
+				if !opts.offline && !noGcloud {
+					g := opts.gcloud()
+					gc = &g
+				}
+				sopts.Reachable = inventory.Reachable(ctx, gc, instances, opts.sshPort(), opts.checkTimeout)
+				opts.timing.Phase("check")
+			}
+
+			selected, err = selector.Select(ctx, instances, sopts)
+			if err != nil {
+				return inventory.Instance{}, config.Config{}, fmt.Errorf("select instance error: %w", err)
+			}
+			opts.timing.Phase("select")
+		}
+	}
+
+	slog.Info("Selected VM", "name", selected.Name, "zone", selected.Location(), "project", selected.Project)
+
+	if opts.selectOnly == "" {
+		if err := enforcePolicy(opts, l.conf, selected); err != nil {
+			return inventory.Instance{}, config.Config{}, err
+		}
+	}
+
+	// Dry runs only print the command, so don't start the VM.
+	if !opts.noStart && !opts.printCommand && selected.GCE() {
+		selected, err = ensureRunning(ctx, opts, selected, l.conf.IAP)
+		if err != nil {
+			return inventory.Instance{}, config.Config{}, err
+		}
+	}
+
+	if opts.wait > 0 && !opts.offline && selected.GCE() {
+		selected, err = waitSSH(ctx, opts.gcloud(), selected, opts.sshPort(), l.conf.IAP, time.Until(deadline))
+		if err != nil {
+			return inventory.Instance{}, config.Config{}, err
+		}
+	}
+
+	conf := l.conf
+	conf.Previous = selected
+	conf.AddHistory(config.HistoryEntry{Time: time.Now(), Instance: selected, User: opts.user})
+	if err = config.Store(conf); err != nil {
+		slog.Debug("Failed to store config", "err", err)
+	}
+	opts.timing.Phase("store")
+	opts.timing.Print()
+
+	return selected, conf, nil
+}
@@ -0,0 +1,6 @@
+//go:build !windows
+
+package selector
+
+// EnableVT is a no-op, unix terminals interpret ANSI escape sequences.
+func EnableVT() {}
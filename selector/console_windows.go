@@ -0,0 +1,30 @@
+//go:build windows
+
+package selector
+
+import (
+	"os"
+	"syscall"
+)
+
+// enableVTProcessing is the ENABLE_VIRTUAL_TERMINAL_PROCESSING console mode,
+// which makes conhost interpret ANSI escape sequences like Windows Terminal does.
+const enableVTProcessing = 0x0004
+
+var setConsoleMode = syscall.NewLazyDLL("kernel32.dll").NewProc("SetConsoleMode")
+
+// EnableVT enables ANSI escape sequence processing of the consoles attached to
+// stdout and stderr, which the selector, prompts and terminal title rely on.
+func EnableVT() {
+	for _, f := range []*os.File{os.Stdout, os.Stderr} {
+		h := syscall.Handle(f.Fd())
+
+		var mode uint32
+		if err := syscall.GetConsoleMode(h, &mode); err != nil {
+			// Not a console, e.g. redirected to a file.
+			continue
+		}
+
+		_, _, _ = setConsoleMode.Call(uintptr(h), uintptr(mode|enableVTProcessing))
+	}
+}
@@ -0,0 +1,108 @@
+package selector
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// index is a trigram index over the names, zones and labels of instances
+// used to search in the selector. Results are cached per input since the
+// selector searches each item for every keystroke.
+type index struct {
+	docs  []string
+	grams map[string][]int
+
+	input   string
+	matches []bool
+}
+
+// newIndex returns an index of the instances.
+func newIndex(instances []inventory.Instance) *index {
+	x := &index{grams: make(map[string][]int)}
+	for i, inst := range instances {
+		fields := []string{inst.Name, inst.Location(), inst.Project}
+		for k, v := range inst.Labels {
+			fields = append(fields, k+"="+v)
+		}
+		sort.Strings(fields[3:])
+
+		doc := strings.ToLower(strings.Join(fields, " "))
+		x.docs = append(x.docs, doc)
+
+		for _, g := range trigrams(doc) {
+			if l := x.grams[g]; len(l) == 0 || l[len(l)-1] != i {
+				x.grams[g] = append(l, i)
+			}
+		}
+	}
+
+	return x
+}
+
+// Match returns true if the instance at index i contains all whitespace separated terms of the input.
+func (x *index) Match(input string, i int) bool {
+	if x.matches == nil || input != x.input {
+		x.input = input
+		x.matches = x.search(input)
+	}
+
+	return x.matches[i]
+}
+
+// search returns whether each instance matches the input.
+func (x *index) search(input string) []bool {
+	matches := make([]bool, len(x.docs))
+	for i := range matches {
+		matches[i] = true
+	}
+
+	for _, term := range strings.Fields(strings.ToLower(input)) {
+		candidates := make([]bool, len(x.docs))
+		if len(term) < 3 {
+			// Too short for trigrams, scan all.
+			for i := range candidates {
+				candidates[i] = true
+			}
+		} else {
+			for _, i := range x.postings(term) {
+				candidates[i] = true
+			}
+		}
+
+		for i, ok := range matches {
+			matches[i] = ok && candidates[i] && strings.Contains(x.docs[i], term)
+		}
+	}
+
+	return matches
+}
+
+// postings returns the candidate instances for the term, i.e. those containing its
+// rarest trigram, or nil if any trigram is absent.
+func (x *index) postings(term string) []int {
+	var shortest []int
+	grams := trigrams(term)
+	for i, g := range grams {
+		l, ok := x.grams[g]
+		if !ok {
+			return nil
+		}
+		if i == 0 || len(l) < len(shortest) {
+			shortest = l
+		}
+	}
+
+	return shortest
+}
+
+// trigrams returns the overlapping three byte substrings of s.
+func trigrams(s string) []string {
+	var grams []string
+	for i := 0; i+3 <= len(s); i++ {
+		grams = append(grams, s[i:i+3])
+	}
+
+	return grams
+}
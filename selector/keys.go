@@ -0,0 +1,262 @@
+package selector
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/inventory"
+)
+
+// Key binds a control key of the selector to an action on the highlighted instance.
+type Key struct {
+	// Code is the control character, e.g. 0x0f for ctrl-o.
+	Code byte
+	// Help describes the key in the selector label, e.g. "ctrl-o: describe".
+	Help string
+	// Run runs the action, after which the selector is shown again.
+	Run func(ctx context.Context, inst inventory.Instance) error
+}
+
+// keyGrace is how long the stdin reader pauses after a final key, waiting for
+// the selector to return. If it doesn't, e.g. since the search has no results,
+// reading resumes.
+const keyGrace = 200 * time.Millisecond
+
+// keyStdin reads the selector input, translating the key codes into enter so
+// that the selector returns the highlighted item. After enter or a key code it
+// stops reading, so that no read is left pending when the selector returns,
+// which would swallow the next keystroke, e.g. of ssh or the next selector.
+// If refresh fires, enter is injected so that the selector returns the
+// highlighted item and can be restarted with updated items.
+type keyStdin struct {
+	in      *input
+	codes   map[byte]bool
+	refresh <-chan struct{}
+	done    chan struct{}
+	once    sync.Once
+
+	mu        sync.Mutex
+	pressed   byte
+	final     bool
+	refreshed bool
+}
+
+// newKeyStdin returns a selector input translating the key codes.
+func newKeyStdin(in *input, keys []Key, refresh <-chan struct{}) *keyStdin {
+	k := &keyStdin{in: in, codes: make(map[byte]bool), refresh: refresh, done: make(chan struct{})}
+	for _, key := range keys {
+		k.codes[key.Code] = true
+	}
+
+	return k
+}
+
+func (k *keyStdin) Read(b []byte) (int, error) {
+	k.mu.Lock()
+	final, refreshed := k.final, k.refreshed
+	k.mu.Unlock()
+
+	if final {
+		select {
+		case <-k.done:
+			return 0, io.EOF
+		case <-time.After(keyGrace):
+		}
+
+		if refreshed {
+			// Enter doesn't return without search results, so end the selector with EOF instead.
+			return 0, io.EOF
+		}
+	}
+
+	n, refresh, err := k.in.read(b, k.done, k.refresh)
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	k.pressed, k.final = 0, false
+	if refresh {
+		k.refreshed, k.final, b[0] = true, true, readline.CharEnter
+		return 1, nil
+	}
+
+	k.in.track(b[:n])
+	if n == 1 && k.codes[b[0]] {
+		k.pressed, b[0] = b[0], readline.CharEnter
+	}
+	if n == 1 && (b[0] == readline.CharEnter || b[0] == readline.CharCtrlJ) {
+		k.final = true
+	}
+
+	return n, err
+}
+
+// Close stops reading, it is called when the selector returns.
+func (k *keyStdin) Close() error {
+	k.once.Do(func() { close(k.done) })
+	return nil
+}
+
+// Pressed returns the key code that selected the item, or zero if enter did.
+func (k *keyStdin) Pressed() byte {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.pressed
+}
+
+// Refreshed returns true if the selector returned since refresh fired.
+func (k *keyStdin) Refreshed() bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	return k.refreshed
+}
+
+// input reads stdin for the consecutive runs of a selector. Stdin is only read
+// on demand of a run, so that no read is left pending when the selector
+// returns. A read abandoned by a refreshed run is delivered to the next one.
+type input struct {
+	r     io.Reader
+	want  chan struct{}
+	data  chan chunk
+	stop  chan struct{}
+	start sync.Once
+
+	mu        sync.Mutex
+	pending   bool
+	buf       []byte
+	searching bool
+	query     []byte
+}
+
+// chunk is the result of a stdin read.
+type chunk struct {
+	b   []byte
+	err error
+}
+
+// newInput returns a selector input reading stdin, it must be closed when the selector returns.
+func newInput() *input {
+	return &input{r: readline.Stdin, want: make(chan struct{}, 1), data: make(chan chunk, 1), stop: make(chan struct{})}
+}
+
+// loop reads stdin whenever a read is requested until closed.
+func (in *input) loop() {
+	for {
+		select {
+		case <-in.stop:
+			return
+		case <-in.want:
+		}
+
+		b := make([]byte, 256)
+		n, err := in.r.Read(b)
+		in.data <- chunk{b: b[:n], err: err}
+	}
+}
+
+// read reads the next input into b. It returns true without reading if
+// refresh fires first, and io.EOF if done is closed first.
+func (in *input) read(b []byte, done <-chan struct{}, refresh <-chan struct{}) (int, bool, error) {
+	in.mu.Lock()
+	if len(in.buf) > 0 {
+		n := copy(b, in.buf)
+		in.buf = in.buf[n:]
+		in.mu.Unlock()
+		return n, false, nil
+	}
+	if !in.pending {
+		in.start.Do(func() { go in.loop() })
+		in.pending = true
+		in.want <- struct{}{}
+	}
+	in.mu.Unlock()
+
+	select {
+	case <-done:
+		return 0, false, io.EOF
+	case <-refresh:
+		return 0, true, nil
+	case c := <-in.data:
+		in.mu.Lock()
+		defer in.mu.Unlock()
+
+		in.pending = false
+		select {
+		case <-done:
+			// The selector returned meanwhile, keep the input for the next one.
+			in.buf = c.b
+			return 0, false, io.EOF
+		default:
+		}
+
+		n := copy(b, c.b)
+		in.buf = c.b[n:]
+
+		return n, false, c.err
+	}
+}
+
+// track records whether the selector is in search mode, toggled by '/', and
+// the search query, i.e. the printable input since.
+func (in *input) track(b []byte) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case c == readline.CharEsc:
+			// Skip escape sequences, e.g. of the arrow keys.
+			if i+1 < len(b) && (b[i+1] == '[' || b[i+1] == 'O') {
+				for i += 2; i < len(b) && (b[i] < 0x40 || b[i] > 0x7e); i++ {
+				}
+			} else {
+				i++
+			}
+		case c == '/':
+			in.searching, in.query = !in.searching, nil
+		case !in.searching:
+		case c == readline.CharBackspace || c == readline.CharCtrlH:
+			if _, size := utf8.DecodeLastRune(in.query); size > 0 {
+				in.query = in.query[:len(in.query)-size]
+			}
+		case c >= ' ':
+			in.query = append(in.query, c)
+		}
+	}
+}
+
+// search returns true and the query if the selector is in search mode.
+func (in *input) search() (bool, string) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return in.searching, string(in.query)
+}
+
+// resume makes the next run search the query and move down to the result at
+// pos, restoring the search of the previous run. The next run must start in
+// search mode.
+func (in *input) resume(query string, pos int) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	// The query is tracked again when read.
+	in.query = nil
+
+	b := []byte(query)
+	for range pos {
+		b = append(b, readline.CharNext)
+	}
+	in.buf = append(b, in.buf...)
+}
+
+// Close stops reading stdin once the pending read, if any, completes.
+func (in *input) Close() {
+	close(in.stop)
+}
@@ -0,0 +1,391 @@
+// Package selector prompts the user to select an instance.
+package selector
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/manifoldco/promptui"
+)
+
+// Options configure the selector.
+type Options struct {
+	// Previous is preselected if present.
+	Previous inventory.Instance
+	// ShowProject includes the project of each instance.
+	ShowProject bool
+	// ShowCost includes the approximate hourly cost of each instance.
+	ShowCost bool
+	// Reachable marks instances as unreachable or unknown, it is ignored if nil.
+	Reachable []inventory.ProbeResult
+	// Latency includes the TCP connect time of each instance, zero if unreachable
+	// or inventory.LatencyIAP if only reachable through IAP, it is ignored if nil.
+	Latency []time.Duration
+	// Keys bind control keys to actions on the highlighted instance.
+	Keys []Key
+	// Updates replace the instances while they are still being listed, keeping
+	// the highlighted instance. The listing is complete once it is closed.
+	Updates <-chan []inventory.Instance
+}
+
+// Select prompts the user to select one of the given instances.
+func Select(ctx context.Context, instances []inventory.Instance, opts Options) (inventory.Instance, error) {
+	in := newInput()
+	defer in.Close()
+
+	var (
+		snaps   *snapshots
+		refresh <-chan struct{}
+		term    *terminal
+	)
+	if opts.Updates != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+
+		snaps = watch(opts.Updates, stop)
+		refresh = snaps.refresh
+
+		// Keys typed while the selector restarts would be echoed and
+		// translated by the terminal, so keep it in raw mode meanwhile.
+		term = rawTerminal()
+		defer term.restore()
+	}
+
+	highlighted := opts.Previous
+	for {
+		listing := snaps != nil && !snaps.complete()
+		labels, cursor := instanceLabels(instances, opts, highlighted)
+		// Keep highlighting the previous instance until it is listed, unless the user moved.
+		keep := cursor < 0
+		cursor = max(cursor, 0)
+
+		var help []string
+		if listing {
+			help = append(help, "listing...")
+		}
+		for _, key := range opts.Keys {
+			help = append(help, key.Help)
+		}
+
+		label := "Select VM"
+		if len(help) > 0 {
+			label += " (" + strings.Join(help, ", ") + ")"
+		}
+
+		index := newIndex(instances)
+		search, query := in.search()
+		if search {
+			// Restore the search of the previous run, e.g. if updated while typing.
+			in.resume(query, searchPos(index, query, cursor))
+		}
+
+		selector := promptui.Select{
+			Label:             label,
+			Items:             labels,
+			Size:              len(labels),
+			Searcher:          index.Match,
+			StartInSearchMode: search,
+			Stdout:            stderr{},
+			// Restarting the selector when updated mustn't print the highlighted item.
+			HideSelected: listing,
+		}
+
+		idx, code, refreshed, err := run(ctx, selector, cursor, in, opts.Keys, refresh)
+		if err != nil {
+			return inventory.Instance{}, err
+		} else if refreshed {
+			if idx >= 0 && (idx != cursor || !keep) {
+				highlighted = instances[idx]
+			}
+			if updated, ok := snaps.take(); ok {
+				instances = updated
+			}
+			continue
+		} else if code == 0 {
+			if listing {
+				term.restore()
+				fmt.Fprintf(os.Stderr, "%s %s\n", promptui.Styler(promptui.FGGreen)(promptui.IconGood), promptui.Styler(promptui.FGFaint)(labels[idx]))
+			}
+			return instances[idx], nil
+		}
+
+		for _, key := range opts.Keys {
+			if key.Code != code {
+				continue
+			}
+			term.restore()
+			err := key.Run(ctx, instances[idx])
+			term.raw()
+			if ctx.Err() != nil {
+				return inventory.Instance{}, ctx.Err()
+			} else if err != nil {
+				slog.Warn("Selector action failed", "key", key.Help, "err", err)
+			}
+		}
+		highlighted = instances[idx]
+	}
+}
+
+// instanceLabels returns the selector labels of the instances and the index of
+// the highlighted instance, or -1 if it isn't one of them.
+func instanceLabels(instances []inventory.Instance, opts Options, highlighted inventory.Instance) ([]string, int) {
+	var labels []string
+	cursor := -1
+	for i, inst := range instances {
+		label := fmt.Sprintf("%-40s%-30s", inst.Name, inst.Location())
+		if opts.ShowProject {
+			label += fmt.Sprintf("%-30s", inst.Project)
+		}
+		if opts.ShowCost {
+			label += fmt.Sprintf("%-12s", inst.CostLabel())
+		}
+		if i < len(opts.Latency) {
+			rtt := "-"
+			if opts.Latency[i] > 0 {
+				rtt = opts.Latency[i].Round(time.Millisecond).String()
+			} else if opts.Latency[i] == inventory.LatencyIAP {
+				rtt = "iap"
+			}
+			label += fmt.Sprintf("%-10s", rtt)
+		}
+		if cluster := inst.GKECluster(); cluster != "" {
+			label += fmt.Sprintf("%-40s", "gke:"+cluster+"/"+inst.GKENodePool())
+		}
+		if inst.Confidential() {
+			label += "[confidential] "
+		} else if inst.Shielded() {
+			label += "[shielded] "
+		}
+		if i < len(opts.Reachable) && opts.Reachable[i] == inventory.ProbeUnreachable {
+			label += "(unreachable)"
+		} else if i < len(opts.Reachable) && opts.Reachable[i] == inventory.ProbeUnknown {
+			label += "(unknown)"
+		}
+
+		labels = append(labels, strings.TrimSpace(label))
+
+		if inst.Name == highlighted.Name && inst.Project == highlighted.Project {
+			cursor = i
+		}
+	}
+
+	return labels, cursor
+}
+
+// searchPos returns the position of the instance at cursor in the search
+// results of the query, or zero if it doesn't match. Without a query, the
+// selector doesn't search and starts at the cursor.
+func searchPos(index *index, query string, cursor int) int {
+	if query == "" || !index.Match(query, cursor) {
+		return 0
+	}
+
+	var pos int
+	for i := range cursor {
+		if index.Match(query, i) {
+			pos++
+		}
+	}
+
+	return pos
+}
+
+// terminal is the state of the stdin terminal before entering raw mode.
+type terminal struct {
+	fd    int
+	state *readline.State
+}
+
+// rawTerminal puts the stdin terminal in raw mode, it must be restored when done.
+func rawTerminal() *terminal {
+	t := &terminal{fd: int(os.Stdin.Fd())}
+	t.raw()
+
+	return t
+}
+
+// raw puts the terminal in raw mode, if it isn't already. It is a no-op if nil.
+func (t *terminal) raw() {
+	if t == nil || t.state != nil {
+		return
+	}
+	if state, err := readline.MakeRaw(t.fd); err == nil {
+		t.state = state
+	}
+}
+
+// restore restores the terminal state before entering raw mode. It is a no-op if nil.
+func (t *terminal) restore() {
+	if t != nil && t.state != nil {
+		_ = readline.Restore(t.fd, t.state)
+		t.state = nil
+	}
+}
+
+// snapshots holds the latest instances received from Options.Updates.
+type snapshots struct {
+	// refresh fires when the instances were updated.
+	refresh chan struct{}
+
+	mu      sync.Mutex
+	latest  []inventory.Instance
+	updated bool
+	closed  bool
+}
+
+// watch returns the snapshots receiving the updates until closed or stopped.
+func watch(updates <-chan []inventory.Instance, stop <-chan struct{}) *snapshots {
+	s := &snapshots{refresh: make(chan struct{}, 1)}
+	go func() {
+		for {
+			var (
+				instances []inventory.Instance
+				ok        bool
+			)
+			select {
+			case <-stop:
+				return
+			case instances, ok = <-updates:
+			}
+
+			s.mu.Lock()
+			if ok {
+				s.latest, s.updated = instances, true
+			}
+			s.closed = !ok
+			s.mu.Unlock()
+
+			select {
+			case s.refresh <- struct{}{}:
+			default:
+			}
+
+			if !ok {
+				return
+			}
+		}
+	}()
+
+	return s
+}
+
+// take returns the latest instances, if updated since the last call.
+func (s *snapshots) take() ([]inventory.Instance, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	updated := s.updated
+	s.updated = false
+
+	return s.latest, updated
+}
+
+// complete returns true if the updates were closed.
+func (s *snapshots) complete() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.closed
+}
+
+// SelectItem prompts the user to select one of the given items with the label,
+// preselecting the previous item if possible.
+func SelectItem(ctx context.Context, label string, items []string, prev string) (string, error) {
+	var cursor int
+	for i, item := range items {
+		if item == prev {
+			cursor = i
+		}
+	}
+
+	selector := promptui.Select{
+		Label: label,
+		Items: items,
+		Size:  min(len(items), 20),
+		Searcher: func(input string, i int) bool {
+			return strings.Contains(strings.ToLower(items[i]), strings.ToLower(strings.TrimSpace(input)))
+		},
+		Stdout: stderr{},
+	}
+
+	in := newInput()
+	defer in.Close()
+
+	idx, _, _, err := run(ctx, selector, cursor, in, nil, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return items[idx], nil
+}
+
+// stderr renders the selectors on stderr, keeping stdout clean for the output
+// of gssh, e.g. with -select-only.
+type stderr struct{}
+
+func (stderr) Write(b []byte) (int, error) {
+	// Readline rings the bell when moving down since the selector has no
+	// history, including for the moves restoring a search.
+	if len(b) == 1 && b[0] == readline.CharBell {
+		return 1, nil
+	}
+
+	return os.Stderr.Write(b)
+}
+
+// Close doesn't close stderr, the selector closes its output when done.
+func (stderr) Close() error {
+	return nil
+}
+
+// run runs the selector and returns the selected index and the code of the key
+// that selected it, zero for enter. If refresh fired, it returns true and the
+// highlighted index, or -1 if the search has no results. If the context is
+// cancelled while prompting, the terminal state is restored and the context error returned.
+func run(ctx context.Context, selector promptui.Select, cursor int, in *input, keys []Key, refresh <-chan struct{}) (int, byte, bool, error) {
+	fd := int(os.Stdin.Fd())
+	state, _ := readline.GetState(fd)
+
+	stdin := newKeyStdin(in, keys, refresh)
+	defer stdin.Close()
+	selector.Stdin = stdin
+
+	type result struct {
+		idx int
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		idx, _, err := selector.RunCursorAt(cursor, 0)
+		resc <- result{idx: idx, err: err}
+	}()
+
+	select {
+	case res := <-resc:
+		if stdin.Refreshed() && res.err == nil {
+			return res.idx, 0, true, nil
+		} else if stdin.Refreshed() && errors.Is(res.err, promptui.ErrEOF) {
+			return -1, 0, true, nil
+		} else if res.err != nil {
+			return 0, 0, false, fmt.Errorf("selector error: %w", res.err)
+		}
+
+		return res.idx, stdin.Pressed(), false, nil
+	case <-ctx.Done():
+		if state != nil {
+			_ = readline.Restore(fd, state)
+		}
+		// Show the cursor hidden by the selector.
+		fmt.Fprint(os.Stderr, "\033[?25h\n")
+
+		return 0, 0, false, ctx.Err()
+	}
+}
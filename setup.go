@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+	"github.com/corverroos/gssh/selector"
+	"github.com/manifoldco/promptui"
+)
+
+// IAP preferences offered by the setup wizard.
+const (
+	iapAuto     = "Tunnel through IAP only for VMs without an external IP"
+	iapAlways   = "Always tunnel through IAP"
+	iapInternal = "Connect to internal IPs, e.g. via a VPN"
+)
+
+// runSetup runs the interactive setup wizard.
+func runSetup(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	} else if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return errors.New("setup requires a terminal, use `gssh config set` instead")
+	}
+
+	return setupWizard(ctx)
+}
+
+// firstRun runs the setup wizard if there is no config file yet and gssh is
+// run interactively. If the wizard is aborted, an empty config is stored so
+// that it isn't offered again.
+func firstRun(ctx context.Context) {
+	if config.Exists() || !readline.IsTerminal(int(os.Stdin.Fd())) || !readline.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+
+	slog.Info("No gssh config found, starting the setup wizard, press Ctrl-C to skip it and use the defaults")
+
+	err := setupWizard(ctx)
+	if err == nil {
+		return
+	} else if ctx.Err() != nil {
+		return
+	}
+
+	slog.Info("Skipped setup, run `gssh setup` to rerun it", "err", err)
+	if err := config.Store(config.Config{}); err != nil {
+		slog.Debug("Failed to store config", "err", err)
+	}
+}
+
+// setupWizard prompts for the default projects, ssh user, IAP preference and
+// cache TTL and stores them in the config file with comments explaining them.
+func setupWizard(ctx context.Context) error {
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	gc := inventory.Gcloud{Timeout: time.Minute, Runner: runner.Exec{}}
+
+	defProjects := strings.Join(conf.Projects, ",")
+	if defProjects == "" {
+		if p, ok := inventory.ActiveProject(); ok {
+			defProjects = p
+		}
+	}
+	projects, err := promptValue("Default projects (comma separated)", defProjects, nil)
+	if err != nil {
+		return err
+	}
+	conf.Projects = nil
+	if err := conf.Set("projects", projects); err != nil {
+		return err
+	}
+	if _, ok := inventory.ActiveProject(); !ok && len(conf.Projects) > 0 {
+		// Select the first project if the gcloud configuration has none.
+		if conf.DefaultProjects == nil {
+			conf.DefaultProjects = make(map[string]string)
+		}
+		conf.DefaultProjects[inventory.ActiveConfig()] = conf.Projects[0]
+	}
+
+	defUser := conf.User
+	if defUser == "" {
+		defUser = os.Getenv("GSSH_USER")
+	}
+	user, err := promptValue("Default ssh user (empty for the gcloud default)", defUser, nil)
+	if err != nil {
+		return err
+	}
+	conf.User = strings.TrimSpace(user)
+
+	prev := iapAuto
+	if conf.IAP {
+		prev = iapAlways
+	} else if conf.Address == "internal" {
+		prev = iapInternal
+	}
+	iap, err := selector.SelectItem(ctx, "Connect to VMs", []string{iapAuto, iapAlways, iapInternal}, prev)
+	if err != nil {
+		return err
+	}
+	conf.IAP = iap == iapAlways
+	if iap == iapInternal {
+		conf.Address = "internal"
+	} else if conf.Address == "internal" {
+		conf.Address = ""
+	}
+
+	ttl, err := promptValue("Cache VM lists for", withDefault(conf.CacheTTL, "1m"), func(s string) error {
+		_, err := time.ParseDuration(s)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	conf.CacheTTL = ttl
+
+	if _, err := gc.ConfigGet(ctx, "account"); err != nil {
+		slog.Warn("No gcloud account, run `gcloud auth login` before connecting", "err", err)
+	}
+
+	filename, _ := config.Path()
+	comments := []string{
+		"// gssh config, written by `gssh setup`. Change values with `gssh config set <key> <value>`.",
+		"// projects: listed by -all-projects and kept warm by `gssh daemon`.",
+		"// user: default ssh username, overridden by $GSSH_USER and -u.",
+		"// iap: tunnel connections to all VMs through IAP, address internal connects to internal IPs.",
+		"// cache_ttl: max age of the cached VM lists before they are refetched.",
+		"// Lines starting with // are comments, they are kept when gssh updates this file.",
+	}
+	if err := config.StoreCommented(conf, comments); err != nil {
+		return err
+	}
+
+	slog.Info("Stored config", "file", filename)
+
+	return nil
+}
+
+// promptValue prompts for a value with the default, validating it if validate is not nil.
+func promptValue(label, def string, validate func(string) error) (string, error) {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   def,
+		AllowEdit: true,
+		Validate:  validate,
+	}
+
+	value, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("prompt error: %w", err)
+	}
+
+	return value, nil
+}
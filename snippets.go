@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/selector"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// runSnippet runs a named remote command snippet from the config on the
+// selected VM, passing the remaining args to it as $1, $2, etc. Without a
+// snippet name, it prompts the user to select one.
+func runSnippet(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	addTTYFlags(fs, opts)
+	addSudoFlags(fs, opts)
+	list := fs.Bool("list", false, "list the configured snippets")
+	parseFlags(fs, args)
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	}
+
+	if *list {
+		if fs.NArg() > 0 {
+			return errUsage
+		}
+		return printSnippets(conf.Snippets)
+	}
+
+	if len(conf.Snippets) == 0 {
+		return errors.New("no snippets configured, add one with `gssh config set snippets.<name> <command>`")
+	}
+
+	var name string
+	if fs.NArg() > 0 {
+		// Flags may also follow the snippet name, e.g. gssh run disk -f '^api'.
+		name = fs.Arg(0)
+		parseFlags(fs, fs.Args()[1:])
+	} else if name, err = pickSnippet(ctx, conf.Snippets); err != nil {
+		return err
+	}
+
+	snippet, ok := conf.Snippets[name]
+	if !ok {
+		return withExitCode(exitNoMatch, fmt.Errorf("unknown snippet %q, expected one of %s", name, strings.Join(snippetNames(conf.Snippets), ", ")))
+	}
+
+	// The snippet name is $0 of the script.
+	sshArgs := append([]string{"sh", "-c", snippet, name}, fs.Args()...)
+
+	return connect(ctx, *opts, sshrunner.Options{Args: sshArgs})
+}
+
+// pickSnippet prompts the user to select one of the snippets.
+func pickSnippet(ctx context.Context, snippets map[string]string) (string, error) {
+	if !readline.IsTerminal(int(os.Stdin.Fd())) {
+		return "", errUsage
+	}
+
+	var items []string
+	names := make(map[string]string)
+	for _, name := range snippetNames(snippets) {
+		item := fmt.Sprintf("%-20s %s", name, snippets[name])
+		items = append(items, item)
+		names[item] = name
+	}
+
+	item, err := selector.SelectItem(ctx, "Select snippet", items, "")
+	if err != nil {
+		return "", fmt.Errorf("select snippet error: %w", err)
+	}
+
+	return names[item], nil
+}
+
+// printSnippets prints the snippets as a table.
+func printSnippets(snippets map[string]string) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tCOMMAND")
+	for _, name := range snippetNames(snippets) {
+		fmt.Fprintf(w, "%s\t%s\n", name, snippets[name])
+	}
+
+	return w.Flush()
+}
+
+// snippetNames returns the sorted snippet names.
+func snippetNames(snippets map[string]string) []string {
+	var names []string
+	for name := range snippets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
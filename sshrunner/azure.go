@@ -0,0 +1,62 @@
+package sshrunner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// azureCommand returns the az command connecting to the Azure VM via Azure
+// Bastion if configured, otherwise via az ssh vm with Entra ID login. The ssh
+// flags and remote args are passed through to ssh.
+func azureCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	if opts.Container != "" {
+		return nil, fmt.Errorf("containers are not supported via az, set azure_connect to ssh")
+	}
+
+	var cmds []string
+	if opts.Bastion != "" {
+		group, name, ok := strings.Cut(opts.Bastion, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid bastion %q, expected resource-group/name", opts.Bastion)
+		}
+
+		cmds = []string{inventory.AzureBin, "network", "bastion", "ssh", "--name", name, "--resource-group", group, "--target-resource-id", inst.ID}
+		if opts.User != "" {
+			keyFile, err := IdentityFile(opts)
+			if err != nil {
+				return nil, err
+			}
+			cmds = append(cmds, "--auth-type", "ssh-key", "--username", opts.User, "--ssh-key", keyFile)
+		} else {
+			cmds = append(cmds, "--auth-type", "AAD")
+		}
+	} else {
+		cmds = []string{inventory.AzureBin, "ssh", "vm", "--ids", inst.ID}
+		if opts.User != "" {
+			cmds = append(cmds, "--local-user", opts.User)
+		}
+		if opts.Identity != "" {
+			cmds = append(cmds, "--private-key-file", opts.Identity)
+		}
+		if opts.Port != 0 {
+			cmds = append(cmds, "--port", strconv.Itoa(opts.Port))
+		}
+	}
+
+	var sshArgs []string
+	for _, flag := range sshFlags(opts) {
+		if flag[0] != "-p" {
+			sshArgs = append(sshArgs, flag...)
+		}
+	}
+	sshArgs = append(sshArgs, remoteArgs(opts)...)
+	if len(sshArgs) > 0 {
+		cmds = append(cmds, "--")
+		cmds = append(cmds, sshArgs...)
+	}
+
+	return cmds, nil
+}
@@ -0,0 +1,43 @@
+package sshrunner
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// ssmCommand returns the aws ssm start-session command connecting to the EC2
+// instance via Session Manager, which logs in as ssm-user.
+func ssmCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	if len(opts.PortFwds) > 0 || opts.NoShell || opts.Container != "" {
+		return nil, fmt.Errorf("port forwarding and containers are not supported via SSM, set ec2_connect to ssh")
+	}
+
+	cmds := []string{inventory.AWSBin, "ssm", "start-session", "--target", inst.ID, "--region", inst.Project}
+	if args := remoteArgs(opts); len(args) > 0 {
+		// Ssh also joins its remote args with spaces.
+		params, err := json.Marshal(map[string][]string{"command": {strings.Join(args, " ")}})
+		if err != nil {
+			return nil, fmt.Errorf("marshal ssm parameters error: %w", err)
+		}
+		cmds = append(cmds, "--document-name", "AWS-StartInteractiveCommand", "--parameters", string(params))
+	}
+
+	return cmds, nil
+}
+
+// ssmProxyFlags returns the ssh flags tunneling the connection to the EC2
+// instance through Session Manager, like IAP for GCE instances.
+func ssmProxyFlags(inst inventory.Instance, opts Options) []string {
+	port := 22
+	if opts.Port != 0 {
+		port = opts.Port
+	}
+
+	proxy := fmt.Sprintf("%s ssm start-session --target %s --region %s --document-name AWS-StartSSHSession --parameters portNumber=%d",
+		inventory.AWSBin, inst.ID, inst.Project, port)
+
+	return []string{"-o", "ProxyCommand=" + proxy}
+}
@@ -0,0 +1,93 @@
+package sshrunner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/corverroos/gssh/runner"
+)
+
+// ErrIdle is returned by RunIdle if the session was disconnected due to the idle timeout.
+var ErrIdle = errors.New("session disconnected after idle timeout")
+
+// RunIdle runs the command attached to the current terminal like Run, but
+// disconnects it after the terminal saw no input or output for the timeout.
+// A warning is printed shortly before.
+//
+// Activity is detected via the modification time of the terminal device, which
+// is updated by output, including remote echoes of typed input.
+func RunIdle(ctx context.Context, r runner.Runner, cmds []string, timeout time.Duration) error {
+	fi, err := os.Stdout.Stat()
+	if err != nil || fi.Mode()&os.ModeCharDevice == 0 || fi.ModTime().IsZero() {
+		slog.Warn("Idle timeout not supported by this terminal, ignoring it")
+		return Run(ctx, r, cmds)
+	}
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	defer close(done)
+	go guardIdle(timeout, stop, done)
+
+	err = r.Run(ctx, runner.Cmd{
+		Name:        cmds[0],
+		Args:        cmds[1:],
+		Stdin:       os.Stdin,
+		Stdout:      os.Stdout,
+		Stderr:      os.Stderr,
+		Interactive: true,
+		Stop:        stop,
+	})
+
+	select {
+	case <-stop:
+		return ErrIdle
+	default:
+		return err
+	}
+}
+
+// guardIdle closes stop after the terminal was idle for the timeout or returns when done is closed.
+func guardIdle(timeout time.Duration, stop chan<- struct{}, done <-chan struct{}) {
+	var (
+		warnBefore = min(timeout/5, time.Minute)
+		poll       = max(min(timeout/10, 10*time.Second), 100*time.Millisecond)
+		last       = time.Now()
+		warned     time.Time
+	)
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		fi, err := os.Stdout.Stat()
+		if err != nil {
+			return
+		}
+
+		// Ignore the terminal modification by the warning itself.
+		if mod := fi.ModTime(); mod.After(last) && (warned.IsZero() || mod.After(warned.Add(time.Second))) {
+			last, warned = mod, time.Time{}
+		}
+
+		idle := time.Since(last)
+		if idle >= timeout {
+			fmt.Fprintf(os.Stderr, "\r\ngssh: session idle for %s, disconnecting\r\n", idle.Round(time.Second))
+			close(stop)
+
+			return
+		} else if idle >= timeout-warnBefore && warned.IsZero() {
+			fmt.Fprintf(os.Stderr, "\r\ngssh: session idle for %s, disconnecting in %s without activity\r\n",
+				idle.Round(time.Second), (timeout - idle).Round(time.Second))
+			warned = time.Now()
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package sshrunner
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// KnownHostsFile returns the known_hosts file of the project's instances used by
+// plain ssh, creating its directory if required.
+func KnownHostsFile(project string) (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("config dir error: %w", err)
+	}
+
+	if project == "" {
+		project = "default"
+	}
+
+	dir = filepath.Join(dir, "gssh", "known_hosts")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("create known_hosts dir error: %w", err)
+	}
+
+	return filepath.Join(dir, project), nil
+}
+
+// hostKeyAlias returns the name the instance's host keys are stored under. It
+// includes the instance ID so that a recreated instance with the same name is
+// trusted on first use again instead of failing with a changed host key.
+func hostKeyAlias(inst inventory.Instance) string {
+	if inst.ID == "" {
+		return inst.Name
+	}
+
+	return inst.Name + "." + inst.ID
+}
+
+// parseHostKeyAlias returns the instance name and ID of the host key alias, if it includes an ID.
+func parseHostKeyAlias(alias string) (name string, id string, ok bool) {
+	i := strings.LastIndexByte(alias, '.')
+	if i <= 0 || i == len(alias)-1 {
+		return "", "", false
+	}
+
+	name, id = alias[:i], alias[i+1:]
+	for _, c := range id {
+		if c < '0' || c > '9' {
+			return "", "", false
+		}
+	}
+
+	return name, id, true
+}
+
+// knownHostsFlags returns the ssh flags verifying the instance's host key
+// against the known_hosts file, prompting with its fingerprint on first use.
+func knownHostsFlags(inst inventory.Instance, opts Options) []string {
+	flags := []string{"-o", "HostKeyAlias=" + hostKeyAlias(inst)}
+	if opts.KnownHosts != "" {
+		flags = append(flags,
+			"-o", "UserKnownHostsFile="+opts.KnownHosts,
+			"-o", "StrictHostKeyChecking=ask",
+			"-o", "HashKnownHosts=no")
+	}
+
+	return flags
+}
+
+// PruneKnownHosts removes the host keys of previous instances with the same name
+// as the instance but another instance ID from the known_hosts file, i.e. of
+// recreated instances. It returns true if the file contains host keys of the instance.
+func PruneKnownHosts(file string, inst inventory.Instance) (bool, error) {
+	b, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("read known_hosts error: %w", err)
+	}
+
+	var (
+		alias  = hostKeyAlias(inst)
+		known  bool
+		pruned bool
+		out    bytes.Buffer
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := scanner.Text()
+		host, _, _ := strings.Cut(line, " ")
+		if host == alias {
+			known = true
+		} else if name, id, ok := parseHostKeyAlias(host); ok && inst.ID != "" && name == inst.Name && id != inst.ID {
+			pruned = true
+			continue
+		}
+		out.WriteString(line + "\n")
+	}
+	if err := scanner.Err(); err != nil {
+		return false, fmt.Errorf("read known_hosts error: %w", err)
+	}
+
+	if !pruned {
+		return known, nil
+	}
+
+	err = os.WriteFile(file, out.Bytes(), 0600)
+	if err != nil {
+		return false, fmt.Errorf("write known_hosts error: %w", err)
+	}
+
+	return known, nil
+}
@@ -0,0 +1,70 @@
+package sshrunner
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+func TestPruneKnownHosts(t *testing.T) {
+	const known = `web.111 ssh-ed25519 AAAAcurrent
+web.222 ssh-ed25519 AAAArecreated
+web.prod.333 ssh-ed25519 AAAAotherVM
+web.dev ssh-ed25519 AAAAnoID
+web ssh-ed25519 AAAAnameOnly
+web-2.444 ssh-ed25519 AAAAotherVM
+`
+	const want = `web.111 ssh-ed25519 AAAAcurrent
+web.prod.333 ssh-ed25519 AAAAotherVM
+web.dev ssh-ed25519 AAAAnoID
+web ssh-ed25519 AAAAnameOnly
+web-2.444 ssh-ed25519 AAAAotherVM
+`
+
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	if err := os.WriteFile(file, []byte(known), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := PruneKnownHosts(file, inventory.Instance{Name: "web", ID: "111"})
+	if err != nil {
+		t.Fatal(err)
+	} else if !ok {
+		t.Error("PruneKnownHosts() = false, want true")
+	}
+
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	} else if string(b) != want {
+		t.Errorf("pruned known_hosts:\n%s\nwant:\n%s", b, want)
+	}
+
+	// Without an ID nothing is pruned.
+	if ok, err := PruneKnownHosts(file, inventory.Instance{Name: "web.prod"}); err != nil || ok {
+		t.Errorf("PruneKnownHosts() = %v, %v, want false, nil", ok, err)
+	}
+}
+
+func TestParseHostKeyAlias(t *testing.T) {
+	tests := []struct {
+		alias    string
+		name, id string
+		ok       bool
+	}{
+		{alias: "web.111", name: "web", id: "111", ok: true},
+		{alias: "web.prod.333", name: "web.prod", id: "333", ok: true},
+		{alias: "web", ok: false},
+		{alias: "web.dev", ok: false},
+		{alias: "web.", ok: false},
+		{alias: ".111", ok: false},
+	}
+	for _, test := range tests {
+		name, id, ok := parseHostKeyAlias(test.alias)
+		if name != test.name || id != test.id || ok != test.ok {
+			t.Errorf("parseHostKeyAlias(%q) = %q, %q, %v, want %q, %q, %v", test.alias, name, id, ok, test.name, test.id, test.ok)
+		}
+	}
+}
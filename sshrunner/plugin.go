@@ -0,0 +1,27 @@
+package sshrunner
+
+import (
+	"fmt"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// pluginCommand returns the plugin's connect command connecting to the host,
+// see inventory.Plugin. Ssh specific options are not supported.
+func pluginCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	if len(opts.PortFwds) > 0 || opts.NoShell || opts.Container != "" {
+		return nil, fmt.Errorf("port forwarding and containers are not supported by plugin %s hosts", inst.Provider)
+	}
+
+	cmds := []string{inst.Plugin, "connect"}
+	if opts.User != "" {
+		cmds = append(cmds, "--user", opts.User)
+	}
+	cmds = append(cmds, inst.ID)
+	if args := remoteArgs(opts); len(args) > 0 {
+		cmds = append(cmds, "--")
+		cmds = append(cmds, args...)
+	}
+
+	return cmds, nil
+}
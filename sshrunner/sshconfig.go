@@ -0,0 +1,42 @@
+package sshrunner
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// HostConfig returns the ssh_config Host entry connecting to the instance with
+// plain ssh like the direct command, so that `ssh <host>` and tools using ssh work.
+func HostConfig(host string, inst inventory.Instance, opts Options) (string, error) {
+	ip, err := directIP(inst, opts)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", host)
+	fmt.Fprintf(&b, "  HostName %s\n", ip)
+	if opts.User != "" {
+		fmt.Fprintf(&b, "  User %s\n", opts.User)
+	}
+	if opts.Port != 0 {
+		fmt.Fprintf(&b, "  Port %d\n", opts.Port)
+	}
+	if keyFile, err := IdentityFile(opts); err == nil {
+		fmt.Fprintf(&b, "  IdentityFile %s\n", keyFile)
+	}
+
+	// Convert the "-o Key=Value" flags to config options.
+	flags := append(knownHostsFlags(inst, opts), proxyFlags(inst, opts)...)
+	for _, flag := range controlFlags(opts) {
+		flags = append(flags, "-o", flag)
+	}
+	for i := 1; i < len(flags); i += 2 {
+		key, value, _ := strings.Cut(flags[i], "=")
+		fmt.Fprintf(&b, "  %s %s\n", key, value)
+	}
+
+	return b.String(), nil
+}
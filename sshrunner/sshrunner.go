@@ -0,0 +1,685 @@
+// Package sshrunner builds and runs the ssh command connecting to an instance.
+package sshrunner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+)
+
+// Options configure the ssh command.
+type Options struct {
+	// User is the ssh username, empty for the gcloud default.
+	User string
+	// PortFwds are the ssh -L port forwarding specs, if any.
+	PortFwds []string
+	// NoShell doesn't execute a remote command, useful for forwarding ports only.
+	NoShell bool
+	// Direct connects with plain ssh to the instance's IP, bypassing gcloud.
+	Direct bool
+	// Address selects the "internal" or "external" IP of the instance, empty for the external IP if any,
+	// otherwise the internal IP (plain ssh) or IAP (gcloud).
+	Address string
+	// Identity is the private key file, empty for the key generated by gcloud.
+	Identity string
+	// KnownHosts is the known_hosts file used for direct connections, empty for the ssh default.
+	KnownHosts string
+	// IAP tunnels connections through Identity-Aware Proxy (or SSM Session Manager
+	// for EC2 instances), for instances without an external IP or if preferred.
+	IAP bool
+	// SSM connects to EC2 instances via SSM Session Manager instead of ssh.
+	SSM bool
+	// AzureSSH connects to Azure VMs via az ssh vm, or via Azure Bastion if
+	// Bastion is set, instead of plain ssh.
+	AzureSSH bool
+	// Bastion is the "resource-group/name" of the Azure Bastion.
+	Bastion string
+	// TailscaleSSH connects to tailnet machines via tailscale ssh instead of plain ssh.
+	TailscaleSSH bool
+	// Args are the ssh_args passed to the underlying ssh implementation.
+	Args []string
+	// Serial attaches to the instance's serial console instead of connecting via ssh.
+	Serial bool
+	// Container connects to the container on a Container-Optimized OS instance, if not empty.
+	Container string
+	// Port is the ssh port, zero for the default.
+	Port int
+	// Init is the command run before the interactive login shell, if not empty.
+	Init string
+	// Tmux attaches to or creates the named remote tmux session instead of a login shell, if not empty.
+	Tmux string
+	// Sudo runs the remote command or login shell as this user via sudo, if not empty.
+	Sudo string
+	// TTY forces ("true") or disables ("false") pseudo-terminal allocation, empty for the ssh default.
+	TTY string
+	// ForwardAgent forwards the ssh agent connection.
+	ForwardAgent bool
+	// ForwardX11 forwards X11, "untrusted" or "trusted" (not subject to the X11 SECURITY extension), empty to disable.
+	ForwardX11 string
+	// KeepAlive is the interval of ssh keepalive messages, which detect dead
+	// connections and keep idle connections through IAP and NAT alive, zero to disable.
+	KeepAlive time.Duration
+	// ControlDir is the directory of the ssh ControlMaster sockets, which are shared
+	// by connections to the same instance, empty to disable multiplexing.
+	ControlDir string
+	// SendEnv are the local environment variables sent to the instance, which may contain
+	// wildcards, or "NAME=value" variables set on the instance. Both require AcceptEnv in
+	// the instance's sshd_config.
+	SendEnv []string
+	// DebugLog enables verbose ssh (and gcloud) debug output written to this file, if not empty.
+	DebugLog string
+	// SSHFlags are passed to ssh itself, e.g. "-o ConnectTimeout=5".
+	SSHFlags []string
+}
+
+// keepAliveCountMax is the number of unanswered keepalive messages after which ssh disconnects.
+const keepAliveCountMax = 3
+
+// controlPersist is the duration an idle ControlMaster connection is kept open.
+const controlPersist = "10m"
+
+// ExitConnectionFailed is the ssh exit code if the connection failed.
+const ExitConnectionFailed = 255
+
+// Command returns the command connecting to the instance.
+func Command(inst inventory.Instance, opts Options) ([]string, error) {
+	if opts.Sudo != "" && (opts.Container != "" || opts.Serial) {
+		return nil, fmt.Errorf("sudo is not supported with containers or the serial console")
+	} else if opts.Serial {
+		return serialCommand(inst, opts)
+	} else if inst.Plugin != "" {
+		return pluginCommand(inst, opts)
+	} else if opts.SSM {
+		return ssmCommand(inst, opts)
+	} else if opts.AzureSSH {
+		return azureCommand(inst, opts)
+	} else if opts.TailscaleSSH {
+		return tailscaleCommand(inst, opts)
+	} else if opts.Direct {
+		return directCommand(inst, opts)
+	}
+
+	host := inst.Name
+	if opts.User != "" {
+		host = opts.User + "@" + host
+	}
+
+	cmds := []string{inventory.GcloudBin, "compute", "ssh", fmt.Sprintf("--zone=%s", inst.TrimZone())}
+	if inst.Project != "" {
+		cmds = append(cmds, fmt.Sprintf("--project=%s", inst.Project))
+	}
+	if opts.Address == "internal" {
+		cmds = append(cmds, "--internal-ip")
+	}
+	if opts.IAP {
+		cmds = append(cmds, "--tunnel-through-iap")
+	}
+	if opts.Identity != "" {
+		cmds = append(cmds, "--ssh-key-file="+opts.Identity)
+	}
+	if opts.DebugLog != "" {
+		cmds = append(cmds, "--verbosity=debug")
+	}
+	flags, err := gcloudFlags("--ssh-flag=", sshFlags(opts))
+	if err != nil {
+		return nil, err
+	}
+	cmds = append(cmds, flags...)
+	if opts.Container != "" {
+		cmds = append(cmds, fmt.Sprintf("--container=%s", opts.Container))
+	}
+	cmds = append(cmds, host)
+	if args := remoteArgs(opts); len(args) > 0 {
+		cmds = append(cmds, "--")
+		cmds = append(cmds, args...)
+	}
+
+	return cmds, nil
+}
+
+// sshFlags returns the flags passed to ssh itself, each with its value if any.
+func sshFlags(opts Options) [][]string {
+	var flags [][]string
+	for _, fwd := range opts.PortFwds {
+		flags = append(flags, []string{"-L", fwd})
+	}
+	if opts.NoShell {
+		flags = append(flags, []string{"-N"})
+	}
+	if opts.Port != 0 {
+		flags = append(flags, []string{"-p", strconv.Itoa(opts.Port)})
+	}
+	switch {
+	case opts.TTY == "false":
+		flags = append(flags, []string{"-T"})
+	case opts.TTY == "true":
+		flags = append(flags, []string{"-t"})
+	case replacesShell(opts):
+		// Running a remote command replaces the login shell, so force a tty.
+		flags = append(flags, []string{"-t"})
+	}
+	if secs := int(opts.KeepAlive.Seconds()); secs > 0 {
+		flags = append(flags,
+			[]string{"-o", fmt.Sprintf("ServerAliveInterval=%d", secs)},
+			[]string{"-o", fmt.Sprintf("ServerAliveCountMax=%d", keepAliveCountMax)})
+	}
+	if opts.ForwardAgent {
+		flags = append(flags, []string{"-A"})
+	}
+	switch opts.ForwardX11 {
+	case "untrusted":
+		flags = append(flags, []string{"-X"})
+	case "trusted":
+		flags = append(flags, []string{"-Y"})
+	}
+	for _, env := range opts.SendEnv {
+		if strings.Contains(env, "=") {
+			flags = append(flags, []string{"-o", "SetEnv=" + env})
+		} else {
+			flags = append(flags, []string{"-o", "SendEnv=" + env})
+		}
+	}
+	for _, flag := range controlFlags(opts) {
+		flags = append(flags, []string{"-o", flag})
+	}
+	if opts.DebugLog != "" {
+		flags = append(flags, []string{"-vvv"}, []string{"-E", opts.DebugLog})
+	}
+	for _, flag := range opts.SSHFlags {
+		flags = append(flags, splitFlag(flag))
+	}
+
+	return flags
+}
+
+// controlFlags returns the ssh options multiplexing connections via a ControlMaster
+// socket per user, host and port if enabled.
+func controlFlags(opts Options) []string {
+	if opts.ControlDir == "" {
+		return nil
+	}
+
+	return []string{
+		"ControlMaster=auto",
+		"ControlPath=" + filepath.Join(opts.ControlDir, "%C"),
+		"ControlPersist=" + controlPersist,
+	}
+}
+
+// ControlDir returns the directory of the ControlMaster sockets, creating it if required.
+func ControlDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache dir error: %w", err)
+	}
+
+	dir = filepath.Join(dir, "gssh", "cm")
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", fmt.Errorf("create control dir error: %w", err)
+	}
+
+	return dir, nil
+}
+
+// replacesShell returns true if the interactive login shell is replaced by
+// a remote command running the init command, tmux or sudo.
+func replacesShell(opts Options) bool {
+	return (opts.Init != "" || opts.Tmux != "" || opts.Sudo != "") && len(opts.Args) == 0 && !opts.NoShell
+}
+
+// remoteArgs returns the remote command args, run via sudo if enabled.
+func remoteArgs(opts Options) []string {
+	if opts.Sudo == "" || opts.NoShell {
+		return commandArgs(opts)
+	}
+
+	sudo := []string{"sudo", "-iu", shellQuote(opts.Sudo)}
+	if opts.Init == "" && opts.Tmux == "" && len(opts.Args) == 0 {
+		// The user's login shell.
+		return sudo
+	}
+
+	args := commandArgs(opts)
+	if len(args) == 1 {
+		// Run the script in a shell as the user, since sudo -i escapes its args.
+		args = []string{"sh", "-c", shellQuote(args[0])}
+	}
+
+	return append(append(sudo, "--"), args...)
+}
+
+// commandArgs returns the remote command args if there are no args, which run the
+// init command followed by a login shell, in the tmux session if any.
+//
+// Ssh joins its args with spaces into a command line interpreted by the remote
+// shell, so a single arg is passed as is, allowing shell scripts like "ls | wc -l",
+// while multiple args are quoted individually so that `echo "a b"` keeps its spaces.
+func commandArgs(opts Options) []string {
+	if (opts.Init != "" || opts.Tmux != "") && len(opts.Args) == 0 && !opts.NoShell {
+		shell := `exec "$SHELL" -l`
+		if opts.Init != "" {
+			shell = opts.Init + "; " + shell
+		}
+		if opts.Tmux == "" {
+			return []string{shell}
+		}
+
+		// The shell command only runs if the session is created.
+		tmux := "tmux new-session -A -s " + shellQuote(opts.Tmux)
+		if opts.Init != "" {
+			tmux += " " + shellQuote(shell)
+		}
+
+		return []string{tmux}
+	} else if len(opts.Args) <= 1 {
+		return opts.Args
+	}
+
+	var args []string
+	for _, arg := range opts.Args {
+		args = append(args, shellQuote(arg))
+	}
+
+	return args
+}
+
+// shellQuote returns the arg quoted for a POSIX shell if it contains anything
+// other than safe characters.
+func shellQuote(arg string) string {
+	if arg != "" && strings.Trim(arg, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=+,.:/@%^") == "" {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'"'"'`) + "'"
+}
+
+// SplitWords splits the string into words like a POSIX shell, honoring single
+// and double quotes and backslash escapes, without any expansion.
+func SplitWords(s string) ([]string, error) {
+	var (
+		words  []string
+		word   strings.Builder
+		inWord bool
+		quote  byte
+	)
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case quote == '\'':
+			if c == '\'' {
+				quote = 0
+			} else {
+				word.WriteByte(c)
+			}
+		case quote == '"':
+			if c == '"' {
+				quote = 0
+			} else if c == '\\' && i+1 < len(s) && strings.IndexByte("$`\"\\", s[i+1]) >= 0 {
+				i++
+				word.WriteByte(s[i])
+			} else {
+				word.WriteByte(c)
+			}
+		case c == '\'' || c == '"':
+			quote, inWord = c, true
+		case c == '\\':
+			if i+1 == len(s) {
+				return nil, fmt.Errorf("trailing backslash in %q", s)
+			}
+			i++
+			word.WriteByte(s[i])
+			inWord = true
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated %c quote in %q", quote, s)
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// gcloudFlags returns the words of the flags as separate gcloud flags with the
+// prefix, e.g. "--ssh-flag=". gcloud splits flag values on whitespace, so
+// words containing whitespace are not supported.
+func gcloudFlags(prefix string, flags [][]string) ([]string, error) {
+	var res []string
+	for _, flag := range flags {
+		for _, word := range flag {
+			if strings.ContainsFunc(word, unicode.IsSpace) {
+				return nil, fmt.Errorf("gcloud splits %s values on whitespace, %q is not supported, use -native instead", strings.TrimSuffix(prefix, "="), word)
+			}
+			res = append(res, prefix+word)
+		}
+	}
+
+	return res, nil
+}
+
+// splitFlag returns the words of the ssh flag, e.g. "-o" and "ProxyCommand=a b"
+// for -o 'ProxyCommand=a b'.
+func splitFlag(flag string) []string {
+	words, err := SplitWords(flag)
+	if err != nil {
+		// Validated by the -ssh-flag flag.
+		return strings.Fields(flag)
+	}
+
+	return words
+}
+
+// Quote returns the command line of the command, quoting args for a POSIX shell
+// as required, or for cmd.exe and PowerShell on windows.
+func Quote(cmds []string) string {
+	quote := shellQuote
+	if runtime.GOOS == "windows" {
+		quote = windowsQuote
+	}
+
+	var quoted []string
+	for _, arg := range cmds {
+		quoted = append(quoted, quote(arg))
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// windowsQuote returns the arg double quoted per the CommandLineToArgvW rules
+// if it contains whitespace, quotes or cmd.exe metacharacters.
+func windowsQuote(arg string) string {
+	if arg != "" && !strings.ContainsAny(arg, " \t\"&|<>^%()") {
+		return arg
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	var slashes int
+	for i := 0; i < len(arg); i++ {
+		switch arg[i] {
+		case '\\':
+			slashes++
+		case '"':
+			// Escape the quote and the backslashes preceding it.
+			b.WriteString(strings.Repeat(`\`, slashes+1))
+			slashes = 0
+		default:
+			slashes = 0
+		}
+		b.WriteByte(arg[i])
+	}
+	// Escape the trailing backslashes preceding the closing quote.
+	b.WriteString(strings.Repeat(`\`, slashes))
+	b.WriteByte('"')
+
+	return b.String()
+}
+
+// serialCommand returns the gcloud command attaching to the instance's serial console.
+func serialCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	if len(opts.PortFwds) > 0 || len(opts.Args) > 0 || opts.Container != "" {
+		return nil, fmt.Errorf("serial console does not support port forwarding, commands or containers")
+	}
+
+	host := inst.Name
+	if opts.User != "" {
+		host = opts.User + "@" + host
+	}
+
+	cmds := []string{inventory.GcloudBin, "compute", "connect-to-serial-port", fmt.Sprintf("--zone=%s", inst.TrimZone())}
+	if inst.Project != "" {
+		cmds = append(cmds, fmt.Sprintf("--project=%s", inst.Project))
+	}
+
+	return append(cmds, host), nil
+}
+
+// CopyCommand returns the command copying the paths to the last path between the
+// local machine and the instance. Remote paths are prefixed with ':'.
+func CopyCommand(inst inventory.Instance, opts Options, recurse bool, paths []string) ([]string, error) {
+	host := inst.Name
+	cmds := []string{inventory.GcloudBin, "compute", "scp", fmt.Sprintf("--zone=%s", inst.TrimZone())}
+	if inst.Project != "" {
+		cmds = append(cmds, fmt.Sprintf("--project=%s", inst.Project))
+	}
+	if recurse {
+		cmds = append(cmds, "--recurse")
+	}
+	if opts.Address == "internal" {
+		cmds = append(cmds, "--internal-ip")
+	}
+	if opts.IAP {
+		cmds = append(cmds, "--tunnel-through-iap")
+	}
+	if opts.Identity != "" {
+		cmds = append(cmds, "--ssh-key-file="+opts.Identity)
+	}
+	if !opts.Direct {
+		var flags [][]string
+		if opts.Port != 0 {
+			flags = append(flags, []string{"-P", strconv.Itoa(opts.Port)})
+		}
+		for _, flag := range controlFlags(opts) {
+			flags = append(flags, []string{"-o", flag})
+		}
+		for _, flag := range opts.SSHFlags {
+			flags = append(flags, splitFlag(flag))
+		}
+		scpFlags, err := gcloudFlags("--scp-flag=", flags)
+		if err != nil {
+			return nil, err
+		}
+		cmds = append(cmds, scpFlags...)
+	} else {
+		ip, err := directIP(inst, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		host = ip
+		cmds = append([]string{"scp"}, hostFlags(inst, opts)...)
+		if recurse {
+			cmds = append(cmds, "-r")
+		}
+		if opts.Port != 0 {
+			cmds = append(cmds, "-P", strconv.Itoa(opts.Port))
+		}
+		for _, flag := range controlFlags(opts) {
+			cmds = append(cmds, "-o", flag)
+		}
+		for _, flag := range opts.SSHFlags {
+			cmds = append(cmds, splitFlag(flag)...)
+		}
+	}
+
+	if opts.User != "" {
+		host = opts.User + "@" + host
+	}
+
+	var remote bool
+	for _, p := range paths {
+		if strings.HasPrefix(p, ":") {
+			p = host + p
+			remote = true
+		}
+		cmds = append(cmds, p)
+	}
+
+	if !remote {
+		return nil, fmt.Errorf("no remote path, prefix remote paths with ':'")
+	}
+
+	return cmds, nil
+}
+
+// directIP returns the IP used to connect directly to the instance, or the
+// host name of hosts imported from ssh_config so that their config applies.
+func directIP(inst inventory.Instance, opts Options) (string, error) {
+	if inst.Provider == inventory.ProviderSSHConfig {
+		return inst.Name, nil
+	}
+
+	var ip string
+	switch opts.Address {
+	case "internal":
+		ip = inst.InternalIP()
+	case "external":
+		if ip = inst.ExternalIP(); ip == "" {
+			return "", fmt.Errorf("VM %s has no external IP", inst.Name)
+		}
+	default:
+		ip = inst.ExternalIP()
+		if ip == "" {
+			ip = inst.InternalIP()
+		}
+	}
+	if ip == "" {
+		return "", fmt.Errorf("no cached IP for VM %s", inst.Name)
+	}
+
+	return ip, nil
+}
+
+// KeyFile returns the path of the private key generated by gcloud compute ssh.
+func KeyFile() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("home dir error: %w", err)
+	}
+
+	return filepath.Join(home, ".ssh", "google_compute_engine"), nil
+}
+
+// IdentityFile returns the private key file used to connect.
+func IdentityFile(opts Options) (string, error) {
+	if opts.Identity != "" {
+		return opts.Identity, nil
+	}
+
+	return KeyFile()
+}
+
+// keyFlags returns the ssh flags selecting the identity file.
+func keyFlags(opts Options) []string {
+	keyFile, err := IdentityFile(opts)
+	if err != nil {
+		return nil
+	}
+
+	return []string{"-i", keyFile}
+}
+
+// hostFlags returns the ssh flags selecting the identity file and verifying the
+// host key of the instance, or the ssh_config file of hosts imported from one.
+func hostFlags(inst inventory.Instance, opts Options) []string {
+	var flags []string
+	switch inst.Provider {
+	case "":
+		flags = append(keyFlags(opts), knownHostsFlags(inst, opts)...)
+		return append(flags, proxyFlags(inst, opts)...)
+	case inventory.ProviderSSHConfig:
+		if home, err := os.UserHomeDir(); err == nil && inventory.ExpandHome(inst.SSHConfig) != filepath.Join(home, ".ssh", "config") {
+			// Hosts of the user's config are resolved by default.
+			flags = append(flags, "-F", inventory.ExpandHome(inst.SSHConfig))
+		}
+	case inventory.ProviderEC2:
+		if opts.IAP {
+			flags = append(flags, ssmProxyFlags(inst, opts)...)
+		}
+	}
+
+	if inst.ProxyJump != "" {
+		flags = append(flags, "-J", inst.ProxyJump)
+	}
+
+	if opts.Identity != "" {
+		flags = append(flags, "-i", opts.Identity)
+	}
+
+	return flags
+}
+
+// proxyFlags returns the ssh flags tunneling the connection through IAP if enabled.
+// Only the tunnel is started by gcloud, ssh itself and the key handling are native.
+func proxyFlags(inst inventory.Instance, opts Options) []string {
+	if !opts.IAP {
+		return nil
+	}
+
+	port := 22
+	if opts.Port != 0 {
+		port = opts.Port
+	}
+
+	// The gcloud path may contain spaces, e.g. in C:\Program Files (x86).
+	proxy := fmt.Sprintf("%s compute start-iap-tunnel %s %d --listen-on-stdin --zone=%s", Quote([]string{inventory.GcloudBin}), inst.Name, port, inst.TrimZone())
+	if inst.Project != "" {
+		proxy += " --project=" + inst.Project
+	}
+
+	return []string{"-o", "ProxyCommand=" + proxy}
+}
+
+// directCommand returns a plain ssh command connecting to the instance's IP
+// using the gcloud generated key, bypassing gcloud.
+func directCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	ip, err := directIP(inst, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	cmds := append([]string{"ssh"}, hostFlags(inst, opts)...)
+	for _, flag := range sshFlags(opts) {
+		cmds = append(cmds, flag...)
+	}
+
+	args := remoteArgs(opts)
+	if opts.Container != "" {
+		// Equivalent to gcloud compute ssh --container.
+		cmds = append(cmds, "-t")
+		if len(args) == 0 {
+			args = []string{"/bin/sh"}
+		} else if len(opts.Args) == 1 {
+			// Run a single arg script in the container, not on the host.
+			args = []string{"/bin/sh", "-c", shellQuote(args[0])}
+		}
+		args = append([]string{"sudo", "docker", "exec", "-it", shellQuote(opts.Container)}, args...)
+	}
+
+	host := ip
+	if opts.User != "" {
+		host = opts.User + "@" + ip
+	}
+	cmds = append(cmds, host)
+	if len(args) > 0 {
+		cmds = append(cmds, "--")
+		cmds = append(cmds, args...)
+	}
+
+	return cmds, nil
+}
+
+// Run runs the command attached to the current terminal.
+func Run(ctx context.Context, r runner.Runner, cmds []string) error {
+	return runner.Terminal(ctx, r, cmds[0], cmds[1:]...)
+}
@@ -0,0 +1,372 @@
+package sshrunner
+
+import (
+	"os/exec"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// quoteArgs are args with spaces and shell metacharacters.
+var quoteArgs = []struct {
+	name string
+	arg  string
+}{
+	{name: "plain", arg: "abc"},
+	{name: "empty", arg: ""},
+	{name: "space", arg: "a b"},
+	{name: "single quote", arg: "it's"},
+	{name: "double quote", arg: `say "hi"`},
+	{name: "dollar", arg: "$HOME"},
+	{name: "subshell", arg: "$(id)"},
+	{name: "backtick", arg: "`id`"},
+	{name: "semicolon", arg: "a; rm -rf /"},
+	{name: "pipe", arg: "a | b && c"},
+	{name: "glob", arg: "*.log"},
+	{name: "question glob", arg: "file?.txt"},
+	{name: "brackets", arg: "[ab]"},
+	{name: "tilde", arg: "~/x"},
+	{name: "newline", arg: "a\nb"},
+	{name: "backslash", arg: `a\b`},
+	{name: "redirect", arg: "> /etc/passwd"},
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{arg: "abc", want: "abc"},
+		{arg: "a=b,c:d/e@f", want: "a=b,c:d/e@f"},
+		{arg: "", want: "''"},
+		{arg: "a b", want: "'a b'"},
+		{arg: "it's", want: `'it'"'"'s'`},
+		{arg: "$HOME", want: "'$HOME'"},
+		{arg: "a;b", want: "'a;b'"},
+		{arg: "*.log", want: "'*.log'"},
+		{arg: "a\nb", want: "'a\nb'"},
+	}
+	for _, test := range tests {
+		if got := shellQuote(test.arg); got != test.want {
+			t.Errorf("shellQuote(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+// TestShellQuoteRoundTrip checks that a POSIX shell evaluates the quoted args
+// back to the original args.
+func TestShellQuoteRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil || runtime.GOOS == "windows" {
+		t.Skip("no POSIX shell")
+	}
+
+	for _, test := range quoteArgs {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := exec.Command(sh, "-c", `printf '%s' `+shellQuote(test.arg)).Output()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.arg {
+				t.Errorf("shell evaluated %q to %q", shellQuote(test.arg), out)
+			}
+		})
+	}
+}
+
+func TestWindowsQuote(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{arg: "abc", want: "abc"},
+		{arg: "", want: `""`},
+		{arg: "a b", want: `"a b"`},
+		{arg: `say "hi"`, want: `"say \"hi\""`},
+		{arg: `a\b`, want: `a\b`},
+		{arg: `a b\`, want: `"a b\\"`},
+		{arg: `a\"b`, want: `"a\\\"b"`},
+		{arg: "a&b|c", want: `"a&b|c"`},
+		{arg: "%PATH%", want: `"%PATH%"`},
+	}
+	for _, test := range tests {
+		if got := windowsQuote(test.arg); got != test.want {
+			t.Errorf("windowsQuote(%q) = %q, want %q", test.arg, got, test.want)
+		}
+	}
+}
+
+func TestRemoteArgs(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "login shell",
+			opts: Options{},
+			want: nil,
+		},
+		{
+			name: "single arg script as is",
+			opts: Options{Args: []string{"ls *.log | wc -l; echo $HOME"}},
+			want: []string{"ls *.log | wc -l; echo $HOME"},
+		},
+		{
+			name: "multiple args quoted",
+			opts: Options{Args: []string{"echo", "a b", "$HOME", "a;b", "*.log", "it's", "a\nb"}},
+			want: []string{"echo", "'a b'", "'$HOME'", "'a;b'", "'*.log'", `'it'"'"'s'`, "'a\nb'"},
+		},
+		{
+			name: "sudo single arg script",
+			opts: Options{Sudo: "root", Args: []string{"ls *.log; id"}},
+			want: []string{"sudo", "-iu", "root", "--", "sh", "-c", "'ls *.log; id'"},
+		},
+		{
+			name: "sudo multiple args",
+			opts: Options{Sudo: "root", Args: []string{"echo", "a b", "$HOME"}},
+			want: []string{"sudo", "-iu", "root", "--", "echo", "'a b'", "'$HOME'"},
+		},
+		{
+			name: "sudo quoted user",
+			opts: Options{Sudo: "a b"},
+			want: []string{"sudo", "-iu", "'a b'"},
+		},
+		{
+			name: "tmux session quoted",
+			opts: Options{Tmux: "my session"},
+			want: []string{"tmux new-session -A -s 'my session'"},
+		},
+		{
+			name: "init with tmux",
+			opts: Options{Init: "cd /srv", Tmux: "gssh"},
+			want: []string{`tmux new-session -A -s gssh 'cd /srv; exec "$SHELL" -l'`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := remoteArgs(test.opts); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("remoteArgs() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+// TestRemoteArgsRoundTrip checks that the remote shell evaluates the multiple
+// args, joined by ssh with spaces, back to the original args.
+func TestRemoteArgsRoundTrip(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil || runtime.GOOS == "windows" {
+		t.Skip("no POSIX shell")
+	}
+
+	var args []string
+	for _, test := range quoteArgs {
+		args = append(args, test.arg)
+	}
+
+	cmdline := strings.Join(remoteArgs(Options{Args: append([]string{"printf", `%s\0`}, args...)}), " ")
+	out, err := exec.Command(sh, "-c", cmdline).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	if !reflect.DeepEqual(got, args) {
+		t.Errorf("shell evaluated %q to %q, want %q", cmdline, got, args)
+	}
+}
+
+func TestCommand(t *testing.T) {
+	inst := inventory.Instance{Name: "vm1", Zone: "us-central1-a", Project: "proj"}
+
+	tests := []struct {
+		name string
+		opts Options
+		want []string
+	}{
+		{
+			name: "gcloud multiple args",
+			opts: Options{User: "bob", Args: []string{"echo", "a b", "$HOME"}},
+			want: []string{inventory.GcloudBin, "compute", "ssh", "--zone=us-central1-a", "--project=proj", "bob@vm1", "--", "echo", "'a b'", "'$HOME'"},
+		},
+		{
+			name: "gcloud single arg script",
+			opts: Options{Args: []string{"ls | wc -l"}},
+			want: []string{inventory.GcloudBin, "compute", "ssh", "--zone=us-central1-a", "--project=proj", "vm1", "--", "ls | wc -l"},
+		},
+		{
+			name: "gcloud ssh flag",
+			opts: Options{SSHFlags: []string{"-o ConnectTimeout=5"}},
+			want: []string{inventory.GcloudBin, "compute", "ssh", "--zone=us-central1-a", "--project=proj", "--ssh-flag=-o", "--ssh-flag=ConnectTimeout=5", "vm1"},
+		},
+		{
+			name: "gcloud ssh flags",
+			opts: Options{Port: 2222, SSHFlags: []string{"-v", "-o 'User=bob'"}},
+			want: []string{inventory.GcloudBin, "compute", "ssh", "--zone=us-central1-a", "--project=proj",
+				"--ssh-flag=-p", "--ssh-flag=2222", "--ssh-flag=-v", "--ssh-flag=-o", "--ssh-flag=User=bob", "vm1"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Command(inst, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Command() = %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestGcloudFlagWithSpaces(t *testing.T) {
+	inst := inventory.Instance{Name: "vm1", Zone: "us-central1-a", Project: "proj"}
+	opts := Options{SSHFlags: []string{"-o 'ProxyCommand=nc %h 22'"}}
+
+	if got, err := Command(inst, opts); err == nil {
+		t.Errorf("Command() = %q, want error", got)
+	}
+	if got, err := CopyCommand(inst, opts, false, []string{"a", ":b"}); err == nil {
+		t.Errorf("CopyCommand() = %q, want error", got)
+	}
+}
+
+func TestCopyCommand(t *testing.T) {
+	inst := inventory.Instance{Name: "vm1", Zone: "us-central1-a", Project: "proj",
+		NetworkInterfaces: []inventory.NetworkInterface{{NetworkIP: "10.0.0.1"}}}
+
+	tests := []struct {
+		name string
+		opts Options
+		// wantTail are the last args, after the key and known_hosts flags if direct.
+		wantTail []string
+	}{
+		{
+			name:     "gcloud scp flags",
+			opts:     Options{Port: 2222, SSHFlags: []string{"-o ConnectTimeout=5"}},
+			wantTail: []string{"--scp-flag=-P", "--scp-flag=2222", "--scp-flag=-o", "--scp-flag=ConnectTimeout=5", "a", "vm1:b"},
+		},
+		{
+			name:     "direct flag with spaces",
+			opts:     Options{Direct: true, Address: "internal", Identity: "/tmp/key", SSHFlags: []string{"-o 'ProxyCommand=nc %h 22'"}},
+			wantTail: []string{"-o", "ProxyCommand=nc %h 22", "a", "10.0.0.1:b"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := CopyCommand(inst, test.opts, false, []string{"a", ":b"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) < len(test.wantTail) || !reflect.DeepEqual(got[len(got)-len(test.wantTail):], test.wantTail) {
+				t.Errorf("CopyCommand() = %q, want suffix %q", got, test.wantTail)
+			}
+		})
+	}
+}
+
+func TestDirectCommand(t *testing.T) {
+	inst := inventory.Instance{Name: "vm1", Zone: "us-central1-a", Project: "proj",
+		NetworkInterfaces: []inventory.NetworkInterface{{NetworkIP: "10.0.0.1"}}}
+
+	tests := []struct {
+		name string
+		opts Options
+		// wantTail are the last args, after the key and known_hosts flags.
+		wantTail []string
+	}{
+		{
+			name:     "multiple args",
+			opts:     Options{Args: []string{"echo", "a b", "$HOME", "a;b"}},
+			wantTail: []string{"10.0.0.1", "--", "echo", "'a b'", "'$HOME'", "'a;b'"},
+		},
+		{
+			name:     "single arg script",
+			opts:     Options{User: "bob", Args: []string{"ls *.log | wc -l"}},
+			wantTail: []string{"bob@10.0.0.1", "--", "ls *.log | wc -l"},
+		},
+		{
+			name:     "ssh flag with spaces",
+			opts:     Options{SSHFlags: []string{"-o 'ProxyCommand=nc -X 5 %h %p'"}},
+			wantTail: []string{"-o", "ProxyCommand=nc -X 5 %h %p", "10.0.0.1"},
+		},
+		{
+			name:     "container script",
+			opts:     Options{Container: "my app", Args: []string{"ps aux | grep x"}},
+			wantTail: []string{"-t", "10.0.0.1", "--", "sudo", "docker", "exec", "-it", "'my app'", "/bin/sh", "-c", "'ps aux | grep x'"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.opts.Direct, test.opts.Address, test.opts.Identity = true, "internal", "/tmp/key"
+			got, err := Command(inst, test.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) < len(test.wantTail) || !reflect.DeepEqual(got[len(got)-len(test.wantTail):], test.wantTail) {
+				t.Errorf("Command() = %q, want suffix %q", got, test.wantTail)
+			}
+		})
+	}
+}
+
+func TestSSHFlags(t *testing.T) {
+	tests := []struct {
+		flag string
+		want []string
+	}{
+		{flag: "-o ConnectTimeout=5", want: []string{"-o", "ConnectTimeout=5"}},
+		{flag: "-o 'ProxyCommand=a b'", want: []string{"-o", "ProxyCommand=a b"}},
+		{flag: `-o "ProxyCommand=nc -X 5 %h %p"`, want: []string{"-o", "ProxyCommand=nc -X 5 %h %p"}},
+		{flag: `-o ProxyCommand=a\ b`, want: []string{"-o", "ProxyCommand=a b"}},
+		{flag: "-v", want: []string{"-v"}},
+	}
+	for _, test := range tests {
+		got := sshFlags(Options{SSHFlags: []string{test.flag}})
+		if len(got) != 1 || !reflect.DeepEqual(got[0], test.want) {
+			t.Errorf("sshFlags(%q) = %q, want %q", test.flag, got, test.want)
+		}
+	}
+}
+
+func TestSplitWords(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: "", want: nil},
+		{s: "  a  b\tc\n", want: []string{"a", "b", "c"}},
+		{s: "'a b' c", want: []string{"a b", "c"}},
+		{s: `"a b" c`, want: []string{"a b", "c"}},
+		{s: `a\ b`, want: []string{"a b"}},
+		{s: `'$HOME' "$HOME"`, want: []string{"$HOME", "$HOME"}},
+		{s: `"say \"hi\""`, want: []string{`say "hi"`}},
+		{s: `"a\b"`, want: []string{`a\b`}},
+		{s: `'a\b'`, want: []string{`a\b`}},
+		{s: `x'y'"z"`, want: []string{"xyz"}},
+		{s: `''`, want: []string{""}},
+		{s: "a;b *.log", want: []string{"a;b", "*.log"}},
+		{s: "'a", wantErr: true},
+		{s: `"a`, wantErr: true},
+		{s: `a\`, wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := SplitWords(test.s)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("SplitWords(%q) = %q, want error", test.s, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("SplitWords(%q) error: %v", test.s, err)
+		} else if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("SplitWords(%q) = %q, want %q", test.s, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package sshrunner
+
+import (
+	"fmt"
+
+	"github.com/corverroos/gssh/inventory"
+)
+
+// tailscaleCommand returns the tailscale ssh command connecting to the tailnet
+// machine over Tailscale SSH. The ssh flags and remote args are passed through to ssh.
+func tailscaleCommand(inst inventory.Instance, opts Options) ([]string, error) {
+	if opts.Container != "" {
+		return nil, fmt.Errorf("containers are not supported via tailscale ssh, set tailscale_connect to ssh")
+	}
+
+	host := inst.Name
+	if opts.User != "" {
+		host = opts.User + "@" + host
+	}
+
+	cmds := []string{inventory.TailscaleBin, "ssh", host}
+	for _, flag := range sshFlags(opts) {
+		cmds = append(cmds, flag...)
+	}
+	if opts.Identity != "" {
+		cmds = append(cmds, "-i", opts.Identity)
+	}
+
+	return append(cmds, remoteArgs(opts)...), nil
+}
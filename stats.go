@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/corverroos/gssh/config"
+)
+
+// usageRecord is a locally recorded gssh invocation. It is anonymized: it
+// contains no VM, project, user or host names and no flag values or args.
+type usageRecord struct {
+	Day      string   `json:"day"`
+	Command  string   `json:"command"`
+	Flags    []string `json:"flags,omitempty"`
+	Kind     string   `json:"kind"`
+	Duration float64  `json:"duration_seconds"`
+}
+
+// recordUsage appends the invocation to the local usage log if the usage_stats
+// config is enabled. Nothing is ever sent over the network.
+func recordUsage(cmd string, fs *flag.FlagSet, err error, t0 time.Time) {
+	conf, cerr := config.Load()
+	if cerr != nil || !conf.UsageStats {
+		return
+	}
+
+	r := usageRecord{
+		Day:      t0.UTC().Format(time.DateOnly),
+		Command:  cmd,
+		Kind:     "ok",
+		Duration: time.Since(t0).Round(time.Millisecond).Seconds(),
+	}
+	fs.Visit(func(f *flag.Flag) {
+		r.Flags = append(r.Flags, f.Name)
+	})
+	if errors.As(err, new(sessionExit)) {
+		r.Kind = "session_exit"
+	} else if err != nil {
+		r.Kind = exitKinds[exitCode(err)]
+	}
+
+	if err := appendUsage(r); err != nil {
+		slog.Debug("Failed to record usage", "err", err)
+	}
+}
+
+// appendUsage appends the record to the usage log.
+func appendUsage(r usageRecord) error {
+	filename, err := cacheFile("usage.jsonl")
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("marshal usage error: %w", err)
+	}
+
+	f, err := os.OpenFile(filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("open usage log error: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write usage log error: %w", err)
+	}
+
+	return nil
+}
+
+// cacheFile returns the path of the file in the gssh cache directory, creating the directory if required.
+func cacheFile(name string) (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("cache dir error: %w", err)
+	}
+
+	dir = filepath.Join(dir, "gssh")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("create cache dir error: %w", err)
+	}
+
+	return filepath.Join(dir, name), nil
+}
+
+// installID returns the random ID of this installation, which distinguishes
+// the reports of team members without identifying them.
+func installID() (string, error) {
+	filename, err := cacheFile("install-id")
+	if err != nil {
+		return "", err
+	}
+
+	if b, err := os.ReadFile(filename); err == nil && len(b) > 0 {
+		return string(b), nil
+	}
+
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("random id error: %w", err)
+	}
+	id := hex.EncodeToString(b)
+
+	if err := os.WriteFile(filename, []byte(id), 0644); err != nil {
+		return "", fmt.Errorf("write install id error: %w", err)
+	}
+
+	return id, nil
+}
+
+// teamReport is the anonymized usage report aggregated by gssh stats -team-report.
+type teamReport struct {
+	InstallID   string         `json:"install_id"`
+	Version     string         `json:"version"`
+	Platform    string         `json:"platform"`
+	From        string         `json:"from,omitempty"`
+	To          string         `json:"to,omitempty"`
+	ActiveDays  int            `json:"active_days"`
+	Invocations int            `json:"invocations"`
+	Commands    map[string]int `json:"commands"`
+	Flags       map[string]int `json:"flags"`
+	Results     map[string]int `json:"results"`
+}
+
+// runStats prints the locally recorded usage, or the anonymized team report as JSON.
+func runStats(_ context.Context, fs *flag.FlagSet, args []string) error {
+	team := fs.Bool("team-report", false, "print an anonymized JSON report of command, flag and result counts to share with your platform team")
+	days := fs.Int("days", 30, "only include the usage of the last N days")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	conf, err := config.Load()
+	if err != nil {
+		return err
+	} else if !conf.UsageStats {
+		slog.Info("Usage stats are not recorded, enable them with `gssh config set usage_stats true`")
+	}
+
+	records, err := loadUsage(time.Now().UTC().AddDate(0, 0, -*days).Format(time.DateOnly))
+	if err != nil {
+		return err
+	}
+
+	report := teamReport{
+		Version:     buildVersion(),
+		Platform:    runtime.GOOS + "/" + runtime.GOARCH,
+		Invocations: len(records),
+		Commands:    make(map[string]int),
+		Flags:       make(map[string]int),
+		Results:     make(map[string]int),
+	}
+	activeDays := make(map[string]bool)
+	for _, r := range records {
+		activeDays[r.Day] = true
+		report.Commands[r.Command]++
+		report.Results[r.Kind]++
+		for _, f := range r.Flags {
+			report.Flags[f]++
+		}
+		if report.From == "" || r.Day < report.From {
+			report.From = r.Day
+		}
+		if r.Day > report.To {
+			report.To = r.Day
+		}
+	}
+	report.ActiveDays = len(activeDays)
+
+	if *team {
+		if report.InstallID, err = installID(); err != nil {
+			return err
+		}
+
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("marshal report error: %w", err)
+		}
+		fmt.Println(string(b))
+
+		return nil
+	}
+
+	fmt.Printf("%d invocations on %d days\n\n", report.Invocations, report.ActiveDays)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "COMMAND\tCOUNT")
+	for _, c := range sortedCounts(report.Commands) {
+		fmt.Fprintf(w, "%s\t%d\n", c, report.Commands[c])
+	}
+
+	return w.Flush()
+}
+
+// loadUsage returns the recorded usage since the day.
+func loadUsage(since string) ([]usageRecord, error) {
+	filename, err := cacheFile("usage.jsonl")
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("open usage log error: %w", err)
+	}
+	defer f.Close()
+
+	var records []usageRecord
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var r usageRecord
+		if err := json.Unmarshal(s.Bytes(), &r); err != nil || r.Day < since {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	return records, s.Err()
+}
+
+// sortedCounts returns the keys of the counts, most frequent first.
+func sortedCounts(counts map[string]int) []string {
+	var keys []string
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if counts[keys[i]] != counts[keys[j]] {
+			return counts[keys[i]] > counts[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+
+	return keys
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/selector"
+)
+
+// streamInterval is the minimum interval between updates of the selector while listing.
+const streamInterval = 500 * time.Millisecond
+
+// listStream collects the matching VMs while they are listed. Once more than
+// one VM matches, the selector is started and updated with the VMs found so far.
+type listStream struct {
+	filter *regexp.Regexp
+	gke    bool
+
+	// started is closed once the selector should be shown.
+	started chan struct{}
+	// updates are the sorted VMs found so far, closed once listing is complete.
+	updates chan []inventory.Instance
+
+	mu      sync.Mutex
+	prog    *progress
+	seen    map[string]bool
+	matched []inventory.Instance
+	changed bool
+	done    bool
+}
+
+// newListStream returns a listStream of the VMs matching the filter.
+func newListStream(filter *regexp.Regexp, gke bool) *listStream {
+	return &listStream{
+		filter:  filter,
+		gke:     gke,
+		started: make(chan struct{}),
+		updates: make(chan []inventory.Instance, 1),
+		seen:    make(map[string]bool),
+	}
+}
+
+// listing returns the found function of a listing, recording its progress.
+func (s *listStream) listing(prog *progress) func(inventory.Instance) {
+	s.mu.Lock()
+	s.prog = prog
+	s.mu.Unlock()
+
+	return func(inst inventory.Instance) {
+		prog.Found(inst)
+		s.found(inst)
+	}
+}
+
+// found records the VM if it matches. It is safe for concurrent use.
+func (s *listStream) found(inst inventory.Instance) {
+	matches := inventory.Filter([]inventory.Instance{inst}, s.filter)
+	if s.gke {
+		matches = inventory.GKENodes(matches)
+	}
+	if len(matches) == 0 {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Retries list the same VMs again.
+	key := inst.Project + "/" + inst.Zone + "/" + inst.Name
+	if s.done || s.seen[key] {
+		return
+	}
+	s.seen[key] = true
+	s.matched = append(s.matched, inst)
+	s.changed = true
+
+	if len(s.matched) == 2 {
+		s.prog.Hide()
+		close(s.started)
+		go s.publish()
+	}
+}
+
+// publish sends the VMs found so far to the selector until listing is done.
+func (s *listStream) publish() {
+	ticker := time.NewTicker(streamInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		s.mu.Lock()
+		if s.done {
+			s.mu.Unlock()
+			return
+		}
+		if s.changed {
+			s.send(inventory.Sort(append([]inventory.Instance(nil), s.matched...)))
+			s.changed = false
+		}
+		s.mu.Unlock()
+	}
+}
+
+// send replaces the pending update, if any, with the instances. It must be called with mu held.
+func (s *listStream) send(instances []inventory.Instance) {
+	select {
+	case <-s.updates:
+	default:
+	}
+	s.updates <- instances
+}
+
+// finish sends the complete listing to the selector, if started.
+func (s *listStream) finish(instances []inventory.Instance) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.done {
+		return
+	}
+	s.done = true
+
+	if len(s.matched) >= 2 {
+		s.send(instances)
+		close(s.updates)
+	}
+}
+
+// abort stops recording VMs, e.g. if listing failed.
+func (s *listStream) abort() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.done = true
+}
+
+// streamSelect returns true if the selector can be shown while listing, i.e.
+// if the user selects the VM in a terminal from the plain list of matching VMs.
+func streamSelect(opts options) bool {
+	return opts.host == "" && !opts.latency && !opts.check && !opts.usePrev && opts.mig == "" && !opts.pickMIG &&
+		readline.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// listAndSelect lists the VMs and shows the selector as soon as more than one
+// VM matches, updating it while the remaining VMs arrive. It returns true and
+// the selected VM if selected while listing, the listing is then cancelled and
+// only contains the VMs found so far. If listing completes before the selector
+// is shown, it returns the listing without selecting.
+func listAndSelect(ctx context.Context, opts options) (listing, inventory.Instance, bool, error) {
+	filter, err := regexp.Compile(opts.filter)
+	if err != nil {
+		l, err := listVMs(ctx, opts) // Returns the error.
+		return l, inventory.Instance{}, false, err
+	}
+	conf, err := config.Load()
+	if err != nil {
+		l, err := listVMs(ctx, opts) // Returns the error.
+		return l, inventory.Instance{}, false, err
+	}
+
+	listCtx, cancelList := context.WithCancel(ctx)
+	defer cancelList()
+
+	s := newListStream(filter, opts.gke)
+	opts.stream = s
+
+	type result struct {
+		l   listing
+		err error
+	}
+	resc := make(chan result, 1)
+	go func() {
+		l, err := listVMs(listCtx, opts)
+		if err != nil {
+			s.abort()
+		} else {
+			s.finish(l.instances)
+		}
+		resc <- result{l: l, err: err}
+	}()
+
+	var res result
+	select {
+	case res = <-resc:
+		return res.l, inventory.Instance{}, false, res.err
+	case <-s.started:
+	}
+
+	selCtx, cancelSel := context.WithCancel(ctx)
+	defer cancelSel()
+
+	// The selector shows the VMs found so far, the rest arrive as updates.
+	s.mu.Lock()
+	snapshot := inventory.Sort(append([]inventory.Instance(nil), s.matched...))
+	s.changed = false
+	s.mu.Unlock()
+
+	type selection struct {
+		inst inventory.Instance
+		err  error
+	}
+	selc := make(chan selection, 1)
+	go func() {
+		inst, err := selector.Select(selCtx, snapshot, selector.Options{
+			Previous:    conf.Previous,
+			ShowProject: len(opts.projects) > 1 || (opts.allProjects && len(conf.Projects) > 1) || opts.scope != "",
+			ShowCost:    opts.cost,
+			Keys:        selectorKeys(opts),
+			Updates:     s.updates,
+		})
+		selc <- selection{inst: inst, err: err}
+	}()
+
+	var sel selection
+	select {
+	case res = <-resc:
+		if res.err != nil {
+			cancelSel()
+			<-selc
+			return listing{}, inventory.Instance{}, false, res.err
+		}
+		sel = <-selc
+	case sel = <-selc:
+		cancelList()
+		res = <-resc
+	}
+	if sel.err != nil {
+		return listing{}, inventory.Instance{}, false, fmt.Errorf("select instance error: %w", sel.err)
+	}
+	opts.timing.Phase("select")
+
+	if res.err == nil {
+		return res.l, sel.inst, true, nil
+	}
+	// Listing was cancelled or failed after selecting, which doesn't affect the selected VM.
+	slog.Debug("Listing incomplete after selecting VM", "err", res.err)
+
+	s.mu.Lock()
+	found := inventory.Sort(append([]inventory.Instance(nil), s.matched...))
+	s.mu.Unlock()
+
+	return listing{conf: conf, projects: projectsOf(found), filter: opts.filter, cacheAge: "none", instances: found}, sel.inst, true, nil
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/selector"
+	"github.com/corverroos/gssh/sshrunner"
+)
+
+// Actions offered for the selected systemd unit.
+const (
+	unitStatus  = "status"
+	unitRestart = "restart"
+	unitJournal = "journal"
+	unitBack    = "back to units"
+	unitQuit    = "quit"
+)
+
+// runSystemd lists the failed systemd units of the selected VM, or the service
+// units matching the pattern, and runs the status, restart and journal actions
+// selected by the user on them.
+func runSystemd(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	opts := addSelectFlags(fs)
+	all := fs.Bool("all", false, "list all service units instead of only the failed ones")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+
+	selected, conf, err := selectVM(ctx, *opts)
+	if err != nil {
+		return err
+	}
+
+	var sshOpts sshrunner.Options
+	if err := prepareSSH(ctx, *opts, conf, selected, &sshOpts); err != nil {
+		return err
+	}
+
+	list := []string{"systemctl", "list-units", "--no-legend", "--plain", "--no-pager"}
+	if fs.NArg() == 1 {
+		list = append(list, "--all", "--type=service", fs.Arg(0))
+	} else if *all {
+		list = append(list, "--all", "--type=service")
+	} else {
+		list = append(list, "--failed")
+	}
+
+	for {
+		output, err := remoteOutput(ctx, *opts, selected, sshOpts, list...)
+		if err != nil {
+			return fmt.Errorf("list units error: %w", err)
+		}
+
+		var units, items []string
+		for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+			// UNIT LOAD ACTIVE SUB DESCRIPTION
+			fields := strings.Fields(line)
+			if len(fields) < 4 {
+				continue
+			}
+			units = append(units, fields[0])
+			items = append(items, fmt.Sprintf("%-40s %-10s %-10s %s", fields[0], fields[2], fields[3], strings.Join(fields[4:], " ")))
+		}
+		if len(units) == 0 {
+			slog.Info("No matching units", "vm", selected.Name, "failed_only", fs.NArg() == 0 && !*all)
+			return nil
+		}
+
+		item, err := selector.SelectItem(ctx, "Select unit", items, "")
+		if err != nil {
+			return fmt.Errorf("select unit error: %w", err)
+		}
+
+		if quit, err := unitActions(ctx, *opts, selected, sshOpts, units[slices.Index(items, item)]); err != nil || quit {
+			return err
+		}
+	}
+}
+
+// unitActions prompts for actions on the unit until the user goes back to the
+// units, or quits in which case it returns true.
+func unitActions(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options, unit string) (bool, error) {
+	for {
+		action, err := selector.SelectItem(ctx, "Action for "+unit, []string{unitStatus, unitRestart, unitJournal, unitBack, unitQuit}, "")
+		if err != nil {
+			return false, fmt.Errorf("select action error: %w", err)
+		}
+
+		var args []string
+		switch action {
+		case unitStatus:
+			args = []string{"systemctl", "status", unit}
+		case unitRestart:
+			args = []string{"sudo", "systemctl", "restart", unit}
+		case unitJournal:
+			// Jump to the end of the journal in the remote pager.
+			args = []string{"sudo", "journalctl", "-u", unit, "-e"}
+		case unitBack:
+			return false, nil
+		case unitQuit:
+			return true, nil
+		}
+
+		if err := runRemote(ctx, opts, inst, sshOpts, args...); ctx.Err() != nil {
+			return false, ctx.Err()
+		} else if err != nil {
+			// E.g. systemctl status exits with 3 for inactive units.
+			slog.Warn("Remote command failed", "cmd", strings.Join(args, " "), "err", err)
+		} else if action == unitRestart {
+			slog.Info("Restarted unit", "unit", unit)
+		}
+	}
+}
+
+// runRemote runs the command on the VM in a pseudo-terminal, e.g. for remote pagers.
+func runRemote(ctx context.Context, opts options, inst inventory.Instance, sshOpts sshrunner.Options, args ...string) error {
+	sshOpts.PortFwds, sshOpts.NoShell, sshOpts.Serial, sshOpts.Container = nil, false, false, ""
+	sshOpts.Args, sshOpts.TTY = args, "true"
+	cmds, err := sshrunner.Command(inst, sshOpts)
+	if err != nil {
+		return err
+	}
+
+	slog.Debug("Executing", "cmd", sshrunner.Quote(cmds))
+
+	return sshrunner.Run(ctx, opts.runner, cmds)
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"log/slog"
+	"time"
+)
+
+// timing records the latency of each phase of a gssh invocation.
+// A nil timing is valid and records nothing.
+type timing struct {
+	start  time.Time
+	last   time.Time
+	phases []any
+}
+
+func newTiming() *timing {
+	now := time.Now()
+	return &timing{start: now, last: now}
+}
+
+// Phase records the duration since the previous phase.
+func (t *timing) Phase(name string) {
+	if t == nil {
+		return
+	}
+
+	now := time.Now()
+	t.phases = append(t.phases, name, now.Sub(t.last).Round(time.Millisecond))
+	t.last = now
+}
+
+// Print prints the recorded phases and the total duration.
+func (t *timing) Print() {
+	if t == nil {
+		return
+	}
+
+	slog.Info("Timing", append(t.phases, "total", time.Since(t.start).Round(time.Millisecond))...)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/template"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+	"github.com/corverroos/gssh/inventory"
+	"github.com/corverroos/gssh/runner"
+)
+
+// defaultTitle is the default terminal title template.
+const defaultTitle = "{{if .User}}{{.User}}@{{end}}{{.Name}}{{if .Project}} ({{.Project}}){{end}}"
+
+// titleData are the fields of the terminal title template.
+type titleData struct {
+	User    string
+	Name    string
+	Zone    string
+	Project string
+}
+
+// setTitle sets the terminal (and tmux pane) title of the session to the VM and
+// returns a function restoring it. The title is configured by the title config
+// template, "off" disables it.
+func setTitle(ctx context.Context, r runner.Runner, conf config.Config, inst inventory.Instance, user string) func() {
+	if conf.Title == "off" || !readline.IsTerminal(int(os.Stderr.Fd())) {
+		return func() {}
+	}
+
+	text := conf.Title
+	if text == "" {
+		text = defaultTitle
+	}
+
+	tmpl, err := template.New("title").Parse(text)
+	if err != nil {
+		return func() {} // Validated by config set.
+	}
+
+	var b bytes.Buffer
+	_ = tmpl.Execute(&b, titleData{User: user, Name: inst.Name, Zone: inst.TrimZone(), Project: inst.Project})
+
+	// Tmux has no title stack, so remember the pane title.
+	var paneTitle string
+	if os.Getenv("TMUX") != "" {
+		out, err := runner.Output(ctx, r, runner.Cmd{Name: "tmux", Args: []string{"display-message", "-p", "#{pane_title}"}})
+		if err == nil {
+			paneTitle = strings.TrimSpace(string(out))
+		}
+	}
+
+	// Push the current title on the xterm title stack, then set it.
+	fmt.Fprintf(os.Stderr, "\033[22;0t\033]0;%s\007", b.String())
+
+	return func() {
+		if paneTitle != "" {
+			fmt.Fprintf(os.Stderr, "\033]2;%s\007", paneTitle)
+		}
+		fmt.Fprint(os.Stderr, "\033[23;0t")
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// latestReleaseURL is the GitHub API endpoint of the latest gssh release.
+const latestReleaseURL = "https://api.github.com/repos/corverroos/gssh/releases/latest"
+
+// checksumsAsset is the release asset with the sha256sum output of the binaries.
+const checksumsAsset = "checksums.txt"
+
+// signatureAsset is the release asset with the minisign signature of the checksums.
+const signatureAsset = checksumsAsset + ".minisig"
+
+// releaseKey is the minisign public key verifying the signature of release
+// checksums, embedded by release builds via ldflags, e.g.
+//
+//	go build -ldflags "-X main.releaseKey=RWQ..."
+//
+// Binaries without it refuse to update themselves.
+var releaseKey string
+
+// maxAssetSize limits the size of downloaded release assets.
+const maxAssetSize = 100 << 20
+
+// release is a GitHub release.
+type release struct {
+	TagName string `json:"tag_name"`
+	HTMLURL string `json:"html_url"`
+	Assets  []struct {
+		Name string `json:"name"`
+		URL  string `json:"browser_download_url"`
+	} `json:"assets"`
+}
+
+// assetURL returns the download URL of the named release asset.
+func (r release) assetURL(name string) (string, bool) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, true
+		}
+	}
+
+	return "", false
+}
+
+// runUpdate replaces the running binary with that of the latest GitHub release
+// if it is newer, after verifying the signature of the checksums and its sha256 checksum.
+func runUpdate(ctx context.Context, fs *flag.FlagSet, args []string) error {
+	checkOnly := fs.Bool("check-only", false, "only print whether a newer release is available")
+	timeout := fs.Duration("timeout", 5*time.Minute, "max duration of checking and downloading the release")
+	parseFlags(fs, args)
+
+	if fs.NArg() > 0 {
+		return errUsage
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	rel, err := latestRelease(ctx)
+	if err != nil {
+		return err
+	}
+
+	current := buildVersion()
+	if !newerVersion(rel.TagName, current) {
+		fmt.Printf("gssh %s is up to date\n", current)
+		return nil
+	} else if *checkOnly {
+		fmt.Printf("gssh %s is available (current %s): %s\n", rel.TagName, current, rel.HTMLURL)
+		return nil
+	}
+
+	if releaseKey == "" {
+		return fmt.Errorf("this build has no release signing key to verify updates with, download gssh %s from %s", rel.TagName, rel.HTMLURL)
+	}
+
+	name := binaryAsset()
+	binURL, ok := rel.assetURL(name)
+	if !ok {
+		return fmt.Errorf("release %s has no binary %s, download it from %s", rel.TagName, name, rel.HTMLURL)
+	}
+	sumsURL, ok := rel.assetURL(checksumsAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s, refusing to install an unverified binary", rel.TagName, checksumsAsset)
+	}
+
+	sigURL, ok := rel.assetURL(signatureAsset)
+	if !ok {
+		return fmt.Errorf("release %s has no %s, refusing to install an unverified binary", rel.TagName, signatureAsset)
+	}
+
+	sums, err := download(ctx, sumsURL)
+	if err != nil {
+		return err
+	}
+	sig, err := download(ctx, sigURL)
+	if err != nil {
+		return err
+	}
+	if err := verifyMinisign(releaseKey, sums, sig); err != nil {
+		return fmt.Errorf("verify %s of release %s error, refusing to install it: %w", checksumsAsset, rel.TagName, err)
+	}
+
+	want, ok := findChecksum(sums, name)
+	if !ok {
+		return fmt.Errorf("no checksum of %s in %s", name, checksumsAsset)
+	}
+
+	slog.Info("Downloading", "release", rel.TagName, "asset", name)
+
+	bin, err := download(ctx, binURL)
+	if err != nil {
+		return err
+	}
+	if got := sha256.Sum256(bin); hex.EncodeToString(got[:]) != want {
+		return fmt.Errorf("checksum mismatch of %s, expected %s", name, want)
+	}
+
+	exe, err := replaceExecutable(bin)
+	if err != nil {
+		return err
+	}
+
+	slog.Info("Updated gssh", "from", current, "to", rel.TagName, "path", exe)
+
+	return nil
+}
+
+// latestRelease returns the latest GitHub release.
+func latestRelease(ctx context.Context) (release, error) {
+	b, err := download(ctx, latestReleaseURL)
+	if err != nil {
+		return release{}, err
+	}
+
+	var rel release
+	if err := json.Unmarshal(b, &rel); err != nil {
+		return release{}, fmt.Errorf("unmarshal release error: %w", err)
+	}
+
+	return rel, nil
+}
+
+// download returns the body of the URL.
+func download(ctx context.Context, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, fmt.Errorf("new request error: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("download error: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("download %s failed: %s", u, resp.Status)
+	}
+
+	b, err := io.ReadAll(io.LimitReader(resp.Body, maxAssetSize))
+	if err != nil {
+		return nil, fmt.Errorf("read %s error: %w", u, err)
+	}
+
+	return b, nil
+}
+
+// binaryAsset returns the name of the release binary of this platform, e.g. gssh-linux-amd64.
+func binaryAsset() string {
+	name := "gssh-" + runtime.GOOS + "-" + runtime.GOARCH
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+
+	return name
+}
+
+// findChecksum returns the hex sha256 checksum of the file in the sha256sum output.
+func findChecksum(sums []byte, file string) (string, bool) {
+	s := bufio.NewScanner(bytes.NewReader(sums))
+	for s.Scan() {
+		// Binary mode prefixes the file name with '*'.
+		fields := strings.Fields(s.Text())
+		if len(fields) != 2 || strings.TrimPrefix(fields[1], "*") != file {
+			continue
+		}
+		if sum, err := hex.DecodeString(fields[0]); err == nil && len(sum) == sha256.Size {
+			return strings.ToLower(fields[0]), true
+		}
+	}
+
+	return "", false
+}
+
+// verifyMinisign returns an error if the minisign signature file of the data
+// isn't valid for the base64 encoded minisign public key. Both legacy and
+// prehashed (BLAKE2b-512) signatures are supported.
+func verifyMinisign(publicKey string, data, sigFile []byte) error {
+	pk, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(pk) != 2+8+ed25519.PublicKeySize || string(pk[:2]) != "Ed" {
+		return errors.New("invalid minisign public key")
+	}
+	keyID, key := pk[2:10], ed25519.PublicKey(pk[10:])
+
+	// The lines are an untrusted comment, the signature, a trusted comment and the global signature.
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(sigFile), "\r\n", "\n")), "\n")
+	if len(lines) != 4 {
+		return errors.New("invalid minisign signature file")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(sig) != 2+8+ed25519.SignatureSize {
+		return errors.New("invalid minisign signature")
+	} else if !bytes.Equal(sig[2:10], keyID) {
+		return fmt.Errorf("signed by unknown key %X", sig[2:10])
+	}
+
+	msg := data
+	switch string(sig[:2]) {
+	case "Ed":
+	case "ED":
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", sig[:2])
+	}
+	if !ed25519.Verify(key, msg, sig[10:]) {
+		return errors.New("invalid signature")
+	}
+
+	// The global signature covers the trusted comment, e.g. the signed file name and timestamp.
+	comment, ok := strings.CutPrefix(lines[2], "trusted comment: ")
+	if !ok {
+		return errors.New("invalid minisign trusted comment")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || !ed25519.Verify(key, append(bytes.Clone(sig[10:]), comment...), global) {
+		return errors.New("invalid trusted comment signature")
+	}
+
+	return nil
+}
+
+// replaceExecutable atomically replaces the running binary with bin and returns its path.
+func replaceExecutable(bin []byte) (string, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("executable path error: %w", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return "", fmt.Errorf("executable path error: %w", err)
+	}
+
+	info, err := os.Stat(exe)
+	if err != nil {
+		return "", fmt.Errorf("stat executable error: %w", err)
+	}
+
+	// Write to the same directory so that the rename is atomic.
+	f, err := os.CreateTemp(filepath.Dir(exe), ".gssh-update-*")
+	if err != nil {
+		return "", fmt.Errorf("create temp file error, install to a writable directory or rerun with sudo: %w", err)
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(bin); err != nil {
+		_ = f.Close()
+		return "", fmt.Errorf("write update error: %w", err)
+	} else if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write update error: %w", err)
+	} else if err := os.Chmod(f.Name(), info.Mode().Perm()); err != nil {
+		return "", fmt.Errorf("chmod update error: %w", err)
+	}
+
+	if runtime.GOOS == "windows" {
+		// The running binary cannot be replaced, but it can be renamed.
+		old := exe + ".old"
+		_ = os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			return "", fmt.Errorf("rename executable error: %w", err)
+		}
+	}
+
+	if err := os.Rename(f.Name(), exe); err != nil {
+		return "", fmt.Errorf("replace executable error: %w", err)
+	}
+
+	return exe, nil
+}
+
+// newerVersion returns true if the latest vX.Y.Z version is newer than the
+// current one. Development builds are always older.
+func newerVersion(latest, current string) bool {
+	parse := func(v string) []int {
+		v, _, _ = strings.Cut(strings.TrimPrefix(v, "v"), "-")
+		var nums []int
+		for _, s := range strings.Split(v, ".") {
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil
+			}
+			nums = append(nums, n)
+		}
+		return nums
+	}
+
+	l, c := parse(latest), parse(current)
+	if c == nil {
+		return l != nil
+	}
+
+	for i := 0; i < max(len(l), len(c)); i++ {
+		var x, y int
+		if i < len(l) {
+			x = l[i]
+		}
+		if i < len(c) {
+			y = c[i]
+		}
+		if x != y {
+			return x > y
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// testKeyID is the minisign key ID of the test keys.
+var testKeyID = []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+// minisignKey returns a test key pair and the base64 encoded minisign public key.
+func minisignKey(t *testing.T, seed byte) (ed25519.PrivateKey, string) {
+	t.Helper()
+
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{seed}, ed25519.SeedSize))
+	pk := append(append([]byte("Ed"), testKeyID...), priv.Public().(ed25519.PublicKey)...)
+
+	return priv, base64.StdEncoding.EncodeToString(pk)
+}
+
+// minisignSign returns the minisign signature file of the data, prehashed
+// with BLAKE2b-512 if alg is "ED".
+func minisignSign(priv ed25519.PrivateKey, keyID []byte, alg string, data []byte, comment string) []byte {
+	msg := data
+	if alg == "ED" {
+		sum := blake2b.Sum512(data)
+		msg = sum[:]
+	}
+
+	sig := ed25519.Sign(priv, msg)
+	global := ed25519.Sign(priv, append(bytes.Clone(sig), comment...))
+
+	return []byte("untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(append(append([]byte(alg), keyID...), sig...)) + "\n" +
+		"trusted comment: " + comment + "\n" +
+		base64.StdEncoding.EncodeToString(global) + "\n")
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	priv, pub := minisignKey(t, 1)
+	other, _ := minisignKey(t, 2)
+	data := []byte("abc123  gssh-linux-amd64\n")
+	const comment = "timestamp:1700000000\tfile:checksums.txt"
+
+	tests := []struct {
+		name    string
+		data    []byte
+		sig     []byte
+		wantErr string
+	}{
+		{
+			name: "legacy",
+			data: data,
+			sig:  minisignSign(priv, testKeyID, "Ed", data, comment),
+		},
+		{
+			name: "prehashed",
+			data: data,
+			sig:  minisignSign(priv, testKeyID, "ED", data, comment),
+		},
+		{
+			name: "crlf line endings",
+			data: data,
+			sig:  bytes.ReplaceAll(minisignSign(priv, testKeyID, "ED", data, comment), []byte("\n"), []byte("\r\n")),
+		},
+		{
+			name:    "tampered data",
+			data:    []byte("evil123  gssh-linux-amd64\n"),
+			sig:     minisignSign(priv, testKeyID, "ED", data, comment),
+			wantErr: "invalid signature",
+		},
+		{
+			name:    "wrong key ID",
+			data:    data,
+			sig:     minisignSign(priv, []byte{8, 7, 6, 5, 4, 3, 2, 1}, "ED", data, comment),
+			wantErr: "signed by unknown key 0807060504030201",
+		},
+		{
+			name:    "wrong key",
+			data:    data,
+			sig:     minisignSign(other, testKeyID, "ED", data, comment),
+			wantErr: "invalid signature",
+		},
+		{
+			name: "tampered trusted comment",
+			data: data,
+			sig: bytes.Replace(minisignSign(priv, testKeyID, "ED", data, comment),
+				[]byte("file:checksums.txt"), []byte("file:other.txt"), 1),
+			wantErr: "invalid trusted comment signature",
+		},
+		{
+			name:    "unsupported algorithm",
+			data:    data,
+			sig:     minisignSign(priv, testKeyID, "XX", data, comment),
+			wantErr: "unsupported minisign signature algorithm",
+		},
+		{
+			name:    "truncated",
+			data:    data,
+			sig:     []byte("untrusted comment: x\nRWQ=\n"),
+			wantErr: "invalid minisign signature file",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := verifyMinisign(pub, test.data, test.sig)
+			if test.wantErr == "" && err != nil {
+				t.Errorf("verifyMinisign() error: %v", err)
+			} else if test.wantErr != "" && (err == nil || !strings.Contains(err.Error(), test.wantErr)) {
+				t.Errorf("verifyMinisign() error = %v, want %q", err, test.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyMinisignInvalidKey(t *testing.T) {
+	priv, _ := minisignKey(t, 1)
+	sig := minisignSign(priv, testKeyID, "ED", []byte("x"), "c")
+
+	for _, key := range []string{"", "not base64!", base64.StdEncoding.EncodeToString([]byte("Ed12345678"))} {
+		if err := verifyMinisign(key, []byte("x"), sig); err == nil {
+			t.Errorf("verifyMinisign(%q) = nil, want error", key)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	tests := []struct {
+		latest  string
+		current string
+		want    bool
+	}{
+		{latest: "v1.2.3", current: "v1.2.3", want: false},
+		{latest: "v1.2.4", current: "v1.2.3", want: true},
+		{latest: "v1.10.0", current: "v1.9.9", want: true},
+		{latest: "v1.2.3", current: "v1.10.0", want: false},
+		{latest: "v2.0.0", current: "v1.99.99", want: true},
+		{latest: "v1.2", current: "v1.2.0", want: false},
+		{latest: "v1.2.1", current: "v1.2", want: true},
+		{latest: "v1.2.3", current: "1.2.3", want: false},
+		{latest: "v1.2.3", current: "v1.2.3-rc1", want: false},
+		{latest: "v1.2.3", current: "dev", want: true},
+		{latest: "v1.2.3", current: "(devel)", want: true},
+		{latest: "garbage", current: "dev", want: false},
+	}
+	for _, test := range tests {
+		if got := newerVersion(test.latest, test.current); got != test.want {
+			t.Errorf("newerVersion(%q, %q) = %v, want %v", test.latest, test.current, got, test.want)
+		}
+	}
+}
+
+func TestFindChecksum(t *testing.T) {
+	var (
+		a = strings.Repeat("a", 64)
+		b = strings.Repeat("b", 64)
+		c = strings.Repeat("c", 64)
+	)
+	sums := []byte(strings.ToUpper(a) + "  gssh-linux-amd64\n" +
+		b + " *gssh-windows-amd64.exe\n" +
+		c + "  gssh-linux-amd64.sig\n" +
+		"malformed line\n" +
+		"abcd  gssh-darwin-amd64\n")
+
+	tests := []struct {
+		file   string
+		want   string
+		wantOK bool
+	}{
+		{file: "gssh-linux-amd64", want: a, wantOK: true},
+		{file: "gssh-windows-amd64.exe", want: b, wantOK: true},
+		{file: "gssh-linux-amd64.sig", want: c, wantOK: true},
+		{file: "gssh-linux", wantOK: false},
+		{file: "line", wantOK: false},
+		{file: "gssh-darwin-amd64", wantOK: false},
+		{file: "gssh-darwin-arm64", wantOK: false},
+	}
+	for _, test := range tests {
+		got, ok := findChecksum(sums, test.file)
+		if got != test.want || ok != test.wantOK {
+			t.Errorf("findChecksum(%q) = %q, %v, want %q, %v", test.file, got, ok, test.want, test.wantOK)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"time"
+
+	"github.com/chzyer/readline"
+	"github.com/corverroos/gssh/config"
+)
+
+// Build info set via ldflags, e.g.
+//
+//	go build -ldflags "-X main.version=v1.2.3 -X main.commit=$(git rev-parse HEAD) -X main.date=$(date -u +%FT%TZ)"
+//
+// If empty, they default to the module version and VCS info embedded by go build.
+var (
+	version string
+	commit  string
+	date    string
+)
+
+// buildVersion returns the version of the running binary.
+func buildVersion() string {
+	if version != "" {
+		return version
+	} else if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+
+	return "(devel)"
+}
+
+// buildInfo returns the version, commit and build date of the running binary.
+func buildInfo() string {
+	c, d := commit, date
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" && c == "" {
+				c = s.Value
+			} else if s.Key == "vcs.time" && d == "" {
+				d = s.Value
+			}
+		}
+	}
+
+	return fmt.Sprintf("gssh %s (commit %s, built %s, %s %s/%s)",
+		buildVersion(), withDefault(c, "unknown"), withDefault(d, "unknown"), runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// releaseCheckInterval is the min interval between checks for a new release by the update notice.
+const releaseCheckInterval = 24 * time.Hour
+
+// releaseCheck is the cached result of the last check for a new release.
+type releaseCheck struct {
+	Checked time.Time `json:"checked"`
+	Latest  string    `json:"latest"`
+}
+
+// updateNotice logs a notice if the cached latest release is newer than the
+// running binary and refreshes the cache in the background at most once per
+// day. It is opt-in via the update_notice config.
+func updateNotice(ctx context.Context) {
+	conf, err := config.Load()
+	if err != nil || !conf.UpdateNotice || !readline.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+
+	filename, err := cacheFile("release.json")
+	if err != nil {
+		return
+	}
+
+	var last releaseCheck
+	if b, err := os.ReadFile(filename); err == nil {
+		_ = json.Unmarshal(b, &last)
+	}
+
+	if current := buildVersion(); last.Latest != "" && current != "(devel)" && newerVersion(last.Latest, current) {
+		slog.Info("A new gssh release is available, run `gssh update`", "current", current, "latest", last.Latest)
+	}
+
+	if time.Since(last.Checked) < releaseCheckInterval {
+		return
+	}
+
+	// The result is only used by the next invocation, so don't delay this one.
+	go func() {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+		defer cancel()
+
+		rel, err := latestRelease(ctx)
+		if err != nil {
+			slog.Debug("Failed to check for a new release", "err", err)
+			return
+		}
+
+		b, err := json.Marshal(releaseCheck{Checked: time.Now(), Latest: rel.TagName})
+		if err != nil {
+			return
+		}
+		if err := os.WriteFile(filename, b, 0644); err != nil {
+			slog.Debug("Failed to store release check", "err", err)
+		}
+	}()
+}